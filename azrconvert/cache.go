@@ -0,0 +1,235 @@
+package azrconvert
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CacheMode determines how a Cache uses the network.
+type CacheMode int
+
+const (
+	// CacheRevalidate uses cached files after checking with the
+	// server that they are still current (using ETag and
+	// Last-Modified). This is the default.
+	CacheRevalidate CacheMode = iota
+
+	// CacheOffline only uses cached files and never accesses the
+	// network.
+	CacheOffline
+
+	// CacheRefresh ignores cached files and downloads everything
+	// again, updating the cache.
+	CacheRefresh
+)
+
+// Cache is a Fetcher that keeps the files it downloads over HTTP
+// in a directory so that converting the same book again does not
+// download everything again. Entries are keyed by URL.
+type Cache struct {
+	Dir    string
+	Mode   CacheMode
+	Client *http.Client
+}
+
+type cacheEntry struct {
+	URL          string
+	ETag         string
+	LastModified string
+	Fetched      time.Time
+}
+
+// NewCache returns a Cache using the directory dir. If dir is
+// empty, azrconvert inside the user's cache directory is used.
+func NewCache(dir string, mode CacheMode) (*Cache, error) {
+
+	if dir == "" {
+		d, err := os.UserCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = filepath.Join(d, "azrconvert")
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cache{Dir: dir, Mode: mode}, nil
+}
+
+// Fetch implements Fetcher. location must be an absolute URL.
+func (c *Cache) Fetch(location string) ([]byte, error) {
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.IsAbs() {
+		return nil, errors.New(location + ": not an absolute URL")
+	}
+
+	location = u.String()
+
+	entry, data, cached := c.load(location)
+
+	switch {
+
+	case c.Mode == CacheOffline && cached:
+		log.Println("Using cached", location)
+		return data, nil
+
+	case c.Mode == CacheOffline:
+		return nil, &url.Error{Op: "Get", URL: location, Err: ErrNotCached}
+
+	case c.Mode == CacheRefresh:
+		cached = false
+	}
+
+	req, err := http.NewRequest(http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if cached {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	r, err := client.Do(req)
+	if err != nil {
+		if cached {
+			log.Println(err, "Using cached", location)
+			return data, nil
+		}
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	switch {
+
+	case r.StatusCode == http.StatusNotModified && cached:
+		log.Println("Not modified, using cached", location)
+		return data, nil
+
+	case r.StatusCode != http.StatusOK:
+		return nil, errors.New(location + ": " + r.Status)
+	}
+
+	data, err = io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Downloaded", location)
+
+	entry = cacheEntry{
+		URL:          location,
+		ETag:         r.Header.Get("ETag"),
+		LastModified: r.Header.Get("Last-Modified"),
+		Fetched:      time.Now().UTC(),
+	}
+
+	err = c.store(entry, data)
+	if err != nil {
+		log.Println("Could not cache", location, err)
+	}
+
+	return data, nil
+}
+
+// Contains reports whether the file at location is in the cache.
+func (c *Cache) Contains(location string) bool {
+
+	_, _, ok := c.load(location)
+
+	return ok
+}
+
+func (c *Cache) path(location string) string {
+
+	h := sha256.Sum256([]byte(location))
+
+	return filepath.Join(c.Dir, hex.EncodeToString(h[:]))
+}
+
+func (c *Cache) load(location string) (entry cacheEntry, data []byte, ok bool) {
+
+	p := c.path(location)
+
+	m, err := os.ReadFile(p + ".json")
+	if err != nil {
+		return
+	}
+
+	err = json.Unmarshal(m, &entry)
+	if err != nil || entry.URL != location {
+		return
+	}
+
+	data, err = os.ReadFile(p + ".data")
+	if err != nil {
+		return
+	}
+
+	return entry, data, true
+}
+
+func (c *Cache) store(entry cacheEntry, data []byte) error {
+
+	p := c.path(entry.URL)
+
+	m, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomic(p+".data", data)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(p+".json", m)
+}
+
+// writeFileAtomic writes data to a temporary file in the same
+// directory as name and renames it to name.
+func writeFileAtomic(name string, data []byte) error {
+
+	f, err := os.CreateTemp(filepath.Dir(name), filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
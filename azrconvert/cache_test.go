@@ -0,0 +1,70 @@
+package azrconvert
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+
+	var hits, notModified int
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("本文"))
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	location := srv.URL + "/cards/000001/files/1_1.html"
+
+	tests := []struct {
+		mode        CacheMode
+		hits        int
+		notModified int
+	}{
+		{CacheRevalidate, 1, 0},
+		{CacheRevalidate, 2, 1},
+		{CacheOffline, 2, 1},
+		{CacheRefresh, 3, 1},
+	}
+
+	for i, tc := range tests {
+
+		c, err := NewCache(dir, tc.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := c.Fetch(location)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		if string(data) != "本文" {
+			t.Errorf("%d: got %q", i, data)
+		}
+
+		if hits != tc.hits || notModified != tc.notModified {
+			t.Errorf("%d: got %d requests (%d not modified), want %d (%d)", i, hits, notModified, tc.hits, tc.notModified)
+		}
+	}
+
+	c, err := NewCache(dir, CacheOffline)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.Fetch(srv.URL + "/other.html")
+	if !errors.Is(err, ErrNotCached) {
+		t.Errorf("got error %v, want ErrNotCached", err)
+	}
+}
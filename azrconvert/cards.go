@@ -0,0 +1,305 @@
+package azrconvert
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+var (
+	cardPath   = regexp.MustCompile(`/cards/[0-9]+/card[0-9]+\.html$`)
+	personPath = regexp.MustCompile(`/index_pages/person[0-9]+\.html$`)
+)
+
+// IsCard reports whether location is the URL of a work card
+// (図書カード) on Aozora Bunko, e.g.
+// https://www.aozora.gr.jp/cards/000879/card55.html.
+func IsCard(location string) bool {
+	u, err := url.Parse(location)
+	return err == nil && cardPath.MatchString(u.Path)
+}
+
+// IsAuthorPage reports whether location is the URL of an author page
+// (作家別作品リスト) on Aozora Bunko, e.g.
+// https://www.aozora.gr.jp/index_pages/person879.html.
+func IsAuthorPage(location string) bool {
+	u, err := url.Parse(location)
+	return err == nil && personPath.MatchString(u.Path)
+}
+
+// WorksOf returns the URLs of the work cards linked from page, which is
+// usually an author page. Relative links are resolved against base, the
+// URL of page. Each card is listed only once.
+func WorksOf(page []byte, base string) (cards []string, err error) {
+
+	links, err := linksOf(page, base)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+
+	for _, l := range links {
+		if !IsCard(l) || seen[l] {
+			continue
+		}
+		seen[l] = true
+		cards = append(cards, l)
+	}
+
+	return cards, nil
+}
+
+// TextOfCard returns the URL of the text linked from the work card
+// page. The XHTML version is preferred. If there is none, the URL of
+// the zip archive with the plain text version is returned. Relative
+// links are resolved against base, the URL of page. If the card links
+// to no text, ErrNoText is returned.
+func TextOfCard(page []byte, base string) (string, error) {
+
+	links, err := linksOf(page, base)
+	if err != nil {
+		return "", err
+	}
+
+	var archive string
+
+	for _, l := range links {
+
+		u, _ := url.Parse(l)
+
+		if path.Base(path.Dir(u.Path)) != "files" {
+			continue
+		}
+
+		switch path.Ext(u.Path) {
+		case ".html":
+			return l, nil
+		case ".zip":
+			if archive == "" {
+				archive = l
+			}
+		}
+	}
+
+	if archive == "" {
+		return "", ErrNoText
+	}
+
+	return archive, nil
+}
+
+// Card holds the information found on a work card (図書カード)
+// that is not part of the text itself.
+type Card struct {
+	// URL of the card and of the text linked from it.
+	URL, Text string
+
+	Title          string // 作品名
+	TitleReading   string // 作品名読み
+	Creator        string // 著者名
+	CreatorReading string // 作家名読み of the author
+	Classification string // 分類, usually an NDC number
+	FirstPublished string // 初出
+	Orthography    string // 文字遣い種別
+	Source         string // 底本 with its publisher and date
+	Inputter       string // 入力
+	Proofreader    string // 校正
+}
+
+// ParseCard returns the information on the work card page. Relative
+// links are resolved against base, the URL of page. If the card links
+// to no text, Text is empty and the error is ErrNoText.
+func ParseCard(page []byte, base string) (*Card, error) {
+
+	if !utf8.Valid(page) {
+		page = ToUTF8(page)
+	}
+
+	c := &Card{URL: base}
+
+	for _, tbl := range cardTables(page) {
+
+		switch tbl.summary {
+
+		case "タイトルデータ":
+			c.Title = tbl.get("作品名")
+			c.TitleReading = tbl.get("作品名読み")
+			c.Creator = tbl.get("著者名")
+
+		case "作品データ":
+			c.Classification = tbl.get("分類")
+			c.FirstPublished = tbl.get("初出")
+			c.Orthography = tbl.get("文字遣い種別")
+
+		case "作家データ":
+			if c.CreatorReading == "" && tbl.get("分類") == "著者" {
+				c.CreatorReading = tbl.get("作家名読み")
+			}
+
+		case "底本データ":
+			if c.Source == "" {
+				c.Source = joinNonEmpty("、", tbl.get("底本"), tbl.get("出版社"), tbl.get("初版発行日"))
+			}
+
+		case "工作員データ":
+			c.Inputter = tbl.get("入力")
+			c.Proofreader = tbl.get("校正")
+		}
+	}
+
+	var err error
+
+	c.Text, err = TextOfCard(page, base)
+
+	return c, err
+}
+
+// SetMetadataFromCard sets the metadata of b that is given on the
+// work card c. Title and creator are only set if b has none. Values
+// on the card take precedence over values found in the text.
+func (b *Book) SetMetadataFromCard(c *Card) {
+
+	if b.Title == "" {
+		b.SetTitle(c.Title)
+	}
+
+	if b.Creator == "" {
+		b.SetCreator(c.Creator)
+	}
+
+	b.Card = c.URL
+
+	if c.TitleReading != "" {
+		b.TitleReading = c.TitleReading
+	}
+
+	if c.Creator != "" {
+		b.AddCreator(Person{Name: c.Creator, FileAs: c.CreatorReading, Role: RoleAuthor})
+	}
+
+	b.AddSubject(c.Classification)
+
+	if c.FirstPublished != "" {
+		b.FirstPublished = c.FirstPublished
+	}
+
+	if c.Orthography != "" {
+		b.Orthography = c.Orthography
+	}
+
+	if c.Source != "" {
+		b.Source = c.Source
+	}
+
+	b.AddContributor(Person{Name: c.Inputter, Role: RoleTranscriber})
+	b.AddContributor(Person{Name: c.Proofreader, Role: RoleProofreader})
+
+	return
+}
+
+// cardTable is one of the tables of a work card. The rows consist of
+// a header cell (e.g. 作品名：) followed by a value.
+type cardTable struct {
+	summary string
+	fields  map[string]string
+}
+
+func (t cardTable) get(key string) string {
+	return t.fields[key]
+}
+
+func cardTables(page []byte) (tables []cardTable) {
+
+	var tbl *cardTable
+	var key string
+	var cell *strings.Builder
+	var header bool
+
+	for _, t := range tokenize(page) {
+
+		switch {
+
+		case t.Type == html.StartTagToken && t.DataAtom == atom.Table:
+			tables = append(tables, cardTable{summary: getAttr(t, "summary"), fields: make(map[string]string)})
+			tbl = &tables[len(tables)-1]
+			key = ""
+
+		case tbl == nil:
+			continue
+
+		case t.Type == html.EndTagToken && t.DataAtom == atom.Table:
+			tbl = nil
+
+		case t.Type == html.StartTagToken && t.DataAtom == atom.Td:
+			cell = new(strings.Builder)
+			header = classOf(t) == "header"
+
+		case t.Type == html.EndTagToken && t.DataAtom == atom.Td && cell != nil:
+			v := strings.TrimSpace(cell.String())
+			cell = nil
+			if header {
+				key = strings.TrimRight(v, "：: ")
+				continue
+			}
+			if key != "" {
+				if _, ok := tbl.fields[key]; !ok {
+					tbl.fields[key] = v
+				}
+				key = ""
+			}
+
+		case t.Type == html.TextToken && cell != nil:
+			cell.WriteString(strings.Join(strings.Fields(t.Data), " "))
+		}
+	}
+
+	return
+}
+
+func joinNonEmpty(sep string, ss ...string) string {
+
+	var out []string
+
+	for _, s := range ss {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+
+	return strings.Join(out, sep)
+}
+
+// linksOf returns the targets of all links in page resolved against
+// base.
+func linksOf(page []byte, base string) (links []string, err error) {
+
+	b, err := url.Parse(base)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range tokenize(page) {
+
+		if t.Type != html.StartTagToken || t.Data != "a" {
+			continue
+		}
+
+		ref, err := url.Parse(getAttr(t, "href"))
+		if err != nil || getAttr(t, "href") == "" {
+			continue
+		}
+
+		l := b.ResolveReference(ref)
+		l.Fragment = ""
+
+		links = append(links, l.String())
+	}
+
+	return links, nil
+}
@@ -0,0 +1,131 @@
+package azrconvert
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const personTestPage = `<html><body>
+<ol>
+<li><a href="../cards/000879/card55.html">芋粥</a>（新字旧仮名、作品ID：55）</li>
+<li><a href="../cards/000879/card92.html">蜘蛛の糸</a>（新字旧仮名、作品ID：92）</li>
+<li><a href="../cards/000879/card55.html#top">芋粥</a></li>
+</ol>
+<a href="../index_top.html">トップ</a>
+</body></html>`
+
+const cardTestPage = `<html><body>
+<table summary="タイトルデータ" class="header">
+<tr><td class="header">作品名：</td><td><font size="+2">芋粥</font></td></tr>
+<tr><td class="header">作品名読み：</td><td>いもがゆ</td></tr>
+<tr><td class="header">著者名：</td><td><a href="../../index_pages/person879.html">芥川 竜之介</a></td></tr>
+</table>
+<table summary="作品データ">
+<tr><td class="header">分類：</td><td>NDC 913</td></tr>
+<tr><td class="header">初出：</td><td>「新小説」1916（大正5）年9月</td></tr>
+<tr><td class="header">文字遣い種別：</td><td>新字旧仮名</td></tr>
+</table>
+<table summary="作家データ">
+<tr><td class="header">分類：</td><td>著者</td></tr>
+<tr><td class="header">作家名：</td><td><a href="../../index_pages/person879.html">芥川 竜之介</a></td></tr>
+<tr><td class="header">作家名読み：</td><td>あくたがわ りゅうのすけ</td></tr>
+</table>
+<table summary="底本データ">
+<tr><td class="header">底本：</td><td>芥川龍之介全集1</td></tr>
+<tr><td class="header">出版社：</td><td>ちくま文庫、筑摩書房</td></tr>
+<tr><td class="header">初版発行日：</td><td>1986（昭和61）年9月24日</td></tr>
+</table>
+<table summary="工作員データ">
+<tr><td class="header">入力：</td><td>j.utiyama</td></tr>
+<tr><td class="header">校正：</td><td>かとうかおり</td></tr>
+</table>
+<table class="download">
+<tr><td><a href="./files/55_ruby_1385.zip">55_ruby_1385.zip</a></td></tr>
+<tr><td><a href="./files/55_14824.html">55_14824.html</a></td></tr>
+</table>
+</body></html>`
+
+func TestWorksOf(t *testing.T) {
+
+	base := "https://www.aozora.gr.jp/index_pages/person879.html"
+
+	if !IsAuthorPage(base) {
+		t.Errorf("%s is not an author page", base)
+	}
+
+	got, err := WorksOf([]byte(personTestPage), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"https://www.aozora.gr.jp/cards/000879/card55.html",
+		"https://www.aozora.gr.jp/cards/000879/card92.html",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTextOfCard(t *testing.T) {
+
+	base := "https://www.aozora.gr.jp/cards/000879/card55.html"
+
+	if !IsCard(base) {
+		t.Errorf("%s is not a card", base)
+	}
+
+	tests := []struct {
+		page string
+		want string
+		err  error
+	}{
+		{cardTestPage, "https://www.aozora.gr.jp/cards/000879/files/55_14824.html", nil},
+		{`<a href="./files/55_ruby_1385.zip">zip</a>`, "https://www.aozora.gr.jp/cards/000879/files/55_ruby_1385.zip", nil},
+		{`<a href="../../index_pages/person879.html">作家</a>`, "", ErrNoText},
+	}
+
+	for _, tc := range tests {
+
+		got, err := TextOfCard([]byte(tc.page), base)
+
+		if !errors.Is(err, tc.err) {
+			t.Errorf("got error %v, want %v", err, tc.err)
+		}
+
+		if got != tc.want {
+			t.Errorf("got %s, want %s", got, tc.want)
+		}
+	}
+}
+
+func TestParseCard(t *testing.T) {
+
+	base := "https://www.aozora.gr.jp/cards/000879/card55.html"
+
+	got, err := ParseCard([]byte(cardTestPage), base)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Card{
+		URL:            base,
+		Text:           "https://www.aozora.gr.jp/cards/000879/files/55_14824.html",
+		Title:          "芋粥",
+		TitleReading:   "いもがゆ",
+		Creator:        "芥川 竜之介",
+		CreatorReading: "あくたがわ りゅうのすけ",
+		Classification: "NDC 913",
+		FirstPublished: "「新小説」1916（大正5）年9月",
+		Orthography:    "新字旧仮名",
+		Source:         "芥川龍之介全集1、ちくま文庫、筑摩書房、1986（昭和61）年9月24日",
+		Inputter:       "j.utiyama",
+		Proofreader:    "かとうかおり",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
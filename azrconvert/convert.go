@@ -5,16 +5,15 @@ import (
 	"bytes"
 	"crypto/md5"
 	"encoding/base64"
+	"errors"
 	"image"
 	"io"
 	"log"
 	"path/filepath"
-	"strconv"
 	"strings"
+	"time"
 
-	"github.com/adamay909/AozoraConvert/jptools"
 	"github.com/adamay909/AozoraConvert/mobi/records"
-	"github.com/adamay909/AozoraConvert/runes"
 	"github.com/google/uuid"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
@@ -23,18 +22,57 @@ import (
 // Book represents a book from Aozora Bunko
 type Book struct {
 	Title, Creator, Publisher string
-	Files                     []fileData
-	UUID                      string
-	Body                      []*html.Token
-	Preamble                  []*html.Token
-	URI                       string
-	TopSection                *section
-	CoverImage                image.Image
-	Images                    []records.ImageRecord
-	CSS                       string
-	Hash                      string
-	DateMod                   string
+
+	// Further metadata. See SetMetadataFromPreamble and
+	// SetMetadataFromCard.
+	Subtitle, OriginalTitle, TitleReading string
+	PublisherReading                      string
+	Creators, Contributors                []Person
+	Source, FirstPublished, Orthography   string
+	Date, Revised, Rights                 string
+	Subjects                              []string
+	Card                                  string
+
+	Files      []fileData
+	UUID       string
+	Body       []*html.Token
+	Preamble   []*html.Token
+	URI        string
+	Fetcher    Fetcher
+	TopSection *section
+	CoverImage image.Image
+	Images     []records.ImageRecord
+	CSS        string
+	Hash       string
+	DateMod    string
+
+	// Reproducible makes the output depend on the book only. See
+	// SetReproducible.
+	Reproducible bool
+	ModTime      time.Time
+
+	// JointMOBI adds a MOBI 6 version to AZW3 output for older
+	// Kindle devices.
+	JointMOBI bool
+
+	// GaijiPolicy gives the ways of writing gaiji tried in turn.
+	// If nil, DefaultGaijiPolicy is used. It must be set before
+	// reading the text.
+	GaijiPolicy GaijiPolicy
+
+	// Gaiji lists the gaiji of the text and how they are written.
+	Gaiji []Gaiji
+
+	// TOCDepth adds a table of contents page (目次) listing the
+	// sections down to TOCDepth levels after the title block of
+	// EPUB, AZW3, and web output. Zero means no such page. Depths
+	// above 3, the number of levels of headings, list all sections.
+	TOCDepth int
 	// Log                       string
+
+	// xhtml files of the EPUB and the file each id is in.
+	parts  []*bodyPart
+	partOf map[string]string
 }
 
 // NewBook returns a new Book.
@@ -45,30 +83,44 @@ func NewBook() *Book {
 }
 
 // NewBookFrom returns a Book based on d. d is assumed to be
-// xhtml formatted book from Aozora Bunko.
-func NewBookFrom(d []byte) *Book {
+// xhtml formatted book from Aozora Bunko. See GetBookFrom
+// for the errors returned.
+func NewBookFrom(d []byte) (*Book, error) {
 	bk := NewBook()
 
-	d = cleanhtml(d)
+	err := bk.GetBookFrom(d)
 
-	bk.GetBookFrom(d)
-
-	return bk
+	return bk, err
 }
 
-//GetBookFrom populates b based on d. d is assumed to be a
-//cleaned up, utf-8 encoded file.
+// GetBookFrom populates b based on d. d is assumed to be
+// xhtml formatted book from Aozora Bunko. If d has no
+// body, the error is ErrNoBody. If some of the images could
+// not be added, the error wraps ErrImageDownload but bk is
+// usable otherwise.
+func (bk *Book) GetBookFrom(d []byte) error {
+
+	return bk.getBookFrom(cleanhtml(d))
 
-func (bk *Book) GetBookFrom(d []byte) {
+}
 
-	d = cleanhtml(d)
+func (bk *Book) getBookFrom(d []byte) error {
 
 	tokens := tokenize(d)
 	log.Println("Parsed and tokenized document.")
 
 	bk.Preamble = getPreamble(tokens)
 
-	bk.Body = getBody(tokens)
+	gr := newGaijiResolver(bk.GaijiPolicy)
+
+	body, err := getBody(tokens, gr)
+	if err != nil {
+		return err
+	}
+
+	bk.Body = body
+
+	bk.Gaiji = gr.found
 
 	bk.TopSection = bk.getStructure()
 
@@ -77,7 +129,7 @@ func (bk *Book) GetBookFrom(d []byte) {
 			bk.TopSection = nil
 		}
 	*/
-	bk.AddFiles()
+	err = bk.AddFiles()
 
 	td := new(bytes.Buffer)
 
@@ -92,6 +144,7 @@ func (bk *Book) GetBookFrom(d []byte) {
 
 	bk.UUID = uuid.NewString()
 
+	return err
 }
 
 func cleanhtml(d []byte) []byte {
@@ -100,6 +153,11 @@ func cleanhtml(d []byte) []byte {
 
 	d = ToUTF8(d)
 
+	return cleanUTF8(d)
+}
+
+func cleanUTF8(d []byte) []byte {
+
 	d = fixLineBreaks(d)
 
 	d = fixLeftOverGaijiChuki(d)
@@ -113,36 +171,54 @@ func cleanhtml(d []byte) []byte {
 	return d
 }
 
-// NewBookFromiZip returns a Book based on dz. dz is assumed to be the result of
-// RenderWebpagePackage.
-func NewBookFromZip(dz []byte) (bk *Book) {
+// NewBookFromZip returns a Book based on dz. dz is assumed to be the result of
+// RenderWebpagePackage or a zip archive as distributed by Aozora Bunko
+// containing the plain text of the book. The errors are
+// as for GetBookFrom.
+func NewBookFromZip(dz []byte) (bk *Book, err error) {
 
 	var d []byte
 
 	arch, err := zip.NewReader(bytes.NewReader(dz), int64(len(dz)))
 	if err != nil {
-		log.Println(err)
-		return
+		return nil, err
 	}
 
 	bk = NewBook()
-	bk.addFilesFromZip(arch)
+
+	t, err := textOfArchive(arch)
+	if err != nil {
+		return nil, err
+	}
+
+	if t != nil {
+		bk.SetFetcher(ZipFetcher{Archive: arch})
+		err = bk.GetBookFromText(t)
+		if err != nil && !errors.Is(err, ErrImageDownload) {
+			return nil, err
+		}
+		bk.SetMetadataFromPreamble()
+		return bk, errors.Join(err, bk.GenTitlePage())
+	}
+
+	err = bk.addFilesFromZip(arch)
+	if err != nil {
+		return nil, err
+	}
 
 	for _, e := range arch.File {
 
 		if filepath.Base(e.Name) == "1.html" {
 
 			r, err := e.Open()
-			defer r.Close()
 			if err != nil {
-				log.Println(err)
-				return
+				return nil, err
 			}
 
 			d, err = io.ReadAll(r)
+			r.Close()
 			if err != nil {
-				log.Println(err)
-				return
+				return nil, err
 			}
 
 			break
@@ -152,7 +228,10 @@ func NewBookFromZip(dz []byte) (bk *Book) {
 	tokens := tokenize(d)
 	bk.Preamble = headerOf(tokens)
 
-	bk.Body = bodyOf(tokens)
+	bk.Body, err = bodyOf(tokens)
+	if err != nil {
+		return nil, err
+	}
 
 	bk.SetMetadataFromPreamble()
 
@@ -175,9 +254,9 @@ func NewBookFromZip(dz []byte) (bk *Book) {
 
 	bk.UUID = uuid.NewString()
 
-	bk.GenTitlePage()
+	err = bk.GenTitlePage()
 
-	return bk
+	return bk, err
 }
 
 // SetURI sets the path of book within
@@ -242,9 +321,9 @@ func headerOf(tokens []*html.Token) (preamble []*html.Token) {
 
 }
 
-func bodyOf(tokens []*html.Token) (body []*html.Token) {
+func bodyOf(tokens []*html.Token) (body []*html.Token, err error) {
 
-	var s, e int
+	s, e := -1, -1
 
 	for i, t := range tokens {
 		if isBodyStart(t) {
@@ -257,20 +336,29 @@ func bodyOf(tokens []*html.Token) (body []*html.Token) {
 		}
 	}
 
+	if s == -1 || e < s {
+		return nil, ErrNoBody
+	}
+
 	body = tokens[s : e+1]
 	log.Println("Got html body of document.")
 
-	return body
+	return body, nil
 
 }
 
-func getBody(tokens []*html.Token) (body []*html.Token) {
+func getBody(tokens []*html.Token, gr *gaijiResolver) (body []*html.Token, err error) {
+
+	body, err = bodyOf(tokens)
+	if err != nil {
+		return nil, err
+	}
 
-	body = bodyOf(tokens)
+	body = removeTOCPage(body)
 
-	body = fixNodes(body)
+	body = fixNodes(body, gr)
 
-	body = fixTokens(body)
+	body = fixTokens(body, gr)
 
 	body = fixKogaki(body)
 
@@ -278,11 +366,13 @@ func getBody(tokens []*html.Token) (body []*html.Token) {
 
 	insertSectionID(body)
 
-	return body
+	insertLandmarkID(body)
+
+	return body, nil
 
 }
 
-func fixNodes(in []*html.Token) (out []*html.Token) {
+func fixNodes(in []*html.Token, gr *gaijiResolver) (out []*html.Token) {
 
 	//First Round
 
@@ -294,7 +384,7 @@ func fixNodes(in []*html.Token) (out []*html.Token) {
 
 		case isNote(t):
 			node := getNode(in[i:])
-			out = append(out, fixNote(node)...)
+			out = append(out, fixNote(node, gr)...)
 			i = i + len(node) - 1
 
 		case isScriptStart(t):
@@ -395,7 +485,7 @@ func fixNodes(in []*html.Token) (out []*html.Token) {
 	return
 }
 
-func fixTokens(in []*html.Token) (out []*html.Token) {
+func fixTokens(in []*html.Token, gr *gaijiResolver) (out []*html.Token) {
 
 	for i := 0; i < len(in); i++ {
 		t := in[i]
@@ -411,7 +501,7 @@ func fixTokens(in []*html.Token) (out []*html.Token) {
 			out = append(out, fixBurasage(t))
 
 		case isGaiji(t):
-			out = append(out, fixGaiji(t))
+			out = append(out, gr.fixGaijiImage(t)...)
 
 		default:
 			out = append(out, in[i])
@@ -420,44 +510,22 @@ func fixTokens(in []*html.Token) (out []*html.Token) {
 	return
 }
 
-func fixNote(oldNode []*html.Token) (newNode []*html.Token) {
+func fixNote(oldNode []*html.Token, gr *gaijiResolver) (newNode []*html.Token) {
 
 	log.Println("fixing note:", renderTokens(oldNode))
 
-	if strings.HasPrefix(oldNode[1].String(), "※") {
-
-		var c string
-		var err error
-
-		if strings.Contains(oldNode[1].String(), "U+") {
-			r := runes.Runes(oldNode[1].String())
-			i := runes.Index(r, runes.Runes("U+"))
-			c, _ = strconv.Unquote(`"` + `\u` + string(r[i+2:i+6]) + `"`)
-		}
+	if strings.HasPrefix(oldNode[1].Data, "※") {
 
-		jcode := jcodeOfStr(oldNode[1].String())
-		if len(jcode) > 0 {
+		note := oldNode[1].Data
 
-			c, err = jptools.Convert(jcode)
-			if err != nil {
-				log.Println("Gaiji. Convert failed for ", renderTokens(oldNode), " Error was: ", err)
-			}
-		}
-
-		if c == "" {
-			log.Println("Gaiji. Convert failed for ", renderTokens(oldNode), " Error was: ", err)
+		newNode = gr.resolve(note, parseGaijiNote(note), nil)
+		if newNode == nil {
 			return oldNode
 		}
 
-		nn := new(html.Token)
-		nn.Data = c
-		nn.DataAtom = 0
-		nn.Type = html.TextToken
-		newNode = append(newNode, nn)
 		log.Println("Gaiji. Replaced ", renderTokens(oldNode), "with", renderTokens(newNode))
 
 		return newNode
-
 	}
 
 	if strings.Contains(oldNode[1].String(), "［＃改丁］") {
@@ -527,33 +595,6 @@ func fixBurasage(t *html.Token) *html.Token {
 	return t
 }
 
-func fixGaiji(t *html.Token) *html.Token {
-
-	jcode := getAttr(t, "data-jcode")
-
-	if len(jcode) == 0 {
-		fn := getAttr(t, "src")
-		jcode = strings.TrimSuffix(filepath.Base(fn), ".png")
-	}
-
-	r, err := jptools.Convert(jcode)
-	if err != nil {
-		log.Println("Gaiji. Convert failed for ", t, " Error was: ", err)
-		return t
-	}
-
-	ot := t.String()
-
-	t.Type = html.TextToken
-	t.DataAtom = atom.Atom(int32(0))
-	t.Attr = nil
-
-	t.Data = r
-
-	log.Println("Gaiji. Replaced ", ot, "with ", r)
-	return t
-}
-
 func fixRubyNode(oldNode []*html.Token) (newNode []*html.Token) {
 
 	for i := 0; i < len(oldNode); i++ {
@@ -595,6 +636,8 @@ func fixMetadata(oldNode []*html.Token) (newNode []*html.Token) {
 
 	nn.Type = html.EndTagToken
 
+	nn.DataAtom = atom.Div
+
 	nn.Data = "div"
 
 	newNode[len(newNode)-1] = nn
@@ -759,7 +802,12 @@ func mkNewNode(dataAtom atom.Atom) (node []*html.Token) {
 // from the header portion of the xhtlm file provided
 // by Aozora Bunko. This is not always successful as
 // not all the xhtml files have the information
-// in their header.
+// in their header. Subtitle, original title, and all
+// creators with their roles are taken from the
+// div.metadata block, and source, first publication,
+// date, and the people who input and proofread the
+// text from the colophon at the end of the text.
+// Rights defaults to PublicDomain.
 func (b *Book) SetMetadataFromPreamble() {
 
 	for _, t := range b.Preamble {
@@ -782,5 +830,11 @@ func (b *Book) SetMetadataFromPreamble() {
 
 	}
 
+	b.setMetadataFromBody()
+
+	if b.Rights == "" {
+		b.Rights = PublicDomain
+	}
+
 	return
 }
@@ -0,0 +1,96 @@
+package azrconvert
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestGenCover(t *testing.T) {
+
+	red := color.RGBA{200, 0, 0, 255}
+	blue := image.NewRGBA(image.Rect(0, 0, 30, 20))
+	for i := 2; i < len(blue.Pix); i += 4 {
+		blue.Pix[i], blue.Pix[i+1] = 200, 255
+	}
+
+	tests := []struct {
+		name   string
+		opts   CoverOptions
+		width  int
+		height int
+		corner color.Color
+	}{
+		{"default", CoverOptions{}, 1200, 1600, nil},
+		{"colours", CoverOptions{Width: 300, Height: 400, Palette: []ColorPair{{red, color.White}}}, 300, 400, red},
+		{"vertical", CoverOptions{Layout: CoverVertical, Palette: []ColorPair{{red, color.White}}, Author: PlaceBottom}, 1200, 1600, red},
+		{"centered", CoverOptions{Layout: CoverCentered, Publisher: PlaceHidden, Palette: []ColorPair{{red, color.White}}}, 1200, 1600, red},
+		{"background", CoverOptions{Width: 60, Height: 80, BackgroundImage: blue}, 60, 80, blue.At(0, 0)},
+		{"ruby", CoverOptions{Ruby: true, Layout: CoverCentered}, 1200, 1600, nil},
+		{"vertical ruby", CoverOptions{Ruby: true, Layout: CoverVertical}, 1200, 1600, nil},
+		{"fallback", CoverOptions{Font: fontdata, FallbackFonts: [][]byte{fontdata}}, 1200, 1600, nil},
+	}
+
+	for _, tt := range tests {
+
+		b := NewBook()
+		b.Title = "羅生門"
+		b.TitleReading = "らしょうもん"
+		b.Creator = "芥川龍之介"
+		b.Publisher = "青空文庫"
+
+		if err := b.GenCover(tt.opts); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		bounds := b.CoverImage.Bounds()
+		if bounds.Dx() != tt.width || bounds.Dy() != tt.height {
+			t.Errorf("%s: cover is %v", tt.name, bounds)
+		}
+
+		if tt.corner == nil {
+			continue
+		}
+
+		r1, g1, b1, _ := b.CoverImage.At(0, 0).RGBA()
+		r2, g2, b2, _ := tt.corner.RGBA()
+		if r1 != r2 || g1 != g2 || b1 != b2 {
+			t.Errorf("%s: corner is %v", tt.name, b.CoverImage.At(0, 0))
+		}
+	}
+
+	b := NewBook()
+	if err := b.GenCover(CoverOptions{Font: []byte("no font")}); err == nil {
+		t.Error("no error for invalid font")
+	}
+	if err := b.GenCover(CoverOptions{FallbackFonts: [][]byte{[]byte("no font")}}); err == nil {
+		t.Error("no error for invalid fallback font")
+	}
+}
+
+func TestDefaultColorPair(t *testing.T) {
+
+	tests := []struct {
+		n    int64
+		want int
+	}{
+		{0, 0}, {1, 1}, {7, 2}, {3, 3}, {9, 3}, {-1, 3}, {-5, 0},
+	}
+
+	for _, tt := range tests {
+
+		h := make([]byte, 4)
+		binary.PutVarint(h, tt.n)
+
+		b := NewBook()
+		b.Hash = base64.StdEncoding.EncodeToString(h)
+
+		bg, fg := b.colorpair(nil)
+
+		if p := DefaultPalette[tt.want]; bg != p.Background || fg != p.Foreground {
+			t.Errorf("%d: got %v %v, want pair %d", tt.n, bg, fg, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package azrconvert
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/adamay909/AozoraConvert/epubcheck"
+)
+
+func TestEpubConforms(t *testing.T) {
+
+	page := strings.NewReplacer(
+		"<h1 class=\"title\">題</h1>", "<h1 class=\"title\">題 &amp; &lt;副題&gt;</h1>",
+		"<h2 class=\"author\">作者</h2>", "<h2 class=\"author\">作者 &amp; 訳者</h2>",
+		"id=\"midashi10\">一<", "id=\"midashi10\">一 &lt;&amp;&gt;<",
+	).Replace(splitTestPage)
+
+	bk := NewBook()
+
+	err := bk.getBookFrom(cleanUTF8([]byte(page)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bk.SetMetadataFromPreamble()
+
+	if bk.Title != "題 & <副題>" {
+		t.Fatalf("title is %q", bk.Title)
+	}
+
+	err = bk.GenTitlePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = bk.WriteEpub(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range epubcheck.Check(buf.Bytes()) {
+		t.Error(d)
+	}
+}
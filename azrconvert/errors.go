@@ -0,0 +1,24 @@
+package azrconvert
+
+import "errors"
+
+var (
+	// ErrImageDownload is returned when an image used by the book
+	// cannot be retrieved or decoded.
+	ErrImageDownload = errors.New("azrconvert: cannot add image")
+
+	// ErrNoBody is returned when the document has no body.
+	ErrNoBody = errors.New("azrconvert: document has no body")
+
+	// ErrTemplate is returned when one of the templates used for
+	// rendering the book cannot be executed.
+	ErrTemplate = errors.New("azrconvert: cannot execute template")
+
+	// ErrNotCached is returned by a Cache in CacheOffline mode for
+	// files that are not in the cache.
+	ErrNotCached = errors.New("azrconvert: file not in cache")
+
+	// ErrNoText is returned when a work card does not link to
+	// the text of the work.
+	ErrNoText = errors.New("azrconvert: card links to no text")
+)
@@ -0,0 +1,41 @@
+package azrconvert
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"text/template"
+)
+
+func TestErrors(t *testing.T) {
+
+	noBody := func() error {
+		_, err := NewBookFrom([]byte(`<html><head><title>test</title></head></html>`))
+		return err
+	}
+
+	noBodyAZW3 := func() error {
+		return NewBook().WriteAZW3(io.Discard)
+	}
+
+	badTemplate := func() error {
+		tpl := template.Must(template.New("test").Parse(`{{.NoSuchField}}`))
+		return executeTemplate(io.Discard, tpl, NewBook())
+	}
+
+	tests := []struct {
+		name string
+		f    func() error
+		want error
+	}{
+		{"no body", noBody, ErrNoBody},
+		{"no body azw3", noBodyAZW3, ErrNoBody},
+		{"template", badTemplate, ErrTemplate},
+	}
+
+	for _, tt := range tests {
+		if err := tt.f(); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package azrconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// Fetcher retrieves the files (mostly images) referenced by a book.
+// location is the reference found in the book resolved against the
+// URI of the book. Depending on the URI, location is either an
+// absolute URL or a relative path.
+type Fetcher interface {
+	Fetch(location string) ([]byte, error)
+}
+
+// HTTPFetcher fetches files over HTTP. location must be an absolute URL.
+// If Client is nil, http.DefaultClient is used.
+type HTTPFetcher struct {
+	Client *http.Client
+}
+
+// Fetch implements Fetcher.
+func (f HTTPFetcher) Fetch(location string) ([]byte, error) {
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	if !u.IsAbs() {
+		return nil, errors.New(location + ": not an absolute URL")
+	}
+
+	c := f.Client
+	if c == nil {
+		c = http.DefaultClient
+	}
+
+	r, err := c.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+
+	if r.StatusCode != http.StatusOK {
+		return nil, errors.New(location + ": " + r.Status)
+	}
+
+	log.Println("file downloaded")
+
+	return io.ReadAll(r.Body)
+}
+
+// DirFetcher fetches files from the local directory Root. For URLs,
+// the path of the URL is taken relative to Root so that Root can be a
+// local mirror of Aozora Bunko (containing the cards and gaiji
+// directories). Relative paths are taken relative to Root and may
+// lead out of it with ../.
+type DirFetcher struct {
+	Root string
+}
+
+// Fetch implements Fetcher.
+func (f DirFetcher) Fetch(location string) ([]byte, error) {
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	p := u.Path
+	if u.IsAbs() {
+		p = path.Clean("/" + p)
+	}
+
+	return os.ReadFile(filepath.Join(f.Root, filepath.FromSlash(p)))
+}
+
+// ZipFetcher fetches files from a zip archive such as the ones
+// distributed by Aozora Bunko. Files are looked up by their path
+// inside the archive, and failing that, by their base name.
+type ZipFetcher struct {
+	Archive *zip.Reader
+}
+
+// NewZipFetcher returns a ZipFetcher for the zip archive dz.
+func NewZipFetcher(dz []byte) (ZipFetcher, error) {
+
+	arch, err := zip.NewReader(bytes.NewReader(dz), int64(len(dz)))
+	if err != nil {
+		return ZipFetcher{}, err
+	}
+
+	return ZipFetcher{Archive: arch}, nil
+}
+
+// Fetch implements Fetcher.
+func (f ZipFetcher) Fetch(location string) ([]byte, error) {
+
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	name := path.Clean(u.Path)
+
+	var found *zip.File
+
+	for _, e := range f.Archive.File {
+		if path.Clean(e.Name) == name {
+			found = e
+			break
+		}
+		if found == nil && path.Base(e.Name) == path.Base(name) {
+			found = e
+		}
+	}
+
+	if found == nil {
+		return nil, &fs.PathError{Op: "fetch", Path: location, Err: fs.ErrNotExist}
+	}
+
+	r, err := found.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// MapFetcher fetches files from memory. Files are looked up by
+// location, and failing that, by the base name of location.
+type MapFetcher map[string][]byte
+
+// Fetch implements Fetcher.
+func (f MapFetcher) Fetch(location string) ([]byte, error) {
+
+	if d, ok := f[location]; ok {
+		return d, nil
+	}
+
+	if d, ok := f[path.Base(location)]; ok {
+		return d, nil
+	}
+
+	return nil, &fs.PathError{Op: "fetch", Path: location, Err: fs.ErrNotExist}
+}
+
+// SetFetcher sets the Fetcher used for retrieving the files
+// referenced by b. The default is HTTPFetcher.
+func (b *Book) SetFetcher(f Fetcher) {
+	b.Fetcher = f
+}
+
+func (b *Book) fetcher() Fetcher {
+
+	if b.Fetcher == nil {
+		return HTTPFetcher{}
+	}
+
+	return b.Fetcher
+}
+
+// resolve returns the location of ref relative to the URI of b. If
+// the URI is a relative path such as that of a local file, relative
+// references stay relative paths, so that ../ can lead out of the
+// directory of the file, e.g. into a sibling gaiji directory.
+func (b *Book) resolve(ref string) (string, error) {
+
+	base, err := url.Parse(b.URI)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if base.IsAbs() || base.Host != "" || r.IsAbs() || r.Host != "" || path.IsAbs(r.Path) {
+		return base.ResolveReference(r).String(), nil
+	}
+
+	loc := url.URL{Path: path.Join(path.Dir(base.Path), r.Path)}
+
+	return loc.String(), nil
+}
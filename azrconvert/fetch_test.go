@@ -0,0 +1,131 @@
+package azrconvert
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const fetchTestPage = `<html><head><title>test</title></head>
+<body>
+<div class="main_text">本文<br />
+<img class="illustration" src="fig1.png" alt="挿絵" /><br />
+</div>
+</body></html>`
+
+// the gaiji image is ../../../gaiji/1-84/1-84-77.png
+const dirFetcherTestPage = `<html><head><title>test</title></head>
+<body>
+<div class="main_text">本文※<span class="notes">［＃「てへん＋劣」、第3水準1-84-77］</span><br />
+<img class="illustration" src="fig1.png" alt="挿絵" /><br />
+</div>
+</body></html>`
+
+func testPNG(t *testing.T) []byte {
+
+	buf := new(bytes.Buffer)
+
+	err := png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 4, 3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestAddFilesWithFetcher(t *testing.T) {
+
+	bk := NewBook()
+	bk.SetURI("https://www.aozora.gr.jp/cards/000001/files/1_1.html")
+	bk.SetFetcher(MapFetcher{
+		"https://www.aozora.gr.jp/cards/000001/files/fig1.png": testPNG(t),
+	})
+
+	err := bk.getBookFrom(cleanUTF8([]byte(fetchTestPage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(bk.Images) != 1 {
+		t.Fatalf("got %d images, want 1", len(bk.Images))
+	}
+
+	if bk.Files[0].Location != "https://www.aozora.gr.jp/cards/000001/files/fig1.png" {
+		t.Errorf("got location %s", bk.Files[0].Location)
+	}
+}
+
+func TestAddFilesMissingImage(t *testing.T) {
+
+	bk := NewBook()
+	bk.SetFetcher(MapFetcher{})
+
+	err := bk.getBookFrom(cleanUTF8([]byte(fetchTestPage)))
+	if !errors.Is(err, ErrImageDownload) {
+		t.Fatalf("got error %v, want ErrImageDownload", err)
+	}
+
+	if len(bk.Body) == 0 {
+		t.Error("book has no body")
+	}
+}
+
+func TestDirFetcher(t *testing.T) {
+
+	root := t.TempDir()
+
+	dir := filepath.Join(root, "cards", "000001", "files")
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(dir, "fig1.png"), testPNG(t), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the gaiji directory of a mirror is a sibling of cards
+	gaiji := filepath.Join(root, "gaiji", "1-84")
+	err = os.MkdirAll(gaiji, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = os.WriteFile(filepath.Join(gaiji, "1-84-77.png"), testPNG(t), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		uri  string
+		root string
+	}{
+		{"https://www.aozora.gr.jp/cards/000001/files/1_1.html", root},
+		{"1_1.html", dir},
+		{"files/1_1.html", filepath.Join(root, "cards", "000001")},
+	}
+
+	for _, tc := range tests {
+
+		bk := NewBook()
+		bk.SetURI(tc.uri)
+		bk.SetFetcher(DirFetcher{Root: tc.root})
+
+		bk.GaijiPolicy = GaijiPolicy{GaijiImage}
+
+		err := bk.getBookFrom(cleanUTF8([]byte(dirFetcherTestPage)))
+		if err != nil {
+			t.Errorf("%s: %v", tc.uri, err)
+			continue
+		}
+
+		if len(bk.Images) != 2 {
+			t.Errorf("%s: got %d images, want 2", tc.uri, len(bk.Images))
+		}
+	}
+}
@@ -103,7 +103,7 @@ func fixCentering(in []*html.Token) (out []*html.Token) {
 
 		nn.Data = "div"
 
-		nn.Attr = append(nn.Attr, html.Attribute{"", "class", "centered"})
+		nn.Attr = append(nn.Attr, html.Attribute{Key: "class", Val: "centered"})
 
 		out = append(out, nn)
 
@@ -0,0 +1,471 @@
+package azrconvert
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/adamay909/AozoraConvert/jptools"
+	"github.com/adamay909/AozoraConvert/runes"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// GaijiMethod is a way of writing gaiji, the characters Aozora Bunko
+// texts give by a note such as ※［＃「さんずい＋督」、第3水準1-87-3］
+// or by an image because they are not in JIS X 0208.
+type GaijiMethod int
+
+const (
+	// GaijiUnresolved means that the gaiji is left as it is in the
+	// text.
+	GaijiUnresolved GaijiMethod = iota
+
+	// GaijiUnicode writes the gaiji as a single Unicode character.
+	GaijiUnicode
+
+	// GaijiSequence writes the gaiji as a sequence of Unicode
+	// characters: a character with an ideographic variation
+	// selector or with combining characters.
+	GaijiSequence
+
+	// GaijiImage writes the gaiji with the image of Aozora Bunko,
+	// scaled to the size of the text.
+	GaijiImage
+
+	// GaijiDescription writes the description of the gaiji given
+	// by the note, e.g. 〔さんずい＋督〕.
+	GaijiDescription
+)
+
+func (m GaijiMethod) String() string {
+	switch m {
+	case GaijiUnicode:
+		return "unicode"
+	case GaijiSequence:
+		return "sequence"
+	case GaijiImage:
+		return "image"
+	case GaijiDescription:
+		return "description"
+	}
+	return "unresolved"
+}
+
+// GaijiPolicy lists the methods tried in turn for writing gaiji.
+type GaijiPolicy []GaijiMethod
+
+// DefaultGaijiPolicy is used for books without a GaijiPolicy of
+// their own.
+var DefaultGaijiPolicy = GaijiPolicy{GaijiUnicode, GaijiSequence, GaijiImage, GaijiDescription}
+
+// ParseGaijiPolicy parses a policy given as methods separated by
+// commas, e.g. "unicode,image".
+func ParseGaijiPolicy(s string) (GaijiPolicy, error) {
+
+	var p GaijiPolicy
+
+	for _, name := range strings.Split(s, ",") {
+
+		found := false
+
+		for m := GaijiUnicode; m <= GaijiDescription; m++ {
+			if strings.TrimSpace(name) == m.String() {
+				p = append(p, m)
+				found = true
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown gaiji method %q, use unicode, sequence, image, or description", name)
+		}
+	}
+
+	return p, nil
+}
+
+// Gaiji records a gaiji of a book and how it is written.
+type Gaiji struct {
+	// Note is the note or the alternative text of the image
+	// describing the gaiji in the text.
+	Note string
+
+	// Code is the JIS X 0213 code of the gaiji in the 面-区-点
+	// (men-ku-ten) format if known.
+	Code string
+
+	// Text is what the gaiji is written as: the Unicode characters,
+	// the name of the image file, or the description.
+	Text string
+
+	Method GaijiMethod
+}
+
+func (g Gaiji) String() string {
+	return g.Note + " (" + g.Method.String() + ")"
+}
+
+// UnresolvedGaiji returns the gaiji of b that are not written as
+// Unicode characters.
+func (b *Book) UnresolvedGaiji() (out []Gaiji) {
+
+	for _, g := range b.Gaiji {
+		if g.Method != GaijiUnicode && g.Method != GaijiSequence {
+			out = append(out, g)
+		}
+	}
+
+	return out
+}
+
+// gaijiNote is what a note or the alternative text of an image says
+// about a gaiji. unicode is the text of the gaiji if the note gives
+// it by code points or as a combining sequence; otherwise the text is
+// that of jcode.
+type gaijiNote struct {
+	desc    string
+	jcode   string
+	unicode string
+}
+
+var (
+	// U+2000B, U+845B+E0100, U+845B U+E0100, U+304B_309A
+	codePointNotation = regexp.MustCompile(`U\+[0-9A-Fa-f]{4,6}(?:[+_ ,]\s*(?:U\+)?[0-9A-Fa-f]{4,6}\b)*`)
+
+	// 845B E0100 as in the Ideographic Variation Database, e.g.
+	// 845B E0100; Adobe-Japan1; CID+1481
+	ivdNotation = regexp.MustCompile(`\b[0-9A-Fa-f]{4,6}[ _][Ee]01[0-9A-Ea-e][0-9A-Fa-f]\b`)
+
+	// VS1 to VS256
+	vsNotation = regexp.MustCompile(`\bVS([0-9]{1,3})\b`)
+
+	// 「う」に濁点, 半濁点付き片仮名ト
+	combiningNotation = regexp.MustCompile(`^(?:「(.)」に(半?濁点)|(半?濁点)付き(?:平仮名|片仮名)(.))$`)
+
+	noteWidth = strings.NewReplacer("Ｕ＋", "U+", "u+", "U+", "ＶＳ", "VS")
+)
+
+// parseGaijiNote parses a gaiji note of the forms
+// ※［＃「さんずい＋督」、第3水準1-87-3］,
+// ※(「魚＋師のつくり」、第4水準2-93-46),
+// ※［＃「土へん＋竒」、U+2000B］, ※［＃「葛」、U+845B+E0100］
+// and ※［＃「う」に濁点］.
+func parseGaijiNote(s string) (n gaijiNote) {
+
+	s = noteWidth.Replace(s)
+
+	n.jcode = jcodeOfStr(s)
+
+	code := codePointsOf(s)
+
+	s = strings.TrimPrefix(s, "※")
+
+	for _, p := range [][2]string{{"［＃", "］"}, {"(", ")"}, {"（", "）"}} {
+		if strings.HasPrefix(s, p[0]) {
+			s = strings.TrimSuffix(strings.TrimPrefix(s, p[0]), p[1])
+			break
+		}
+	}
+
+	n.desc = gaijiDescription(s)
+
+	switch {
+
+	case code != nil && !isVariationSelector(code[0]):
+		n.unicode = string(code)
+
+	// a variation selector of the character given by the code or
+	// quoted as description
+	case code != nil:
+		base, _ := jptools.Convert(n.jcode)
+		if base == "" && utf8.RuneCountInString(n.desc) == 1 {
+			base = n.desc
+		}
+		if utf8.RuneCountInString(base) == 1 {
+			n.unicode = base + string(code)
+		}
+
+	case n.jcode == "":
+		n.unicode = combiningOf(n.desc)
+	}
+
+	return n
+}
+
+// codePointsOf returns the code points given in s in one of the
+// notations U+XXXX, IVD sequences, or VSn, or nil if there are none or
+// some are invalid.
+func codePointsOf(s string) (code []rune) {
+
+	var fields []string
+
+	switch {
+	case codePointNotation.MatchString(s):
+		m := codePointNotation.FindString(s)
+		fields = strings.FieldsFunc(strings.ReplaceAll(m, "U+", " "), func(r rune) bool {
+			return strings.ContainsRune("+_, ", r)
+		})
+
+	case ivdNotation.MatchString(s):
+		fields = strings.FieldsFunc(ivdNotation.FindString(s), func(r rune) bool {
+			return r == ' ' || r == '_'
+		})
+
+	case vsNotation.MatchString(s):
+		i, _ := strconv.Atoi(vsNotation.FindStringSubmatch(s)[1])
+		switch {
+		case i >= 1 && i <= 16:
+			return []rune{rune(0xFE00 + i - 1)}
+		case i >= 17 && i <= 256:
+			return []rune{rune(0xE0100 + i - 17)}
+		}
+		return nil
+	}
+
+	for _, f := range fields {
+
+		r, err := strconv.ParseUint(f, 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return nil
+		}
+
+		code = append(code, rune(r))
+	}
+
+	return code
+}
+
+func isVariationSelector(r rune) bool {
+	return unicode.Is(unicode.Variation_Selector, r)
+}
+
+// combiningOf returns the combining sequence described by desc, such
+// as 「う」に濁点 or 半濁点付き片仮名ト, or "" if desc describes
+// none.
+func combiningOf(desc string) string {
+
+	m := combiningNotation.FindStringSubmatch(desc)
+	if m == nil {
+		return ""
+	}
+
+	base, mark := m[1]+m[4], m[2]+m[3]
+
+	if mark == "半濁点" {
+		return base + "\u309a"
+	}
+
+	return base + "\u3099"
+}
+
+// gaijiDescription returns the description of a gaiji, which is the
+// note up to the first 、 outside quotes without the quotes around it.
+func gaijiDescription(s string) string {
+
+	depth := 0
+
+loop:
+	for i, r := range s {
+		switch r {
+		case '「':
+			depth++
+		case '」':
+			depth--
+		case '、':
+			if depth == 0 {
+				s = s[:i]
+				break loop
+			}
+		}
+	}
+
+	r := runes.Runes(s)
+	if len(r) > 1 && r[0] == '「' && r[len(r)-1] == '」' && strings.Count(s, "「") == 1 {
+		s = string(r[1 : len(r)-1])
+	}
+
+	return s
+}
+
+// gaijiResolver writes gaiji following policy and records them.
+type gaijiResolver struct {
+	policy GaijiPolicy
+	found  []Gaiji
+}
+
+func newGaijiResolver(p GaijiPolicy) *gaijiResolver {
+
+	if p == nil {
+		p = DefaultGaijiPolicy
+	}
+
+	return &gaijiResolver{policy: p}
+}
+
+// resolve returns the tokens to write the gaiji of note n with, or
+// nil if it is to be left as it is. img is the image of the gaiji in
+// the text if any.
+func (gr *gaijiResolver) resolve(note string, n gaijiNote, img *html.Token) []*html.Token {
+
+	g := Gaiji{Note: note, Code: n.jcode}
+
+	text := n.unicode
+	if text == "" && n.jcode != "" {
+		text, _ = jptools.Convert(n.jcode)
+	}
+
+	var out []*html.Token
+
+	for _, m := range gr.policy {
+
+		switch m {
+
+		case GaijiUnicode:
+			if text != "" && utf8.RuneCountInString(text) == 1 {
+				g.Text = text
+				out = []*html.Token{textToken(text)}
+			}
+
+		case GaijiSequence:
+			if utf8.RuneCountInString(text) > 1 {
+				g.Text = text
+				out = []*html.Token{textToken(text)}
+			}
+
+		case GaijiImage:
+			switch {
+			case img != nil:
+				out = []*html.Token{img}
+			case n.jcode != "":
+				out = []*html.Token{gaijiImage(n.jcode, note)}
+			}
+			if out != nil {
+				g.Text = getAttr(out[0], "src")
+				setAttr(out[0], "class", "gaiji")
+				setAttr(out[0], "data-gaiji", strconv.Itoa(len(gr.found)))
+			}
+
+		case GaijiDescription:
+			if n.desc != "" {
+				g.Text = "〔" + n.desc + "〕"
+				out = descriptionTokens(g.Text)
+			}
+		}
+
+		if out != nil {
+			g.Method = m
+			break
+		}
+	}
+
+	gr.found = append(gr.found, g)
+
+	log.Println("Gaiji.", note, "written as", g.Method, g.Text)
+
+	return out
+}
+
+// gaijiFallback returns the tokens to write the gaiji with index i
+// with if its image cannot be added, or nil if there is no other way
+// in the policy of b.
+func (b *Book) gaijiFallback(i int) []*html.Token {
+
+	policy := newGaijiResolver(b.GaijiPolicy).policy
+
+	g := &b.Gaiji[i]
+
+	n := parseGaijiNote(g.Note)
+
+	after := false
+
+	for _, m := range policy {
+		if after && m == GaijiDescription && n.desc != "" {
+			g.Text = "〔" + n.desc + "〕"
+			g.Method = m
+			return descriptionTokens(g.Text)
+		}
+		after = after || m == GaijiImage
+	}
+
+	g.Method = GaijiUnresolved
+
+	return nil
+}
+
+// gaijiImage returns an img token for the Aozora Bunko image of the
+// gaiji with the 面-区-点 code jcode.
+func gaijiImage(jcode, alt string) *html.Token {
+
+	c := strings.Split(jcode, "-")
+	for i := 1; i < len(c); i++ {
+		if len(c[i]) < 2 {
+			c[i] = "0" + c[i]
+		}
+	}
+
+	t := new(html.Token)
+	t.Type = html.SelfClosingTagToken
+	t.Data = "img"
+	t.DataAtom = atom.Img
+
+	setAttr(t, "src", "../../../gaiji/"+c[0]+"-"+c[1]+"/"+strings.Join(c, "-")+".png")
+	setAttr(t, "alt", alt)
+
+	return t
+}
+
+func textToken(s string) *html.Token {
+
+	t := new(html.Token)
+	t.Type = html.TextToken
+	t.Data = s
+
+	return t
+}
+
+func descriptionTokens(desc string) []*html.Token {
+	return tokenize([]byte(`<span class="gaiji_desc">` + html.EscapeString(desc) + `</span>`))
+}
+
+// isGaijiImage reports whether t is the image of a gaiji.
+func isGaijiImage(t *html.Token) bool {
+	return isImg(t) && classOf(t) == "gaiji"
+}
+
+// fixGaijiImage writes the gaiji image t as given by the policy of gr.
+func (gr *gaijiResolver) fixGaijiImage(t *html.Token) []*html.Token {
+
+	// images made for notes are done
+	if getAttr(t, "data-gaiji") != "" {
+		return []*html.Token{t}
+	}
+
+	alt := getAttr(t, "alt")
+
+	n := parseGaijiNote(alt)
+
+	if jcode := getAttr(t, "data-jcode"); jcode != "" {
+		n.jcode = jcode
+	}
+
+	if n.jcode == "" {
+		n.jcode = strings.TrimSuffix(filepath.Base(getAttr(t, "src")), ".png")
+		if _, err := jptools.MktToJis(n.jcode); err != nil {
+			n.jcode = ""
+		}
+	}
+
+	delAttr(t, "data-jcode")
+
+	out := gr.resolve(alt, n, t)
+	if out == nil {
+		return []*html.Token{t}
+	}
+
+	return out
+}
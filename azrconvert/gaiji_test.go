@@ -0,0 +1,161 @@
+package azrconvert
+
+import (
+	"strings"
+	"testing"
+)
+
+const gaijiTestPage = `<html><head><title>test</title></head>
+<body>
+<div class="main_text">一※<span class="notes">［＃「丸1」、1-13-1］</span>二<img src="../../../gaiji/1-13/1-13-01.png" alt="※(「丸1」、1-13-1)" class="gaiji" />三※<span class="notes">［＃「口＋世」、36-上-8］</span>四※<span class="notes">［＃半濁点付き平仮名か、1-4-87］</span>五<br />
+</div>
+</body></html>`
+
+const gaijiTestURI = "https://www.aozora.gr.jp/cards/000001/files/1_1.html"
+
+const gaijiTestImage = "https://www.aozora.gr.jp/gaiji/1-13/1-13-01.png"
+
+func TestGaijiPolicy(t *testing.T) {
+
+	tests := []struct {
+		name    string
+		policy  GaijiPolicy
+		images  bool
+		nimages int
+		want    []string
+		// notes come before images
+		methods []GaijiMethod
+	}{
+		{
+			"default", nil, true, 0,
+			[]string{"一①二①三", `<span class="gaiji_desc">〔口＋世〕</span>四か゚五`},
+			[]GaijiMethod{GaijiUnicode, GaijiDescription, GaijiSequence, GaijiUnicode},
+		},
+		{
+			"images", GaijiPolicy{GaijiImage, GaijiDescription}, true, 1,
+			[]string{`一<img class="gaiji" src="00001.png" alt="※［＃「丸1」、1-13-1］"/>二<img class="gaiji" src="00001.png"`, "〔半濁点付き平仮名か〕"},
+			[]GaijiMethod{GaijiImage, GaijiDescription, GaijiDescription, GaijiImage},
+		},
+		{
+			"missing images", GaijiPolicy{GaijiImage, GaijiDescription}, false, 0,
+			[]string{"一<span class=\"gaiji_desc\">〔丸1〕</span>二"},
+			[]GaijiMethod{GaijiDescription, GaijiDescription, GaijiDescription, GaijiDescription},
+		},
+		{
+			"unicode only", GaijiPolicy{GaijiUnicode}, true, 0,
+			[]string{"一①二①三", "※［＃「口＋世」、36-上-8］", "※［＃半濁点付き平仮名か、1-4-87］"},
+			[]GaijiMethod{GaijiUnicode, GaijiUnresolved, GaijiUnresolved, GaijiUnicode},
+		},
+	}
+
+	for _, tt := range tests {
+
+		bk := NewBook()
+		bk.GaijiPolicy = tt.policy
+		bk.SetURI(gaijiTestURI)
+
+		files := MapFetcher{}
+		if tt.images {
+			files[gaijiTestImage] = testPNG(t)
+		}
+		bk.SetFetcher(files)
+
+		err := bk.getBookFrom(cleanUTF8([]byte(gaijiTestPage)))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		body := renderTokens(bk.Body)
+
+		for _, s := range tt.want {
+			if !strings.Contains(body, s) {
+				t.Errorf("%s: body lacks %s:\n%s", tt.name, s, body)
+			}
+		}
+
+		if len(bk.Gaiji) != len(tt.methods) {
+			t.Fatalf("%s: %d gaiji recorded: %v", tt.name, len(bk.Gaiji), bk.Gaiji)
+		}
+
+		unresolved := 0
+		for i, g := range bk.Gaiji {
+			if g.Method != tt.methods[i] {
+				t.Errorf("%s: %s written as %s, want %s", tt.name, g.Note, g.Method, tt.methods[i])
+			}
+			if g.Method != GaijiUnicode && g.Method != GaijiSequence {
+				unresolved++
+			}
+		}
+
+		if n := len(bk.UnresolvedGaiji()); n != unresolved {
+			t.Errorf("%s: %d unresolved gaiji, want %d", tt.name, n, unresolved)
+		}
+
+		if len(bk.Images) != tt.nimages {
+			t.Errorf("%s: %d images, want %d", tt.name, len(bk.Images), tt.nimages)
+		}
+	}
+}
+
+func TestParseGaijiPolicy(t *testing.T) {
+
+	p, err := ParseGaijiPolicy("unicode, image,description")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p) != 3 || p[0] != GaijiUnicode || p[1] != GaijiImage || p[2] != GaijiDescription {
+		t.Errorf("got %v", p)
+	}
+
+	if _, err := ParseGaijiPolicy("unicode,png"); err == nil {
+		t.Error("no error for unknown method")
+	}
+}
+
+func TestParseGaijiNote(t *testing.T) {
+
+	tests := []struct {
+		note  string
+		desc  string
+		jcode string
+		text  string
+	}{
+		{"※［＃「さんずい＋督」、第3水準1-87-3］", "さんずい＋督", "1-87-3", ""},
+		{"※(「魚＋師のつくり」、第4水準2-93-46)", "魚＋師のつくり", "2-93-46", ""},
+		{"※［＃「丸1」、1-13-1］", "丸1", "1-13-1", ""},
+		{"※［＃「土へん＋竒」、U+57FC］", "土へん＋竒", "", "埼"},
+		// five digits
+		{"※［＃「土へん＋竒」、U+2000B、123-4］", "土へん＋竒", "", "\U0002000b"},
+		{"※［＃「口＋七」、Ｕ＋20089］", "口＋七", "", "\U00020089"},
+		// several code points
+		{"※［＃「か」に半濁点、U+304B+309A］", "「か」に半濁点", "", "か゚"},
+		{"※［＃「こ」に半濁点、U+3053 U+309A］", "「こ」に半濁点", "", "こ゚"},
+		// variation selectors
+		{"※［＃「葛」、U+845B+E0100］", "葛", "", "葛\U000e0100"},
+		{"※［＃「葛」、U+845B_U+E0101］", "葛", "", "葛\U000e0101"},
+		{"※［＃「辻」のAdobe-Japan1の字形、8FBB E0100; Adobe-Japan1; CID+3056］", "「辻」のAdobe-Japan1の字形", "", "辻\U000e0100"},
+		{"※［＃「辻」、Hanyo-Denshi、U+E0101］", "辻", "", "辻\U000e0101"},
+		{"※［＃「葛」、VS18］", "葛", "", "葛\U000e0101"},
+		{"※［＃「邊」、VS2］", "邊", "", "邊︁"},
+		// the selector applies to the character of the code
+		{"※［＃「さんずい＋督」、第3水準1-87-3、U+E0100］", "さんずい＋督", "1-87-3", "\u6f10\U000e0100"},
+		// JIS X 0213 combining sequences
+		{"※［＃半濁点付き平仮名か、1-4-87］", "半濁点付き平仮名か", "1-4-87", ""},
+		{"※［＃「う」に濁点］", "「う」に濁点", "", "ゔ"},
+		{"※［＃半濁点付き片仮名ト］", "半濁点付き片仮名ト", "", "ト゚"},
+		// invalid code points
+		{"※［＃「口＋世」、U+D800］", "口＋世", "", ""},
+		{"※［＃「口＋世」、U+110000］", "口＋世", "", ""},
+		{"※［＃「口＋世」、36-上-8］", "口＋世", "", ""},
+	}
+
+	for _, tt := range tests {
+
+		n := parseGaijiNote(tt.note)
+
+		if n.desc != tt.desc || n.jcode != tt.jcode || n.unicode != tt.text {
+			t.Errorf("%s: got %q %q %+q, want %q %q %+q", tt.note, n.desc, n.jcode, n.unicode, tt.desc, tt.jcode, tt.text)
+		}
+	}
+}
@@ -4,51 +4,319 @@ import (
 	_ "embed" //for embedding font file.
 	"encoding/base64"
 	"encoding/binary"
+	"image"
 	"image/color"
 	"log"
 	"strings"
 
 	"github.com/adamay909/AozoraConvert/drawtext"
+	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
 )
 
 //go:embed resources/jpfont.otf
 var fontdata []byte
 
+// CoverLayout is the arrangement of title, author, and publisher on a
+// cover made by GenCover.
+type CoverLayout int
+
+const (
+	// CoverClassic has the title written horizontally in the
+	// middle, the author at the top left, and the publisher at the
+	// bottom right.
+	CoverClassic CoverLayout = iota
+
+	// CoverCentered has title, author, and publisher written
+	// horizontally and centred.
+	CoverCentered
+
+	// CoverVertical has the title written vertically (縦書き) on the
+	// right with the reading next to it, the author in a column to
+	// the left of the title, and the publisher at the bottom.
+	CoverVertical
+)
+
+// Placement overrides where a layout puts the author or the
+// publisher.
+type Placement int
+
+const (
+	PlaceDefault Placement = iota
+	PlaceTop
+	PlaceBottom
+	PlaceHidden
+)
+
+// ColorPair is a background colour with a matching colour for text.
+type ColorPair struct {
+	Background, Foreground color.Color
+}
+
+// DefaultPalette holds the colours of the default covers. As on the
+// covers of earlier versions, the first three pairs are each taken for
+// a fifth of the books and the last pair for the rest.
+var DefaultPalette = []ColorPair{
+	{color.RGBA{196, 216, 196, 255}, color.RGBA{18, 66, 18, 255}},
+	{color.RGBA{246, 234, 245, 255}, color.RGBA{79, 26, 74, 255}},
+	{color.RGBA{201, 208, 242, 255}, color.RGBA{40, 49, 92, 255}},
+	{color.RGBA{245, 236, 220, 255}, color.RGBA{73, 59, 34, 255}},
+}
+
+// CoverOptions control the cover made by GenCover. The zero value
+// gives the default cover of GenTitlePage.
+type CoverOptions struct {
+	// Width and Height of the cover in pixels. The default is
+	// 1200×1600.
+	Width, Height int
+
+	// Palette holds the colours to choose from. The pair is picked
+	// by the hash of the text, so that all books of a series get
+	// the same colours if Palette has a single pair. The default is
+	// DefaultPalette.
+	Palette []ColorPair
+
+	// Font is an OpenType or TrueType font file or collection. The
+	// default is the font embedded in the package.
+	Font []byte
+
+	// FallbackFonts are font files used in turn for characters
+	// missing from Font, such as gaiji. The embedded font is always
+	// the last fallback.
+	FallbackFonts [][]byte
+
+	// TitleSize, AuthorSize, and SmallSize are the sizes in points
+	// of the title, of the author, and of the reading of the title
+	// and the publisher. The defaults are 28, 18, and 16.
+	TitleSize, AuthorSize, SmallSize int
+
+	Layout CoverLayout
+
+	// Author and Publisher override where Layout puts the author
+	// and the publisher.
+	Author, Publisher Placement
+
+	// BackgroundImage, if set, is drawn under the text, scaled to
+	// cover the whole cover.
+	BackgroundImage image.Image
+
+	// Ruby writes the reading of the title as ruby (furigana) over
+	// it, or to the right of it in CoverVertical, instead of
+	// separately. Titles broken in several parts keep the separate
+	// reading.
+	Ruby bool
+}
+
 // GenTitlePage() generaltes a tile page for book. Relies on the
 // presence of metadata in b so should be called after setting metadata.
-func (b *Book) GenTitlePage() {
+func (b *Book) GenTitlePage() error {
+	return b.GenCover(CoverOptions{})
+}
+
+// GenCover makes the cover image of b as given by opts. Like
+// GenTitlePage, it should be called after setting metadata.
+func (b *Book) GenCover(opts CoverOptions) error {
+
+	opts.setDefaults()
+
+	bg, fg := b.colorpair(opts.Palette)
+
+	canvas := drawtext.NewCanvas(bg, opts.Width, opts.Height)
+
+	if opts.BackgroundImage != nil {
+		drawtext.DrawBackground(canvas, opts.BackgroundImage)
+	}
 
-	bg, fg := b.colorpair()
-	xsize := 1200
-	ysize := 1600
+	fonts, err := parseFonts(opts.Font, opts.FallbackFonts)
+	if err != nil {
+		return err
+	}
 
-	canvas := drawtext.NewCanvas(bg, xsize, ysize)
+	c := &cover{
+		Book:   b,
+		opts:   opts,
+		canvas: canvas,
+		fg:     fg,
+		normal: makeFace(fonts, opts.AuthorSize),
+		small:  makeFace(fonts, opts.SmallSize),
+		large:  makeFace(fonts, opts.TitleSize),
+	}
 
-	normalfont := drawtext.MakeNewFontFace(getFont(), 18)
-	smallfont := drawtext.MakeNewFontFace(getFont(), 16)
-	largefont := drawtext.MakeNewFontFace(getFont(), 28)
+	switch opts.Layout {
+	case CoverCentered:
+		c.centered()
+	case CoverVertical:
+		c.vertical()
+	default:
+		c.classic()
+	}
 
-	drawtext.SetCursor(canvas, 0, 0)
+	b.CoverImage = drawtext.ImageOf(canvas)
 
-	drawtext.WriteLine(b.Creator, "left", normalfont, fg, canvas)
+	return nil
+}
 
-	l, _ := drawtext.BreakLines(b.Title, largefont, canvas)
+func (opts *CoverOptions) setDefaults() {
 
-	drawtext.SetCursor(canvas, 0, 0.3-float64((len(l)-1)*3/40))
+	if opts.Width <= 0 || opts.Height <= 0 {
+		opts.Width, opts.Height = 1200, 1600
+	}
 
-	titleLines := breakTitle(b.Title)
+	if opts.TitleSize <= 0 {
+		opts.TitleSize = 28
+	}
 
-	for _, t := range titleLines {
-		drawtext.AddCenteredText(t, largefont, fg, canvas)
+	if opts.AuthorSize <= 0 {
+		opts.AuthorSize = 18
 	}
-	drawtext.SetCursor(canvas, 0, 0.9)
 
-	drawtext.WriteLine(b.Publisher, "right", smallfont, fg, canvas)
+	if opts.SmallSize <= 0 {
+		opts.SmallSize = 16
+	}
+}
 
-	b.CoverImage = drawtext.ImageOf(canvas)
+// cover holds what the layouts need to draw a cover.
+type cover struct {
+	*Book
+	opts                 CoverOptions
+	canvas               *drawtext.Canvas
+	fg                   color.Color
+	normal, small, large font.Face
+}
+
+// classic lays out the cover as it has always been: author at the
+// top left, title in the middle, publisher at the bottom right.
+func (c *cover) classic() {
+
+	switch c.opts.Author {
+	case PlaceDefault, PlaceTop:
+		drawtext.SetCursor(c.canvas, 0, 0)
+		drawtext.WriteLine(c.Creator, "left", c.normal, c.fg, c.canvas)
+	case PlaceBottom:
+		drawtext.SetCursor(c.canvas, 0, 0.8)
+		drawtext.WriteLine(c.Creator, "left", c.normal, c.fg, c.canvas)
+	}
+
+	c.centeredTitle()
+
+	switch c.opts.Publisher {
+	case PlaceDefault, PlaceBottom:
+		drawtext.SetCursor(c.canvas, 0, 0.9)
+		drawtext.WriteLine(c.Publisher, "right", c.small, c.fg, c.canvas)
+	case PlaceTop:
+		drawtext.SetCursor(c.canvas, 0, 0)
+		drawtext.WriteLine(c.Publisher, "right", c.small, c.fg, c.canvas)
+	}
+}
+
+// centered lays out the cover with everything centred.
+func (c *cover) centered() {
+
+	if c.opts.Author == PlaceTop {
+		drawtext.SetCursor(c.canvas, 0, 0)
+		drawtext.AddCenteredText(c.Creator, c.normal, c.fg, c.canvas)
+	}
+
+	if c.opts.Publisher == PlaceTop {
+		drawtext.SetCursor(c.canvas, 0, 0)
+		drawtext.AddCenteredText(c.Publisher, c.small, c.fg, c.canvas)
+	}
+
+	c.centeredTitle()
+
+	if c.opts.Author == PlaceDefault {
+		drawtext.SetCursor(c.canvas, 0, 0.55)
+		drawtext.AddCenteredText(c.Creator, c.normal, c.fg, c.canvas)
+	}
+
+	if c.opts.Author == PlaceBottom {
+		drawtext.SetCursor(c.canvas, 0, 0.8)
+		drawtext.AddCenteredText(c.Creator, c.normal, c.fg, c.canvas)
+	}
+
+	if c.opts.Publisher == PlaceDefault || c.opts.Publisher == PlaceBottom {
+		drawtext.SetCursor(c.canvas, 0, 0.9)
+		drawtext.AddCenteredText(c.Publisher, c.small, c.fg, c.canvas)
+	}
+}
+
+// centeredTitle writes the title and its reading horizontally in
+// the upper middle of the cover.
+func (c *cover) centeredTitle() {
+
+	l, _ := drawtext.BreakLines(c.Title, c.large, c.canvas)
+
+	drawtext.SetCursor(c.canvas, 0, 0.3-float64((len(l)-1)*3/40))
+
+	if c.rubyTitle() {
+		drawtext.WriteSegments(c.titleSegments(), "center", c.large, c.fg, c.canvas)
+		return
+	}
+
+	for _, t := range breakTitle(c.Title) {
+		drawtext.AddCenteredText(t, c.large, c.fg, c.canvas)
+	}
+
+	if c.TitleReading != "" {
+		drawtext.AddCenteredText(c.TitleReading, c.small, c.fg, c.canvas)
+	}
+}
+
+// rubyTitle reports whether the reading is written as ruby of the
+// title.
+func (c *cover) rubyTitle() bool {
+	return c.opts.Ruby && c.TitleReading != "" && len(breakTitle(c.Title)) == 1
+}
+
+// titleSegments returns the title with its reading as ruby.
+func (c *cover) titleSegments() []drawtext.Segment {
+	return []drawtext.Segment{{Text: c.Title, Ruby: c.TitleReading}}
+}
+
+// vertical lays out the cover for vertical writing: the title in a
+// column on the right with its reading to the right of it and the
+// author in a column to the left.
+func (c *cover) vertical() {
+
+	x, top := 0.8, 0.05
+	if c.opts.Publisher == PlaceTop {
+		top = 0.12
+	}
+
+	switch {
+	case c.rubyTitle():
+		x -= drawtext.WriteColumnSegments(c.titleSegments(), c.large, c.fg, c.canvas, x, top)
+
+	default:
+		if c.TitleReading != "" {
+			drawtext.WriteColumn(c.TitleReading, c.small, c.fg, c.canvas, x+0.1, top)
+		}
+
+		for _, t := range breakTitle(c.Title) {
+			x -= drawtext.WriteColumn(t, c.large, c.fg, c.canvas, x, top)
+		}
+	}
+
+	x -= 0.1
+
+	switch c.opts.Author {
+	case PlaceDefault:
+		drawtext.WriteColumn(c.Creator, c.normal, c.fg, c.canvas, x, 0.4)
+	case PlaceTop:
+		drawtext.WriteColumn(c.Creator, c.normal, c.fg, c.canvas, x, top)
+	case PlaceBottom:
+		y := 0.85 - drawtext.ColumnHeight(c.Creator, c.normal, c.canvas)
+		drawtext.WriteColumn(c.Creator, c.normal, c.fg, c.canvas, x, max(y, top))
+	}
 
-	//	return drawtext.RenderCanvas(canvas, "png")
+	switch c.opts.Publisher {
+	case PlaceDefault, PlaceBottom:
+		drawtext.SetCursor(c.canvas, 0, 0.9)
+		drawtext.AddCenteredText(c.Publisher, c.small, c.fg, c.canvas)
+	case PlaceTop:
+		drawtext.SetCursor(c.canvas, 0, 0)
+		drawtext.AddCenteredText(c.Publisher, c.small, c.fg, c.canvas)
+	}
 }
 
 func breakTitle(in string) (out []string) {
@@ -59,44 +327,99 @@ func breakTitle(in string) (out []string) {
 	return
 }
 
-func (b *Book) colorpair() (bg, fg color.Color) {
+// colorpair picks the colours of the cover from palette by the hash
+// of the text of b.
+func (b *Book) colorpair(palette []ColorPair) (bg, fg color.Color) {
 
 	bh, err := base64.StdEncoding.DecodeString(b.Hash)
 
 	if err != nil {
 		log.Println(err)
-		return
 	}
 
-	n, _ := binary.Varint(bh[:4])
+	var n int64
 
-	r := n % 5
+	if len(bh) >= 4 {
+		n, _ = binary.Varint(bh[:4])
+	}
 
-	switch r {
-	case 0:
-		bg = color.RGBA{R: uint8(196), G: uint8(216), B: uint8(196), A: uint8(255)}
-		fg = color.RGBA{R: uint8(18), G: uint8(66), B: uint8(18), A: uint8(255)}
+	// the default covers have always taken the first pairs for
+	// n%5 = 0, 1, 2 and the last pair otherwise
+	if len(palette) == 0 {
+		i := int(n % 5)
+		if i < 0 || i >= len(DefaultPalette)-1 {
+			i = len(DefaultPalette) - 1
+		}
+		p := DefaultPalette[i]
+		return p.Background, p.Foreground
+	}
 
-	case 1:
-		bg = color.RGBA{R: uint8(246), G: uint8(234), B: uint8(245), A: uint8(255)}
-		fg = color.RGBA{R: uint8(79), G: uint8(26), B: uint8(74), A: uint8(255)}
+	if n < 0 {
+		n = -n
+	}
 
-	case 2:
-		bg = color.RGBA{R: uint8(201), G: uint8(208), B: uint8(242), A: uint8(255)}
-		fg = color.RGBA{R: uint8(40), G: uint8(49), B: uint8(92), A: uint8(255)}
+	p := palette[n%int64(len(palette))]
 
-	default:
-		bg = color.RGBA{R: uint8(245), G: uint8(236), B: uint8(220), A: uint8(255)}
-		fg = color.RGBA{R: uint8(73), G: uint8(59), B: uint8(34), A: uint8(255)}
+	return p.Background, p.Foreground
+}
+
+func getFont() (*opentype.Font, error) {
+	return opentype.Parse(fontdata)
+}
+
+// parseFonts parses the font file main and the fallbacks, ending the
+// list with the embedded font if main is given.
+func parseFonts(main []byte, fallbacks [][]byte) ([]*opentype.Font, error) {
+
+	var fonts []*opentype.Font
+
+	for _, data := range append([][]byte{main}, fallbacks...) {
+		fnt, err := parseFont(data)
+		if err != nil {
+			return nil, err
+		}
+		fonts = append(fonts, fnt)
 	}
 
-	return
+	if len(main) > 0 {
+		fnt, err := getFont()
+		if err != nil {
+			return nil, err
+		}
+		fonts = append(fonts, fnt)
+	}
+
+	return fonts, nil
 }
 
-func getFont() *opentype.Font {
-	fontd, err := opentype.Parse(fontdata)
-	if err != nil {
-		log.Println(err)
+// makeFace makes a face of size from fonts, falling back on the later
+// fonts for characters missing from the first.
+func makeFace(fonts []*opentype.Font, size int) font.Face {
+
+	if len(fonts) == 1 {
+		return drawtext.MakeNewFontFace(fonts[0], size)
+	}
+
+	return drawtext.MakeFaceChain(size, fonts...)
+}
+
+// parseFont parses the font file data, using the first font of
+// collections. Without data, it returns the embedded font.
+func parseFont(data []byte) (*opentype.Font, error) {
+
+	if len(data) == 0 {
+		return getFont()
+	}
+
+	fnt, err := opentype.Parse(data)
+	if err == nil {
+		return fnt, nil
 	}
-	return fontd
+
+	coll, cerr := opentype.ParseCollection(data)
+	if cerr != nil {
+		return nil, err
+	}
+
+	return coll.Font(0)
 }
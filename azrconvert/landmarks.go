@@ -0,0 +1,143 @@
+package azrconvert
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// landmark is a location in the book declared in the landmarks of the
+// EPUB navigation document and in the guides of the OPF and AZW3
+// files. Readers use the start of the text to open books and for "Go
+// to Beginning".
+type landmark struct {
+	// epubType is the type of the landmark in the navigation
+	// document, guideType its type in the guides.
+	epubType, guideType string
+
+	title string
+
+	// token starts the landmark in b.Body. It is nil for the title
+	// page of EPUB files.
+	token *html.Token
+}
+
+// landmarks returns the landmarks of b: the title page showing the
+// cover image, the block with title and author at the start of the
+// body, the table of contents page if there is one, and the start of
+// the main text.
+func (b *Book) landmarks() []landmark {
+
+	lm := []landmark{{epubType: "cover", guideType: "cover", title: "表紙"}}
+
+	meta, toc, text := landmarkTokens(b.Body)
+
+	if meta != nil && hasID(meta) {
+		lm = append(lm, landmark{"titlepage", "title-page", "扉", meta})
+	}
+
+	if toc != nil {
+		lm = append(lm, landmark{"toc", "toc", "目次", toc})
+	}
+
+	if text != nil && hasID(text) {
+		lm = append(lm, landmark{"bodymatter", "text", "本文", text})
+	}
+
+	return lm
+}
+
+// landmarkTokens returns the start of the block with title and
+// author, the start of the table of contents page, and the start of
+// the main text in body. The main text starts with the body if there
+// is no div.main_text.
+func landmarkTokens(body []*html.Token) (meta, toc, text *html.Token) {
+
+	for _, t := range body {
+
+		if t.Type != html.StartTagToken || t.DataAtom != atom.Div {
+			continue
+		}
+
+		if getAttr(t, "id") == tocPageID {
+			toc = t
+		}
+
+		switch classOf(t) {
+		case "metadata":
+			if meta == nil {
+				meta = t
+			}
+		case "main_text":
+			if text == nil {
+				text = t
+			}
+		}
+	}
+
+	if text == nil && len(body) > 0 {
+		text = body[0]
+	}
+
+	return meta, toc, text
+}
+
+// insertLandmarkID gives the elements starting landmarks an id if
+// they have none.
+func insertLandmarkID(body []*html.Token) {
+
+	meta, _, text := landmarkTokens(body)
+
+	if meta != nil && !hasID(meta) {
+		setAttr(meta, "id", "azbc_titlepage")
+	}
+
+	if text != nil && !hasID(text) {
+		setAttr(text, "id", "azbc_bodymatter")
+	}
+}
+
+// landmarkHref returns the link to l in the EPUB.
+func (b *Book) landmarkHref(l landmark) string {
+
+	if l.token == nil {
+		return "title.html"
+	}
+
+	return b.href(getID(l.token))
+}
+
+// RenderLandmarks returns the landmarks nav element of the EPUB
+// navigation document.
+func (b *Book) RenderLandmarks() string {
+
+	w := new(strings.Builder)
+
+	w.WriteString("<nav epub:type=\"landmarks\" hidden=\"hidden\">\n<ol>\n")
+
+	for _, l := range b.landmarks() {
+		w.WriteString(`<li><a epub:type="` + l.epubType + `" href="` + b.landmarkHref(l) + `">` + html.EscapeString(l.title) + "</a></li>\n")
+	}
+
+	w.WriteString("</ol>\n</nav>\n")
+
+	return w.String()
+}
+
+// RenderGuide returns the guide element of the OPF file for reading
+// systems that do not know EPUB 3 landmarks.
+func (b *Book) RenderGuide() string {
+
+	w := new(strings.Builder)
+
+	w.WriteString("<guide>\n")
+
+	for _, l := range b.landmarks() {
+		w.WriteString(`   <reference type="` + l.guideType + `" title="` + html.EscapeString(l.title) + `" href="` + b.landmarkHref(l) + "\"/>\n")
+	}
+
+	w.WriteString("  </guide>")
+
+	return w.String()
+}
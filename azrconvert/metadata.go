@@ -0,0 +1,588 @@
+package azrconvert
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adamay909/AozoraConvert/jptools"
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Role is the part a person played in making a book. Roles are
+// given as MARC relator codes so they can be used in the OPF file
+// as they are.
+type Role string
+
+// Roles used for books from Aozora Bunko.
+const (
+	RoleAuthor      Role = "aut"
+	RoleTranslator  Role = "trl"
+	RoleEditor      Role = "edt"
+	RoleIllustrator Role = "ill"
+	RoleTranscriber Role = "trc" // 入力
+	RoleProofreader Role = "pfr" // 校正
+)
+
+// Label returns the Japanese name of r.
+func (r Role) Label() string {
+
+	switch r {
+	case RoleAuthor:
+		return "著者"
+	case RoleTranslator:
+		return "翻訳"
+	case RoleEditor:
+		return "編集"
+	case RoleIllustrator:
+		return "挿絵"
+	case RoleTranscriber:
+		return "入力"
+	case RoleProofreader:
+		return "校正"
+	default:
+		return string(r)
+	}
+}
+
+// Person is a creator of or contributor to a book.
+type Person struct {
+	Name   string
+	FileAs string // reading of the name, e.g. あくたがわ りゅうのすけ
+	Role   Role
+}
+
+// aozoraBunko is the publisher of all books from Aozora Bunko.
+const aozoraBunko = "青空文庫"
+
+// PublicDomain is the default value of Book.Rights.
+const PublicDomain = "Public domain"
+
+// metadataRoles maps the classes used in div.metadata of Aozora
+// Bunko's xhtml files to roles.
+var metadataRoles = map[string]Role{
+	"author":     RoleAuthor,
+	"translator": RoleTranslator,
+	"editor":     RoleEditor,
+	"henyaku":    RoleTranslator,
+}
+
+// AllCreators returns the creators of b. If b.Creators is empty but
+// b.Creator is set, b.Creator is returned as the author.
+func (b *Book) AllCreators() []Person {
+
+	if len(b.Creators) == 0 && b.Creator != "" {
+		return []Person{{Name: b.Creator, Role: RoleAuthor}}
+	}
+
+	return b.Creators
+}
+
+// AddCreator adds p to the creators of b unless a creator with the
+// same name and role is already present. If p has a reading and the
+// existing creator does not, the reading is added.
+func (b *Book) AddCreator(p Person) {
+	b.Creators = addPerson(b.Creators, p)
+	return
+}
+
+// AddContributor adds p to the contributors of b, e.g. the people who
+// input and proofread the text, unless p is already present.
+func (b *Book) AddContributor(p Person) {
+	b.Contributors = addPerson(b.Contributors, p)
+	return
+}
+
+func addPerson(people []Person, p Person) []Person {
+
+	if p.Name == "" {
+		return people
+	}
+
+	for i := range people {
+		if people[i].Role == p.Role && sameName(people[i].Name, p.Name) {
+			if people[i].FileAs == "" {
+				people[i].FileAs = p.FileAs
+			}
+			return people
+		}
+	}
+
+	return append(people, p)
+}
+
+// sameName reports whether a and b are the same name written with or
+// without spaces between family and given name.
+func sameName(a, b string) bool {
+
+	strip := func(s string) string {
+		return strings.Join(strings.Fields(s), "")
+	}
+
+	return strip(a) == strip(b)
+}
+
+// AddSubject adds s to the subjects of b unless it is already present.
+func (b *Book) AddSubject(s string) {
+
+	if s == "" {
+		return
+	}
+
+	for _, e := range b.Subjects {
+		if e == s {
+			return
+		}
+	}
+
+	b.Subjects = append(b.Subjects, s)
+
+	return
+}
+
+// SetTitleReading sets the reading of the title to r.
+func (b *Book) SetTitleReading(r string) {
+	b.TitleReading = r
+	return
+}
+
+// SetCreatorReading sets the reading of the name of the first
+// author to r.
+func (b *Book) SetCreatorReading(r string) {
+
+	b.Creators = b.AllCreators()
+
+	for i := range b.Creators {
+		if b.Creators[i].Role == RoleAuthor {
+			b.Creators[i].FileAs = r
+			return
+		}
+	}
+
+	return
+}
+
+// SetPublisherReading sets the reading of the publisher to r.
+func (b *Book) SetPublisherReading(r string) {
+	b.PublisherReading = r
+	return
+}
+
+// CreatorReading returns the reading of the name of the first author.
+func (b *Book) CreatorReading() string {
+
+	for _, p := range b.AllCreators() {
+		if p.Role == RoleAuthor {
+			return p.FileAs
+		}
+	}
+
+	return ""
+}
+
+// publisherReading returns the reading of the publisher. The reading
+// of 青空文庫 is known.
+func (b *Book) publisherReading() string {
+
+	if b.PublisherReading == "" && b.Publisher == aozoraBunko {
+		return "あおぞらぶんこ"
+	}
+
+	return b.PublisherReading
+}
+
+// furigana returns the reading r in katakana as used for sorting
+// by e-readers.
+func furigana(r string) string {
+	return strings.Map(jptools.ToKatakana, r)
+}
+
+// authors returns the names of the creators of b that are authors
+// and the readings of the names in katakana.
+func (b *Book) authors() (a, readings []string) {
+
+	for _, p := range b.AllCreators() {
+		if p.Role == RoleAuthor {
+			a = append(a, p.Name)
+			readings = append(readings, furigana(p.FileAs))
+		}
+	}
+
+	return
+}
+
+// contributors returns the creators of b that are not authors and
+// the contributors of b in the form 役割：名前.
+func (b *Book) contributors() (c []string) {
+
+	for _, p := range append(b.AllCreators(), b.Contributors...) {
+		if p.Role != RoleAuthor {
+			c = append(c, p.Role.Label()+"："+p.Name)
+		}
+	}
+
+	return
+}
+
+// Description returns a short description of b made from the
+// information about its first publication and orthography.
+func (b *Book) Description() string {
+
+	var d []string
+
+	if b.FirstPublished != "" {
+		d = append(d, "初出："+b.FirstPublished)
+	}
+
+	if b.Orthography != "" {
+		d = append(d, "文字遣い種別："+b.Orthography)
+	}
+
+	return strings.Join(d, "\n")
+}
+
+// NDC returns the class of the Nippon Decimal Classification given
+// by subject, e.g. 913 for "NDC 913". If subject is not an NDC class,
+// it returns the empty string.
+func NDC(subject string) string {
+
+	f := strings.Fields(subject)
+
+	if len(f) == 2 && f[0] == "NDC" {
+		return f[1]
+	}
+
+	return ""
+}
+
+// publishedDate returns b.Date as time.
+func (b *Book) publishedDate() time.Time {
+
+	t, err := time.Parse("2006-01-02", b.Date)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return t
+}
+
+// DCMeta returns html meta elements giving the metadata of b
+// following the conventions of Aozora Bunko's xhtml files.
+func (b *Book) DCMeta() string {
+
+	w := new(strings.Builder)
+
+	meta := func(name, content string) {
+		if content != "" {
+			fmt.Fprintf(w, "    <meta name=\"%s\" content=\"%s\"/>\n", name, html.EscapeString(content))
+		}
+	}
+
+	meta("DC.Title", b.Title)
+
+	for _, p := range b.AllCreators() {
+		meta("DC.Creator", p.Name)
+	}
+
+	meta("DC.Publisher", b.Publisher)
+	meta("DC.Source", b.Source)
+	meta("DC.Date", b.Date)
+	meta("DC.Rights", b.Rights)
+
+	for _, s := range b.Subjects {
+		meta("DC.Subject", s)
+	}
+
+	return strings.TrimSpace(w.String())
+}
+
+// OPFMetadata returns the elements of the metadata section of the
+// OPF file describing b apart from the title, identifiers, and
+// modification date.
+func (b *Book) OPFMetadata() string {
+
+	w := new(strings.Builder)
+
+	el := func(id, name, content string) {
+		if content == "" {
+			return
+		}
+		if id != "" {
+			id = ` id="` + id + `"`
+		}
+		fmt.Fprintf(w, "   <%s%s>%s</%s>\n\n", name, id, html.EscapeString(content), name)
+	}
+
+	refines := func(id, property, content, scheme string) {
+		if content == "" {
+			return
+		}
+		if scheme != "" {
+			scheme = ` scheme="` + scheme + `"`
+		}
+		fmt.Fprintf(w, "   <meta refines=\"#%s\" property=\"%s\"%s>%s</meta>\n\n", id, property, scheme, html.EscapeString(content))
+	}
+
+	// reading gives the reading r of the element id in katakana
+	// for sorting and as written for display.
+	reading := func(id, r string) {
+		if r == "" {
+			return
+		}
+		refines(id, "file-as", furigana(r), "")
+		fmt.Fprintf(w, "   <meta refines=\"#%s\" property=\"alternate-script\" xml:lang=\"ja-Hrkt\">%s</meta>\n\n", id, html.EscapeString(r))
+	}
+
+	refines("maintitle", "title-type", "main", "")
+	reading("maintitle", b.TitleReading)
+
+	if b.Subtitle != "" {
+		el("subtitle", "dc:title", b.Subtitle)
+		refines("subtitle", "title-type", "subtitle", "")
+	}
+
+	if b.OriginalTitle != "" {
+		fmt.Fprintf(w, "   <meta property=\"dcterms:alternative\">%s</meta>\n\n", html.EscapeString(b.OriginalTitle))
+	}
+
+	for i, p := range b.AllCreators() {
+		id := "creator" + strconv.Itoa(i+1)
+		el(id, "dc:creator", p.Name)
+		refines(id, "role", string(p.Role), "marc:relators")
+		reading(id, p.FileAs)
+		refines(id, "display-seq", strconv.Itoa(i+1), "")
+	}
+
+	for i, p := range b.Contributors {
+		id := "contributor" + strconv.Itoa(i+1)
+		el(id, "dc:contributor", p.Name)
+		refines(id, "role", string(p.Role), "marc:relators")
+	}
+
+	el("publisher", "dc:publisher", b.Publisher)
+	if b.Publisher != "" {
+		reading("publisher", b.publisherReading())
+	}
+	el("", "dc:source", b.Source)
+	el("", "dc:date", b.Date)
+	el("", "dc:rights", b.Rights)
+
+	for i, s := range b.Subjects {
+		id := "subject" + strconv.Itoa(i+1)
+		el(id, "dc:subject", s)
+		if ndc := NDC(s); ndc != "" {
+			refines(id, "authority", "NDC", "")
+			refines(id, "term", ndc, "")
+		}
+	}
+
+	el("", "dc:description", b.Description())
+
+	return strings.TrimSpace(w.String())
+}
+
+// setMetadataFromBody sets the metadata given in the div.metadata
+// block at the start of the body and in the colophon
+// (div.bibliographical_information) at the end.
+func (b *Book) setMetadataFromBody() {
+
+	for i := 0; i < len(b.Body); i++ {
+
+		t := b.Body[i]
+
+		if t.Type != nethtml.StartTagToken {
+			continue
+		}
+
+		if classNameContains(t, "main_text") {
+			break
+		}
+
+		class := classOf(t)
+
+		if class == "" || class == "metadata" {
+			continue
+		}
+
+		text, n := elementText(b.Body[i:])
+		i = i + n - 1
+
+		if text == "" {
+			continue
+		}
+
+		switch class {
+
+		case "title":
+			if b.Title == "" {
+				b.SetTitle(text)
+			}
+
+		case "subtitle":
+			b.Subtitle = text
+
+		case "original_title":
+			b.OriginalTitle = text
+
+		default:
+			role, ok := metadataRoles[class]
+			if !ok {
+				continue
+			}
+			name := strings.TrimSpace(strings.TrimSuffix(strings.TrimSuffix(text, "訳"), "編"))
+			b.AddCreator(Person{Name: name, Role: role})
+			if b.Creator == "" && role == RoleAuthor {
+				b.SetCreator(name)
+			}
+		}
+	}
+
+	b.setMetadataFromColophon(colophonLines(b.Body))
+
+	return
+}
+
+// elementText returns the text content of the element starting
+// with tokens[0] without ruby readings, and the number of tokens
+// making up the element. Metadata elements only contain inline
+// elements so the element ends with the first end tag matching the
+// start tag.
+func elementText(tokens []*nethtml.Token) (string, int) {
+
+	w := new(strings.Builder)
+
+	skip := 0
+
+	for i, t := range tokens[1:] {
+
+		switch {
+
+		case t.Type == nethtml.EndTagToken && t.Data == tokens[0].Data && skip == 0:
+			return strings.TrimSpace(w.String()), i + 2
+
+		case t.Type == nethtml.StartTagToken && (t.DataAtom == atom.Rt || t.DataAtom == atom.Rp):
+			skip++
+
+		case t.Type == nethtml.EndTagToken && (t.DataAtom == atom.Rt || t.DataAtom == atom.Rp):
+			skip--
+
+		case isText(t) && skip == 0:
+			w.WriteString(t.Data)
+		}
+	}
+
+	return strings.TrimSpace(w.String()), len(tokens)
+}
+
+// colophonLines returns the lines of the colophon of body.
+func colophonLines(body []*nethtml.Token) (lines []string) {
+
+	in := false
+
+	w := new(strings.Builder)
+
+	for _, t := range body {
+
+		switch {
+
+		case t.Type == nethtml.StartTagToken && classNameContains(t, "bibliographical_information"):
+			in = true
+
+		case !in:
+			continue
+
+		case t.Type == nethtml.StartTagToken && isDiv(t):
+			lines = append(lines, w.String())
+			return lines
+
+		case t.DataAtom == atom.Br:
+			lines = append(lines, w.String())
+			w.Reset()
+
+		case isText(t):
+			w.WriteString(strings.Trim(t.Data, "\n"))
+		}
+	}
+
+	return append(lines, w.String())
+}
+
+var aozoraDate = regexp.MustCompile(`^([0-9]{4})年([0-9]{1,2})月([0-9]{1,2})日(公開|作成|修正)`)
+
+// setMetadataFromColophon sets the metadata given in the colophon.
+// Entries are of the form 底本：… and may be continued on the
+// following lines, which are indented.
+func (b *Book) setMetadataFromColophon(lines []string) {
+
+	var entries [][2]string
+
+	for _, l := range lines {
+
+		if l == "" {
+			continue
+		}
+
+		if strings.TrimLeft(l, " 　") != l && len(entries) > 0 {
+			entries[len(entries)-1][1] += " " + strings.TrimSpace(l)
+			continue
+		}
+
+		l = strings.TrimSpace(l)
+
+		if m := aozoraDate.FindStringSubmatch(l); m != nil {
+			y, _ := strconv.Atoi(m[1])
+			mo, _ := strconv.Atoi(m[2])
+			d, _ := strconv.Atoi(m[3])
+			date := fmt.Sprintf("%04d-%02d-%02d", y, mo, d)
+			switch {
+			case m[4] == "修正":
+				if date > b.Revised {
+					b.Revised = date
+				}
+			case b.Date == "":
+				b.Date = date
+			}
+			continue
+		}
+
+		if strings.Contains(l, "クリエイティブ・コモンズ") {
+			b.Rights = l
+			continue
+		}
+
+		k, v, ok := strings.Cut(l, "：")
+		if !ok {
+			continue
+		}
+
+		entries = append(entries, [2]string{k, strings.TrimSpace(v)})
+	}
+
+	for _, e := range entries {
+
+		switch e[0] {
+
+		case "底本":
+			if b.Source == "" {
+				b.Source = e[1]
+			}
+
+		case "初出":
+			if b.FirstPublished == "" {
+				b.FirstPublished = e[1]
+			}
+
+		case "入力":
+			b.AddContributor(Person{Name: e[1], Role: RoleTranscriber})
+
+		case "校正":
+			b.AddContributor(Person{Name: e[1], Role: RoleProofreader})
+		}
+	}
+
+	return
+}
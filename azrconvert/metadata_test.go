@@ -0,0 +1,250 @@
+package azrconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const metadataTestPage = `<html><head>
+<meta name="DC.Title" content="幸福な王子" />
+<meta name="DC.Creator" content="ワイルド オスカー" />
+<meta name="DC.Publisher" content="青空文庫" />
+<title>ワイルド オスカー 幸福な王子</title></head>
+<body>
+<div class="metadata">
+<h1 class="title">幸福な王子</h1>
+<h2 class="subtitle">――<ruby><rb>或</rb><rp>（</rp><rt>あ</rt><rp>）</rp></ruby>る童話――</h2>
+<h2 class="original_title">THE HAPPY PRINCE</h2>
+<h2 class="author">ワイルド オスカー</h2>
+<h2 class="translator">結城浩訳</h2>
+<br />
+<br />
+</div>
+<div class="main_text">本文<br />
+</div>
+<div class="bibliographical_information">
+<hr />
+<br />
+底本：「幸福な王子」青空文庫<br />
+　　　2000（平成12）年1月1日初版発行<br />
+初出：「某誌」<br />
+　　　1999（平成11）年<br />
+入力：結城浩<br />
+校正：某<br />
+2000年1月1日公開<br />
+2005年2月3日修正<br />
+</div>
+</body></html>`
+
+func TestSetMetadataFromPreamble(t *testing.T) {
+
+	bk := NewBook()
+
+	err := bk.getBookFrom(cleanUTF8([]byte(metadataTestPage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bk.SetMetadataFromPreamble()
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"Title", bk.Title, "幸福な王子"},
+		{"Subtitle", bk.Subtitle, "――或る童話――"},
+		{"OriginalTitle", bk.OriginalTitle, "THE HAPPY PRINCE"},
+		{"Creators", bk.Creators, []Person{
+			{Name: "ワイルド オスカー", Role: RoleAuthor},
+			{Name: "結城浩", Role: RoleTranslator},
+		}},
+		{"Contributors", bk.Contributors, []Person{
+			{Name: "結城浩", Role: RoleTranscriber},
+			{Name: "某", Role: RoleProofreader},
+		}},
+		{"Source", bk.Source, "「幸福な王子」青空文庫 2000（平成12）年1月1日初版発行"},
+		{"FirstPublished", bk.FirstPublished, "「某誌」 1999（平成11）年"},
+		{"Date", bk.Date, "2000-01-01"},
+		{"Rights", bk.Rights, PublicDomain},
+	}
+
+	for _, tc := range tests {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+
+	bk.SetMetadataFromCard(&Card{
+		TitleReading:   "こうふくなおうじ",
+		Creator:        "ワイルド オスカー",
+		CreatorReading: "わいるど おすかー",
+		Classification: "NDC 933",
+	})
+
+	opf := bk.OPFMetadata()
+
+	for _, s := range []string{
+		`<dc:creator id="creator2">結城浩</dc:creator>`,
+		`<meta refines="#creator2" property="role" scheme="marc:relators">trl</meta>`,
+		`<meta refines="#maintitle" property="file-as">コウフクナオウジ</meta>`,
+		`<meta refines="#creator1" property="file-as">ワイルド オスカー</meta>`,
+		`<meta refines="#creator1" property="alternate-script" xml:lang="ja-Hrkt">わいるど おすかー</meta>`,
+		`<meta refines="#publisher" property="file-as">アオゾラブンコ</meta>`,
+		`<meta refines="#subtitle" property="title-type">subtitle</meta>`,
+		`<meta refines="#subject1" property="term">933</meta>`,
+		`<dc:rights>Public domain</dc:rights>`,
+	} {
+		if !strings.Contains(opf, s) {
+			t.Errorf("OPF metadata does not contain %s", s)
+		}
+	}
+}
+
+func TestMetadataEscaped(t *testing.T) {
+
+	bk := NewBook()
+
+	err := bk.getBookFrom(cleanUTF8([]byte(metadataTestPage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bk.SetMetadataFromPreamble()
+
+	const s = `A&B<"C'>`
+
+	bk.Title, bk.Subtitle, bk.OriginalTitle, bk.TitleReading = s, s, s, s
+	bk.Publisher, bk.PublisherReading = s, s
+	bk.Creators = []Person{{Name: s, FileAs: s, Role: RoleAuthor}}
+	bk.Contributors = []Person{{Name: s, Role: RoleTranscriber}}
+	bk.Source, bk.FirstPublished, bk.Date, bk.Rights = s, s, s, s
+	bk.Subjects = []string{s}
+
+	err = bk.GenTitlePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = bk.WriteEpub(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arch, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range arch.File {
+
+		switch path.Ext(f.Name) {
+		case ".opf", ".xhtml", ".html", ".xml":
+		default:
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d := xml.NewDecoder(r)
+		d.Strict = true
+
+		for {
+			_, err = d.Token()
+			if err != nil {
+				break
+			}
+		}
+
+		r.Close()
+
+		if err != io.EOF {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+	}
+}
+
+func TestOPFUniqueIDs(t *testing.T) {
+
+	bk := NewBook()
+
+	err := bk.getBookFrom(cleanUTF8([]byte(metadataTestPage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bk.SetMetadataFromPreamble()
+	bk.SetMetadataFromCard(&Card{
+		TitleReading:   "こうふくなおうじ",
+		CreatorReading: "わいるど おすかー",
+		Classification: "NDC 933",
+	})
+
+	err = bk.GenTitlePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = bk.WriteEpub(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arch, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range arch.File {
+
+		if path.Ext(f.Name) != ".opf" {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+
+		seen := make(map[string]bool)
+
+		d := xml.NewDecoder(r)
+
+		for {
+			tok, err := d.Token()
+			if err != nil {
+				break
+			}
+			el, ok := tok.(xml.StartElement)
+			if !ok {
+				continue
+			}
+			for _, a := range el.Attr {
+				if a.Name.Local != "id" {
+					continue
+				}
+				if seen[a.Value] {
+					t.Errorf("%s: id %s used twice", f.Name, a.Value)
+				}
+				seen[a.Value] = true
+			}
+		}
+
+		if !seen["maintitle"] {
+			t.Errorf("%s: no title id", f.Name)
+		}
+	}
+}
@@ -0,0 +1,826 @@
+package azrconvert
+
+import (
+	"archive/zip"
+	"html"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/adamay909/AozoraConvert/runes"
+)
+
+// textItem is a piece of a line of plain text together with
+// its xhtml rendering. plain is what annotations of the form
+// ［＃「…」に傍点］ refer to.
+type textItem struct {
+	plain string
+	html  string
+	kanji bool
+}
+
+// textConverter holds the state needed while converting the
+// body of a plain text file line by line.
+type textConverter struct {
+	out     *strings.Builder
+	blocks  int
+	midashi int
+}
+
+// NewBookFromText returns a Book based on d. d is assumed to be
+// a plain text file from Aozora Bunko, i.e. a Shift_JIS encoded
+// text with ruby given as 《》 and annotations given as ［＃…］.
+// The errors are as for GetBookFrom.
+func NewBookFromText(d []byte) (*Book, error) {
+	bk := NewBook()
+
+	err := bk.GetBookFromText(d)
+
+	return bk, err
+}
+
+// GetBookFromText populates bk based on d. d is assumed to be a plain text
+// file from Aozora Bunko. The text is first converted to xhtml
+// in the format used by Aozora Bunko so that the rest of the
+// conversion is the same as for the xhtml files. The errors
+// are as for GetBookFrom.
+func (bk *Book) GetBookFromText(d []byte) error {
+
+	d = textToXHTML(d)
+	log.Println("Converted plain text to xhtml.")
+
+	return bk.getBookFrom(cleanUTF8(d))
+
+}
+
+// textToXHTML converts the plain text file d to xhtml. The
+// output is UTF-8 encoded.
+func textToXHTML(d []byte) []byte {
+
+	d = fixLineEndings(d)
+
+	if !utf8.Valid(d) {
+		d = ToUTF8(d)
+	}
+
+	lines := strings.Split(strings.TrimPrefix(string(d), "\ufeff"), "\n")
+
+	header, body, colophon := splitText(lines)
+
+	title, subtitle, creator, translator := headerFields(header)
+
+	w := new(strings.Builder)
+
+	w.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	w.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="ja" lang="ja">` + "\n")
+	w.WriteString("<head>\n")
+	w.WriteString(`<meta http-equiv="Content-Type" content="text/html;charset=UTF-8" />` + "\n")
+	w.WriteString(`<link rel="Schema.DC" href="http://purl.org/dc/elements/1.1/" />` + "\n")
+	w.WriteString(`<meta name="DC.Title" content="` + html.EscapeString(strings.TrimSpace(title+" "+subtitle)) + `" />` + "\n")
+	w.WriteString(`<meta name="DC.Creator" content="` + html.EscapeString(creator) + `" />` + "\n")
+	w.WriteString(`<meta name="DC.Publisher" content="青空文庫" />` + "\n")
+	w.WriteString("<title>" + html.EscapeString(creator+" "+title) + "</title>\n")
+	w.WriteString("</head>\n")
+	w.WriteString("<body>\n")
+
+	w.WriteString(`<div class="metadata">` + "\n")
+	w.WriteString(`<h1 class="title">` + html.EscapeString(title) + "</h1>\n")
+	if subtitle != "" {
+		w.WriteString(`<h2 class="subtitle">` + html.EscapeString(subtitle) + "</h2>\n")
+	}
+	if creator != "" {
+		w.WriteString(`<h2 class="author">` + html.EscapeString(creator) + "</h2>\n")
+	}
+	if translator != "" {
+		w.WriteString(`<h2 class="translator">` + html.EscapeString(translator) + "</h2>\n")
+	}
+	w.WriteString("<br />\n<br />\n</div>\n")
+
+	w.WriteString(`<div class="main_text">`)
+
+	c := &textConverter{out: w, midashi: 0}
+	for _, l := range body {
+		c.convertLine(l)
+	}
+	for ; c.blocks > 0; c.blocks-- {
+		w.WriteString("</div>\n")
+	}
+
+	w.WriteString("</div>\n")
+
+	if len(colophon) > 0 {
+		w.WriteString(`<div class="bibliographical_information">` + "\n")
+		w.WriteString("<hr />\n<br />\n")
+		for _, l := range colophon {
+			w.WriteString(html.EscapeString(l) + "<br />\n")
+		}
+		w.WriteString("</div>\n")
+	}
+
+	w.WriteString("</body>\n</html>\n")
+
+	return []byte(w.String())
+}
+
+// splitText splits the lines of a plain text file into the
+// header (title, author, etc.), the body, and the colophon
+// (底本 etc.). The explanation of symbols between the lines
+// of dashes is dropped.
+func splitText(lines []string) (header, body, colophon []string) {
+
+	i := 0
+
+	for ; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == "" {
+			break
+		}
+		header = append(header, strings.TrimSpace(lines[i]))
+	}
+
+	for ; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) != "" {
+			break
+		}
+	}
+
+	if i < len(lines) && isDashLine(lines[i]) {
+		for i++; i < len(lines); i++ {
+			if isDashLine(lines[i]) {
+				i++
+				break
+			}
+		}
+	}
+
+	e := len(lines)
+
+	for j := i; j < len(lines); j++ {
+		if strings.HasPrefix(lines[j], "底本：") {
+			e = j
+			break
+		}
+	}
+
+	if i < e {
+		body = lines[i:e]
+	}
+
+	colophon = lines[e:]
+
+	for len(body) > 0 && strings.TrimSpace(body[0]) == "" {
+		body = body[1:]
+	}
+
+	for len(body) > 0 && strings.TrimSpace(body[len(body)-1]) == "" {
+		body = body[:len(body)-1]
+	}
+
+	for len(colophon) > 0 && strings.TrimSpace(colophon[len(colophon)-1]) == "" {
+		colophon = colophon[:len(colophon)-1]
+	}
+
+	return
+}
+
+func isDashLine(l string) bool {
+
+	l = strings.TrimSpace(l)
+
+	return len(l) >= 10 && strings.Trim(l, "-") == ""
+}
+
+// headerFields guesses title, subtitle, author and translator
+// from the header lines of a plain text file. The first line
+// is the title, the author comes last unless the last line is
+// a translator (ends with 訳), and anything in between is the
+// subtitle.
+func headerFields(header []string) (title, subtitle, creator, translator string) {
+
+	if len(header) == 0 {
+		return
+	}
+
+	title = header[0]
+	header = header[1:]
+
+	if len(header) > 1 && strings.HasSuffix(header[len(header)-1], "訳") {
+		translator = header[len(header)-1]
+		header = header[:len(header)-1]
+	}
+
+	if len(header) > 0 {
+		creator = header[len(header)-1]
+		header = header[:len(header)-1]
+	}
+
+	subtitle = strings.Join(header, " ")
+
+	return
+}
+
+// convertLine writes the xhtml for the plain text line l.
+func (c *textConverter) convertLine(l string) {
+
+	l = strings.TrimRight(l, " \t")
+
+	r := runes.Runes(l)
+
+	if runes.HasPrefix(r, runes.Runes("［＃")) && closingBracket(r, 0) == len(r)-1 {
+		a := string(r[2 : len(r)-1])
+		if c.blockAnnotation(a) {
+			return
+		}
+	}
+
+	items, jisage, chitsuki, split := c.convertInline(r)
+
+	if jisage > 0 {
+		c.out.WriteString(`<div class="jisage_` + strconv.Itoa(jisage) + `" style="margin-left: ` + strconv.Itoa(jisage) + `em">`)
+		c.out.WriteString(joinItems(items))
+		c.out.WriteString("</div>\n")
+		return
+	}
+
+	if split >= 0 {
+		c.out.WriteString(joinItems(items[:split]))
+		c.out.WriteString(`<div class="chitsuki_` + strconv.Itoa(chitsuki) + `" style="text-align:right; margin-right: ` + strconv.Itoa(chitsuki) + `em">`)
+		c.out.WriteString(joinItems(items[split:]))
+		c.out.WriteString("</div>\n")
+		return
+	}
+
+	line := joinItems(items)
+
+	c.out.WriteString(line)
+
+	if strings.HasSuffix(line, "</h3>") || strings.HasSuffix(line, "</h4>") || strings.HasSuffix(line, "</h5>") {
+		c.out.WriteString("\n")
+		return
+	}
+
+	c.out.WriteString("<br />\n")
+}
+
+// blockAnnotation handles annotations which occupy a line of their own and
+// open or close a block (ここから…, ここで…終わり) or stand for
+// a page break. It reports whether a was handled.
+func (c *textConverter) blockAnnotation(a string) bool {
+
+	switch {
+
+	case strings.HasPrefix(a, "ここで") && (strings.HasSuffix(a, "終わり") || strings.HasSuffix(a, "終了")):
+		if c.blocks > 0 {
+			c.out.WriteString("</div>\n")
+			c.blocks--
+		}
+		return true
+
+	case strings.HasPrefix(a, "ここから"):
+		c.out.WriteString(blockStart(strings.TrimPrefix(a, "ここから")))
+		c.blocks++
+		return true
+
+	case isPageBreak(a):
+		c.out.WriteString(`<span class="notes">［＃` + a + `］</span><br />` + "\n")
+		return true
+
+	default:
+		return false
+	}
+}
+
+// blockStart returns the opening tag of the block started by
+// ［＃ここから…］. a is the annotation without ここから.
+func blockStart(a string) string {
+
+	switch {
+
+	case strings.Contains(a, "、折り返して"):
+		parts := strings.SplitN(a, "、折り返して", 2)
+		first := 0
+		if !strings.HasPrefix(parts[0], "改行天付き") {
+			first = annotationNumber(parts[0])
+		}
+		rest := annotationNumber(parts[1])
+		return `<div class="burasage" style="margin-left: ` + strconv.Itoa(rest) + `em; text-indent: ` + strconv.Itoa(first-rest) + `em;">` + "\n"
+
+	case strings.HasSuffix(a, "字下げ"):
+		n := strconv.Itoa(annotationNumber(a))
+		return `<div class="jisage_` + n + `" style="margin-left: ` + n + `em">` + "\n"
+
+	case a == "地付き":
+		return `<div class="chitsuki_0" style="text-align:right; margin-right: 0em">` + "\n"
+
+	case strings.HasPrefix(a, "地から") && strings.HasSuffix(a, "字上げ"):
+		n := strconv.Itoa(annotationNumber(a))
+		return `<div class="chitsuki_` + n + `" style="text-align:right; margin-right: ` + n + `em">` + "\n"
+
+	case strings.HasSuffix(a, "字詰め"):
+		n := strconv.Itoa(annotationNumber(a))
+		return `<div class="jizume_` + n + `" style="width: ` + n + `em">` + "\n"
+
+	case a == "罫囲み":
+		return `<div class="keigakomi">` + "\n"
+
+	default:
+		log.Println("Unknown block annotation: ［＃ここから" + a + "］")
+		return `<div><span class="notes">［＃ここから` + html.EscapeString(a) + `］</span>` + "\n"
+	}
+}
+
+// convertInline converts a line of text. It returns the converted pieces
+// of the line, the indentation requested by ［＃N字下げ］ at
+// the start of the line, and, if the line contains
+// ［＃地付き］ or ［＃地からN字上げ］, the index of the first
+// piece to be aligned to the bottom together with the offset.
+// split is -1 if there is no such annotation.
+func (c *textConverter) convertInline(r runes.Runes) (items []textItem, jisage, chitsuki, split int) {
+
+	type opening struct {
+		name  string
+		index int
+	}
+
+	var open []opening
+
+	rubyStart := -1
+	split = -1
+
+	for i := 0; i < len(r); i++ {
+
+		switch {
+
+		// the ｜ is kept as text unless ruby follows
+		case r[i] == '｜':
+			rubyStart = len(items)
+			items = append(items, plainItem(r[i]))
+
+		case r[i] == '《':
+			j := runes.Index(r[i:], runes.Runes("》"))
+			if j == -1 {
+				items = append(items, plainItem(r[i]))
+				continue
+			}
+			j = j + i
+
+			bar := rubyStart >= 0 && rubyStart < len(items)-1 && items[rubyStart].plain == "｜"
+
+			start := rubyStart + 1
+			if !bar {
+				for start = len(items); start > 0 && items[start-1].kanji; start-- {
+				}
+			}
+
+			if start == len(items) {
+				items = append(items, plainItem(r[i]))
+				continue
+			}
+
+			ruby := rubyItem(items[start:], string(r[i+1:j]))
+			if bar {
+				start--
+			}
+
+			items = append(items[:start], ruby)
+			rubyStart = -1
+			i = j
+
+		case r[i] == '※' && runes.HasPrefix(r[i+1:], runes.Runes("［＃")):
+			j := closingBracket(r, i+1)
+			if j == -1 {
+				items = append(items, plainItem(r[i]))
+				continue
+			}
+			items = append(items, textItem{
+				plain: "※",
+				html:  `※<span class="notes">` + html.EscapeString(string(r[i+1:j+1])) + `</span>`,
+				kanji: true,
+			})
+			i = j
+
+		case runes.HasPrefix(r[i:], runes.Runes("［＃")):
+			j := closingBracket(r, i)
+			if j == -1 {
+				items = append(items, plainItem(r[i]))
+				continue
+			}
+			a := string(r[i+2 : j])
+			i = j
+
+			switch {
+
+			case len(items) == 0 && strings.HasSuffix(a, "字下げ") && annotationNumber(a) > 0:
+				jisage = annotationNumber(a)
+
+			case a == "地付き":
+				split, chitsuki = len(items), 0
+
+			case strings.HasPrefix(a, "地から") && strings.HasSuffix(a, "字上げ"):
+				split, chitsuki = len(items), annotationNumber(a)
+
+			case isImageAnnotation(a):
+				items = append(items, imageItem(a))
+
+			case strings.HasPrefix(a, "「"):
+				items = c.referenceAnnotation(items, a)
+
+			case strings.HasSuffix(a, "終わり") || strings.HasSuffix(a, "終了"):
+				name := strings.TrimSuffix(strings.TrimSuffix(a, "終わり"), "終了")
+				k := len(open) - 1
+				for ; k >= 0; k-- {
+					if open[k].name == name {
+						break
+					}
+				}
+				if k == -1 {
+					items = append(items, noteItem(a))
+					continue
+				}
+				start := open[k].index
+				open = open[:k]
+				o, e := c.styleTags(name)
+				items = append(items[:start], wrapItems(items[start:], o, e))
+
+			case isPageBreak(a):
+				items = append(items, noteItem(a))
+
+			default:
+				if isStyle(a) {
+					open = append(open, opening{name: a, index: len(items)})
+					continue
+				}
+				log.Println("Unknown annotation: ［＃" + a + "］")
+				items = append(items, noteItem(a))
+			}
+
+		default:
+			items = append(items, plainItem(r[i]))
+		}
+	}
+
+	return
+}
+
+// referenceAnnotation applies annotations of the form ［＃「X」に…］
+// which refer to the text X immediately preceding them.
+func (c *textConverter) referenceAnnotation(items []textItem, a string) []textItem {
+
+	e := strings.Index(a, "」")
+	if e == -1 {
+		return append(items, noteItem(a))
+	}
+
+	target := strings.TrimPrefix(a[:e], "「")
+	rest := a[e+len("」"):]
+
+	start := -1
+	s := ""
+	for k := len(items) - 1; k >= 0; k-- {
+		s = items[k].plain + s
+		if s == target {
+			start = k
+			break
+		}
+		if len(s) >= len(target) {
+			break
+		}
+	}
+
+	if start == -1 {
+		log.Println("Could not find target of annotation: ［＃" + a + "］")
+		return append(items, noteItem(a))
+	}
+
+	if strings.HasPrefix(rest, "に「") && strings.HasSuffix(rest, "」のルビ") {
+		ruby := strings.TrimSuffix(strings.TrimPrefix(rest, "に「"), "」のルビ")
+		return append(items[:start], rubyItem(items[start:], ruby))
+	}
+
+	rest = strings.TrimPrefix(rest, "に")
+	rest = strings.TrimPrefix(rest, "は")
+
+	o, cl := c.styleTags(rest)
+	if o == "" {
+		log.Println("Unknown annotation: ［＃" + a + "］")
+		return append(items, noteItem(a))
+	}
+
+	return append(items[:start], wrapItems(items[start:], o, cl))
+}
+
+// styleTags returns the opening and closing tags for the
+// inline style given by the annotation name a (傍点, 大見出し,
+// etc.). It returns empty strings if a is not known.
+func (c *textConverter) styleTags(a string) (open, close string) {
+
+	left := strings.HasPrefix(a, "の左に")
+	a = strings.TrimPrefix(a, "の左に")
+	a = strings.TrimPrefix(a, "左に")
+
+	if class, ok := botenClasses[a]; ok {
+		if left {
+			class = class + "_after"
+		}
+		return `<em class="` + class + `">`, "</em>"
+	}
+
+	if class, ok := bosenClasses[a]; ok {
+		if left {
+			class = strings.Replace(class, "underline", "overline", 1)
+		}
+		return `<em class="` + class + `">`, "</em>"
+	}
+
+	if m, ok := midashiTags[a]; ok {
+		c.midashi = c.midashi + 10
+		return `<` + m[0] + ` class="` + m[1] + `"><a class="midashi_anchor" id="midashi` + strconv.Itoa(c.midashi) + `">`, `</a></` + m[0] + `>`
+	}
+
+	switch a {
+	case "太字":
+		return `<span class="futoji">`, "</span>"
+	case "斜体":
+		return `<span class="shatai">`, "</span>"
+	case "下付き小文字":
+		return `<sub class="subscript">`, "</sub>"
+	case "上付き小文字":
+		return `<sup class="superscript">`, "</sup>"
+	}
+
+	return "", ""
+}
+
+// isStyle reports whether a is the name of an inline style.
+func isStyle(a string) bool {
+
+	a = strings.TrimPrefix(a, "の左に")
+	a = strings.TrimPrefix(a, "左に")
+
+	_, boten := botenClasses[a]
+	_, bosen := bosenClasses[a]
+	_, midashi := midashiTags[a]
+
+	switch a {
+	case "太字", "斜体", "下付き小文字", "上付き小文字":
+		return true
+	}
+
+	return boten || bosen || midashi
+}
+
+var botenClasses = map[string]string{
+	"傍点":    "sesame_dot",
+	"白ゴマ傍点": "white_sesame_dot",
+	"丸傍点":   "black_circle",
+	"白丸傍点":  "white_circle",
+	"黒三角傍点": "black_up-pointing_triangle",
+	"白三角傍点": "white_up-pointing_triangle",
+	"二重丸傍点": "bullseye",
+	"蛇の目傍点": "fisheye",
+	"ばつ傍点":  "saltire",
+}
+
+var bosenClasses = map[string]string{
+	"傍線":   "underline_solid",
+	"二重傍線": "underline_double",
+	"鎖線":   "underline_dotted",
+	"破線":   "underline_dashed",
+	"波線":   "underline_wavy",
+}
+
+var midashiTags = map[string][2]string{
+	"大見出し":   {"h3", "o-midashi"},
+	"中見出し":   {"h4", "naka-midashi"},
+	"小見出し":   {"h5", "ko-midashi"},
+	"同行大見出し": {"h3", "dogyo-o-midashi"},
+	"同行中見出し": {"h4", "dogyo-naka-midashi"},
+	"同行小見出し": {"h5", "dogyo-ko-midashi"},
+	"窓大見出し":  {"h3", "mado-o-midashi"},
+	"窓中見出し":  {"h4", "mado-naka-midashi"},
+	"窓小見出し":  {"h5", "mado-ko-midashi"},
+}
+
+func isPageBreak(a string) bool {
+
+	switch a {
+	case "改ページ", "改丁", "改見開き", "改段", "ページの左右中央":
+		return true
+	default:
+		return false
+	}
+}
+
+// isImageAnnotation reports whether a is of the form
+// 挿絵（fig1.png）入る or 「…」のキャプション付きの図（fig1.png、横…×縦…）入る.
+func isImageAnnotation(a string) bool {
+
+	if !strings.HasSuffix(a, "）入る") {
+		return false
+	}
+
+	i := strings.LastIndex(a, "（")
+	if i == -1 {
+		return false
+	}
+
+	name := strings.SplitN(a[i+len("（"):], "、", 2)[0]
+	name = strings.TrimSuffix(name, "）入る")
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".png", ".jpg", ".jpeg", ".gif":
+		return true
+	default:
+		return false
+	}
+}
+
+func imageItem(a string) textItem {
+
+	i := strings.LastIndex(a, "（")
+
+	fields := strings.Split(strings.TrimSuffix(a[i+len("（"):], "）入る"), "、")
+
+	alt := strings.TrimSpace(a[:i])
+	if strings.HasPrefix(alt, "「") {
+		if e := strings.Index(alt, "」"); e != -1 {
+			alt = alt[len("「"):e]
+		}
+	}
+
+	w := new(strings.Builder)
+	w.WriteString(`<img class="illustration"`)
+
+	for _, f := range fields[1:] {
+		size := strings.Split(f, "×")
+		if len(size) != 2 {
+			continue
+		}
+		w.WriteString(` width="` + strconv.Itoa(annotationNumber(size[0])) + `"`)
+		w.WriteString(` height="` + strconv.Itoa(annotationNumber(size[1])) + `"`)
+	}
+
+	w.WriteString(` src="` + html.EscapeString(fields[0]) + `" alt="` + html.EscapeString(alt) + `" />`)
+
+	return textItem{plain: "", html: w.String()}
+}
+
+func plainItem(c rune) textItem {
+
+	return textItem{
+		plain: string(c),
+		html:  html.EscapeString(string(c)),
+		kanji: unicode.Is(unicode.Han, c) || c == 'ヶ',
+	}
+}
+
+func noteItem(a string) textItem {
+
+	return textItem{html: `<span class="notes">［＃` + html.EscapeString(a) + `］</span>`}
+}
+
+func rubyItem(base []textItem, ruby string) textItem {
+
+	var plain strings.Builder
+
+	for _, it := range base {
+		plain.WriteString(it.plain)
+	}
+
+	return textItem{
+		plain: plain.String(),
+		html:  `<ruby><rb>` + joinItems(base) + `</rb><rp>（</rp><rt>` + html.EscapeString(ruby) + `</rt><rp>）</rp></ruby>`,
+	}
+}
+
+func wrapItems(items []textItem, open, close string) textItem {
+
+	var plain strings.Builder
+
+	for _, it := range items {
+		plain.WriteString(it.plain)
+	}
+
+	return textItem{plain: plain.String(), html: open + joinItems(items) + close}
+}
+
+func joinItems(items []textItem) string {
+
+	w := new(strings.Builder)
+
+	for _, it := range items {
+		w.WriteString(it.html)
+	}
+
+	return w.String()
+}
+
+// closingBracket returns the index of the ］ closing the ［
+// at r[i], taking nested brackets into account. It returns -1
+// if there is none.
+func closingBracket(r runes.Runes, i int) int {
+
+	depth := 0
+
+	for j := i; j < len(r); j++ {
+		switch r[j] {
+		case '［':
+			depth++
+		case '］':
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+
+	return -1
+}
+
+// annotationNumber returns the number in annotations such as
+// ２字下げ or 地から３字上げ. Full width, half width and
+// kanji numerals are understood.
+func annotationNumber(a string) (n int) {
+
+	kanji := map[rune]int{'〇': 0, '一': 1, '二': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+
+	// found is set once a digit is seen, ten right after 十, which
+	// makes the next digit the ones of 二十三 rather than a new
+	// place as in 一〇〇
+	found, ten := false, false
+
+	for _, c := range a {
+		switch {
+		case '０' <= c && c <= '９':
+			n = n*10 + int(c-'０')
+			found, ten = true, false
+		case '0' <= c && c <= '9':
+			n = n*10 + int(c-'0')
+			found, ten = true, false
+		case c == '十':
+			if n == 0 {
+				n = 1
+			}
+			n = n * 10
+			found, ten = true, true
+		default:
+			if d, ok := kanji[c]; ok {
+				if ten {
+					n = n + d
+				} else {
+					n = n*10 + d
+				}
+				found, ten = true, false
+				continue
+			}
+			if found {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+// textOfArchive returns the content of the plain text file in
+// arch if arch looks like a zip archive distributed by Aozora
+// Bunko. Otherwise, it returns nil.
+func textOfArchive(arch *zip.Reader) ([]byte, error) {
+
+	for _, e := range arch.File {
+		if filepath.Base(e.Name) == "1.html" {
+			return nil, nil
+		}
+	}
+
+	var text *zip.File
+
+	for _, e := range arch.File {
+		if strings.ToLower(filepath.Ext(e.Name)) == ".txt" {
+			text = e
+			break
+		}
+	}
+
+	if text == nil {
+		return nil, nil
+	}
+
+	r, err := text.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	d, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("Found plain text", text.Name, "in archive.")
+
+	return d, nil
+}
@@ -0,0 +1,247 @@
+package azrconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/adamay909/AozoraConvert/runes"
+)
+
+func TestConvertInline(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"漢字《かんじ》です", `<ruby><rb>漢字</rb><rp>（</rp><rt>かんじ</rt><rp>）</rp></ruby>です`},
+		{"これは｜青空文庫《あおぞらぶんこ》", `これは<ruby><rb>青空文庫</rb><rp>（</rp><rt>あおぞらぶんこ</rt><rp>）</rp></ruby>`},
+		{"ひらがな《ひらがな》", "ひらがな《ひらがな》"},
+		// ｜ without ruby is text
+		{"｜あいう", "｜あいう"},
+		{"あ｜い", "あ｜い"},
+		{"｜《》", "｜《》"},
+		{"吾輩は猫［＃「猫」に傍点］である", `吾輩は<em class="sesame_dot">猫</em>である`},
+		{"吾輩は猫［＃「猫」の左に傍点］である", `吾輩は<em class="sesame_dot_after">猫</em>である`},
+		{"吾輩は猫［＃「猫」に傍線］", `吾輩は<em class="underline_solid">猫</em>`},
+		{"吾輩は猫［＃「犬」に傍点］", `吾輩は猫<span class="notes">［＃「犬」に傍点］</span>`},
+		{"［＃傍点］吾輩［＃傍点終わり］は", `<em class="sesame_dot">吾輩</em>は`},
+		{"羅生門［＃「羅生門」は大見出し］", `<h3 class="o-midashi"><a class="midashi_anchor" id="midashi10">羅生門</a></h3>`},
+		{"［＃中見出し］一［＃中見出し終わり］", `<h4 class="naka-midashi"><a class="midashi_anchor" id="midashi10">一</a></h4>`},
+		{"東京［＃「東京」に「とうきょう」のルビ］", `<ruby><rb>東京</rb><rp>（</rp><rt>とうきょう</rt><rp>）</rp></ruby>`},
+		{"※［＃「さんずい＋督」、第3水準1-87-3］", `※<span class="notes">［＃「さんずい＋督」、第3水準1-87-3］</span>`},
+		{"［＃挿絵（fig1.png、横300×縦200）入る］", `<img class="illustration" width="300" height="200" src="fig1.png" alt="挿絵" />`},
+		{"［＃「門」のキャプション付きの図（fig2.png）入る］", `<img class="illustration" src="fig2.png" alt="門" />`},
+		{"a&b<c", "a&amp;b&lt;c"},
+	}
+
+	for _, tt := range tests {
+
+		c := &textConverter{out: new(strings.Builder)}
+
+		items, _, _, _ := c.convertInline(runes.Runes(tt.in))
+
+		if got := joinItems(items); got != tt.want {
+			t.Errorf("%s:\ngot  %s\nwant %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestConvertLine(t *testing.T) {
+
+	tests := []struct {
+		in   []string
+		want string
+	}{
+		{[]string{"本文"}, "本文<br />\n"},
+		{[]string{"［＃２字下げ］本文"}, `<div class="jisage_2" style="margin-left: 2em">本文</div>` + "\n"},
+		{[]string{"本文［＃地付き］終わり"}, `本文<div class="chitsuki_0" style="text-align:right; margin-right: 0em">終わり</div>` + "\n"},
+		{[]string{"［＃地から３字上げ］終わり"}, `<div class="chitsuki_3" style="text-align:right; margin-right: 3em">終わり</div>` + "\n"},
+		{[]string{"［＃ここから２字下げ］", "本文", "［＃ここで字下げ終わり］"}, `<div class="jisage_2" style="margin-left: 2em">` + "\n" + "本文<br />\n</div>\n"},
+		{[]string{"［＃ここから地付き］", "本文", "［＃ここで地付き終わり］"}, `<div class="chitsuki_0" style="text-align:right; margin-right: 0em">` + "\n" + "本文<br />\n</div>\n"},
+		{[]string{"［＃ここから地から二字上げ］"}, `<div class="chitsuki_2" style="text-align:right; margin-right: 2em">` + "\n"},
+		{[]string{"［＃ここから３字下げ、折り返して５字下げ］"}, `<div class="burasage" style="margin-left: 5em; text-indent: -2em;">` + "\n"},
+		{[]string{"［＃ここから改行天付き、折り返して１字下げ］"}, `<div class="burasage" style="margin-left: 1em; text-indent: -1em;">` + "\n"},
+		{[]string{"［＃改ページ］"}, `<span class="notes">［＃改ページ］</span><br />` + "\n"},
+		{[]string{"［＃大見出し］一［＃大見出し終わり］"}, `<h3 class="o-midashi"><a class="midashi_anchor" id="midashi10">一</a></h3>` + "\n"},
+		// a stray closing annotation is ignored
+		{[]string{"［＃ここで字下げ終わり］"}, ""},
+	}
+
+	for _, tt := range tests {
+
+		w := new(strings.Builder)
+		c := &textConverter{out: w}
+
+		for _, l := range tt.in {
+			c.convertLine(l)
+		}
+
+		if w.String() != tt.want {
+			t.Errorf("%q:\ngot  %q\nwant %q", tt.in, w.String(), tt.want)
+		}
+	}
+}
+
+func TestSplitText(t *testing.T) {
+
+	text := `羅生門
+芥川龍之介
+
+-------------------------------------------------------
+【テキスト中に現れる記号について】
+
+《》：ルビ
+-------------------------------------------------------
+
+　ある日の暮方の事である。
+　下人の行方は、誰も知らない。
+
+
+底本：「芥川龍之介全集１」ちくま文庫、筑摩書房
+入力：j.utiyama
+
+`
+
+	header, body, colophon := splitText(strings.Split(text, "\n"))
+
+	want := [][]string{
+		{"羅生門", "芥川龍之介"},
+		{"　ある日の暮方の事である。", "　下人の行方は、誰も知らない。"},
+		{"底本：「芥川龍之介全集１」ちくま文庫、筑摩書房", "入力：j.utiyama"},
+	}
+
+	for i, got := range [][]string{header, body, colophon} {
+		if !slices.Equal(got, want[i]) {
+			t.Errorf("part %d: got %q, want %q", i, got, want[i])
+		}
+	}
+
+	// no explanation of symbols and no colophon
+	header, body, colophon = splitText([]string{"題", "", "本文"})
+
+	if !slices.Equal(header, []string{"題"}) || !slices.Equal(body, []string{"本文"}) || len(colophon) != 0 {
+		t.Errorf("got %q %q %q", header, body, colophon)
+	}
+}
+
+func TestHeaderFields(t *testing.T) {
+
+	tests := []struct {
+		header []string
+		want   [4]string
+	}{
+		{nil, [4]string{}},
+		{[]string{"羅生門"}, [4]string{"羅生門", "", "", ""}},
+		{[]string{"羅生門", "芥川龍之介"}, [4]string{"羅生門", "", "芥川龍之介", ""}},
+		{[]string{"或る女", "前編", "有島武郎"}, [4]string{"或る女", "前編", "有島武郎", ""}},
+		{[]string{"幸福な王子", "ワイルド", "結城浩訳"}, [4]string{"幸福な王子", "", "ワイルド", "結城浩訳"}},
+	}
+
+	for _, tt := range tests {
+
+		title, subtitle, creator, translator := headerFields(tt.header)
+
+		if got := [4]string{title, subtitle, creator, translator}; got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestAnnotationNumber(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"２字下げ", 2},
+		{"12字下げ", 12},
+		{"１２字下げ", 12},
+		{"地から三字上げ", 3},
+		{"十字下げ", 10},
+		{"十五字下げ", 15},
+		{"二十字詰め", 20},
+		{"二十三字詰め", 23},
+		{"一〇字詰め", 10},
+		{"一〇〇", 100},
+		{"横300", 300},
+		{"字下げ", 0},
+	}
+
+	for _, tt := range tests {
+		if n := annotationNumber(tt.in); n != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.in, n, tt.want)
+		}
+	}
+}
+
+func TestTextOfArchive(t *testing.T) {
+
+	tests := []struct {
+		files map[string]string
+		want  string
+	}{
+		{map[string]string{"rashomon.txt": "羅生門"}, "羅生門"},
+		{map[string]string{"readme.md": "x", "files/rashomon.TXT": "羅生門"}, "羅生門"},
+		// a zip file of xhtml is not plain text
+		{map[string]string{"1.html": "x", "rashomon.txt": "羅生門"}, ""},
+		{map[string]string{"fig1.png": "x"}, ""},
+	}
+
+	for _, tt := range tests {
+
+		buf := new(bytes.Buffer)
+		w := zip.NewWriter(buf)
+
+		for name, content := range tt.files {
+			f, err := w.Create(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write([]byte(content))
+		}
+
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		arch, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := textOfArchive(arch)
+		if err != nil || string(d) != tt.want {
+			t.Errorf("%v: got %q, %v", tt.files, d, err)
+		}
+	}
+}
+
+func TestTextToXHTML(t *testing.T) {
+
+	text := "羅生門\r\n芥川龍之介\r\n\r\n［＃大見出し］一［＃大見出し終わり］\r\n" +
+		"下人《げにん》の行方は、｜誰《だれ》も知らない。\r\n［＃改ページ］\r\n\r\n底本：「全集」\r\n"
+
+	out := string(textToXHTML([]byte(text)))
+
+	for _, s := range []string{
+		`<meta name="DC.Title" content="羅生門" />`,
+		`<meta name="DC.Creator" content="芥川龍之介" />`,
+		`<h1 class="title">羅生門</h1>`,
+		`<div class="main_text"><h3 class="o-midashi">`,
+		`<ruby><rb>下人</rb><rp>（</rp><rt>げにん</rt><rp>）</rp></ruby>の行方は、<ruby><rb>誰</rb>`,
+		`<span class="notes">［＃改ページ］</span>`,
+		`<div class="bibliographical_information">` + "\n<hr />\n<br />\n底本：「全集」<br />",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("output lacks %s:\n%s", s, out)
+		}
+	}
+
+	// Shift_JIS input
+	if out := string(textToXHTML([]byte{0x82, 0xa0, 0x0d, 0x0a})); !strings.Contains(out, `<h1 class="title">あ</h1>`) {
+		t.Errorf("Shift_JIS not converted:\n%s", out)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"archive/zip"
 	"bytes"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"image"
@@ -11,14 +12,12 @@ import (
 	"image/png"
 	"io"
 	"log"
-	"math/rand"
 	"mime"
-	"net/http"
-	"net/url"
-	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/adamay909/AozoraConvert/mobi"
@@ -38,87 +37,152 @@ type fileData struct {
 
 // RenderWebpage returns b as a single web page.
 // It will be  UTF-8 encoded and css will be
-// for vertical reading.
+// for vertical reading. Errors are logged and dropped.
+//
+// Deprecated: Use WriteWebpage, which returns the errors.
 func (b *Book) RenderWebpage() []byte {
 
-	builder := new(strings.Builder)
+	buf := new(bytes.Buffer)
 
-	err := webpageTemplate().Execute(builder, b)
+	err := b.WriteWebpage(buf)
 	if err != nil {
 		log.Println(err)
 	}
-	return []byte(builder.String())
+	return buf.Bytes()
 }
 
-// RenderMonolithic returns b as a single, monolithic
-// webpage. All graphics are inline.
-func (b *Book) RenderMonolithicHTML() []byte {
+// WriteWebpage writes b as a single web page to w.
+// It will be  UTF-8 encoded and css will be
+// for vertical reading.
+func (b *Book) WriteWebpage(w io.Writer) error {
 
-	b.EmbedImages()
+	return b.withTOCPage(func() error {
+		return executeTemplate(w, webpageTemplate(), b)
+	})
+}
 
-	builder := new(strings.Builder)
+// RenderMonolithic returns b as a single, monolithic
+// webpage. All graphics are inline. Errors are logged and
+// dropped.
+//
+// Deprecated: Use WriteMonolithicHTML, which returns the errors.
+func (b *Book) RenderMonolithicHTML() []byte {
 
-	err := inlineCSSTemplate().Execute(builder, b)
+	buf := new(bytes.Buffer)
 
+	err := b.WriteMonolithicHTML(buf)
 	if err != nil {
 		log.Println(err)
 	}
 
-	b.UnembedImages()
+	return buf.Bytes()
+
+}
+
+// WriteMonolithicHTML writes b as a single, monolithic
+// webpage to w. All graphics are inline.
+func (b *Book) WriteMonolithicHTML(w io.Writer) error {
+
+	b.EmbedImages()
 
-	return []byte(builder.String())
+	defer b.UnembedImages()
 
+	return b.withTOCPage(func() error {
+		return executeTemplate(w, inlineCSSTemplate(), b)
+	})
 }
 
 func (b *Book) renderInlineCSS() []byte {
 
-	builder := new(strings.Builder)
+	buf := new(bytes.Buffer)
 
-	err := inlineCSSTemplate().Execute(builder, b)
+	err := executeTemplate(buf, inlineCSSTemplate(), b)
 	if err != nil {
 		log.Println(err)
 	}
-	return []byte(builder.String())
+	return buf.Bytes()
 }
 
 // RenderWebpagePackage returns a zip archive containing
 // all the files necessary (html, css, graphics files) to
-// show the page correctly in a web browser.
+// show the page correctly in a web browser. Errors are logged and
+// dropped.
+//
+// Deprecated: Use WriteWebpagePackage, which returns the errors.
 func (b *Book) RenderWebpagePackage() []byte {
 
 	buf := new(bytes.Buffer)
 
-	w := zip.NewWriter(buf)
+	err := b.WriteWebpagePackage(buf)
+	if err != nil {
+		log.Println(err)
+	}
+	return buf.Bytes()
+}
+
+// WriteWebpagePackage writes a zip archive containing
+// all the files necessary (html, css, graphics files) to
+// show the page correctly in a web browser to w.
+func (b *Book) WriteWebpagePackage(out io.Writer) error {
+
+	w := newZipWriter(out, b.modTime())
 
 	f, err := w.Create("1.html")
-	_, err = f.Write(b.RenderWebpage())
+	if err != nil {
+		return err
+	}
+
+	err = b.WriteWebpage(f)
+	if err != nil {
+		return err
+	}
 
 	//write support files
 	for _, file := range b.Files {
 		f, err = w.Create(file.Name)
 		if err != nil {
-			log.Println(err)
+			return err
 		}
 		_, err = f.Write(file.Data)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = w.Close()
+	return w.Close()
+}
+
+// RenderEpub returns b as a zipped Epub
+// file. Errors are logged and dropped.
+//
+// Deprecated: Use WriteEpub, which returns the errors.
+func (b *Book) RenderEpub() []byte {
+
+	buf := new(bytes.Buffer)
+
+	err := b.WriteEpub(buf)
 	if err != nil {
 		log.Println(err)
 	}
 	return buf.Bytes()
 }
 
-// RenderEpub returns b as a zipped Epub
-// file.
-func (b *Book) RenderEpub() []byte {
+// WriteEpub writes b as a zipped Epub file to out.
+func (b *Book) WriteEpub(out io.Writer) error {
 
-	buf := new(bytes.Buffer)
+	return b.withTOCPage(func() error {
+		return b.writeEpub(out)
+	})
+}
+
+func (b *Book) writeEpub(out io.Writer) error {
+
+	b.setStableUUID()
 
-	w := zip.NewWriter(buf)
+	w := newZipWriter(out, b.modTime())
 
 	//set mod time
-	b.DateMod = time.Now().Format(time.DateOnly) + "T00:00:00Z"
+	b.DateMod = w.modified.Format("2006-01-02T15:04:05Z")
 
 	//write mimetype file
 	fh := new(zip.FileHeader)
@@ -128,120 +192,158 @@ func (b *Book) RenderEpub() []byte {
 	fh.UncompressedSize64 = uint64(len(mt))
 	fh.CompressedSize64 = uint64(len(mt))
 	fh.CRC32 = crc32.ChecksumIEEE(mt)
+	// CreateRaw does not fill in the MS-DOS time from fh.Modified
+	fh.SetModTime(w.modified) //nolint:staticcheck
 	iw, err := w.CreateRaw(fh)
 	if err != nil {
-		log.Println(err)
+		return err
+	}
+	_, err = iw.Write(mt)
+	if err != nil {
+		return err
 	}
-	iw.Write(mt)
 
-	/*
-		f, err := w.Create("mimetype")
-		_, err = f.Write([]byte("application/epub+zip"))
-		if err != nil {
-			log.Println(err)
-		}
-	*/
 	//write META-INF
-	f, err := w.Create("META-INF/container.xml")
-	_, err = f.Write(metainf(b))
+	err = writeTemplateFile(w, "META-INF/container.xml", oebMetaInf(), b)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
+
 	// write title image
-	f, err = w.Create("OEBPF/cover.png")
-	//	b.genTitlePage()
-	img := new(bytes.Buffer)
-	_ = png.Encode(img, b.CoverImage)
-	_, err = f.Write(img.Bytes())
+	if b.CoverImage == nil {
+		return errors.New("azrconvert: book has no cover image")
+	}
+	f, err := w.Create("OEBPF/cover.png")
 	if err != nil {
-		log.Println(err)
+		return err
 	}
-	//write title page
-
-	f, err = w.Create("OEBPF/title.html")
-	_, err = f.Write(oebtitle(b))
+	err = png.Encode(f, b.CoverImage)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
 
-	//write main file
-	f, err = w.Create("OEBPF/1.html")
-	_, err = f.Write(oebmain(b))
+	//write title page
+	err = writeTemplateFile(w, "OEBPF/title.html", oebTitleTemplate(), b)
 	if err != nil {
-		log.Println(err)
+		return err
+	}
+
+	//write main files
+	b.splitBody()
+	for _, p := range b.parts {
+		err = writeTemplateFile(w, "OEBPF/"+p.Name, oebHTMLTemplate(), p)
+		if err != nil {
+			return err
+		}
 	}
 
 	//write opf
-	f, err = w.Create("OEBPF/content.opf")
-	_, err = f.Write(contentopf(b))
+	err = writeTemplateFile(w, "OEBPF/content.opf", contentopfTemplate(), b)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
-	/*
-		counter = 0
-		//write toc
-		f, err = w.Create("toc.ncx")
-		_, err = f.Write(tocncx(b))
-		if err != nil {
-			log.Println(err)
-		}
-	*/
 
-	counter = 0
 	//write Epub3 toc
-	f, err = w.Create("OEBPF/toc.xhtml")
-	_, err = f.Write(tocep3(b))
+	err = writeTemplateFile(w, "OEBPF/toc.xhtml", tocep3Template(), b)
 	if err != nil {
-		log.Println(err)
+		return err
 	}
 
 	//write support files
 	for _, file := range b.Files {
 		f, err = w.Create("OEBPF/" + file.Name)
 		if err != nil {
-			log.Println(err)
+			return err
 		}
 		_, err = f.Write(file.Data)
+		if err != nil {
+			return err
+		}
 	}
 
-	err = w.Close()
+	return w.Close()
+}
+
+// RenderAZW3 returrns b as an AZW3 file. Errors are logged and
+// dropped.
+//
+// Deprecated: Use WriteAZW3, which returns the errors.
+func (b *Book) RenderAZW3() []byte {
+
+	buf := new(bytes.Buffer)
+
+	err := b.WriteAZW3(buf)
 	if err != nil {
 		log.Println(err)
 	}
 	return buf.Bytes()
 }
 
-// RenderAZW3 returrns b as an AZW3 file
-func (b *Book) RenderAZW3() []byte {
-	var text string
+// WriteAZW3 writes b as an AZW3 file to w.
+func (b *Book) WriteAZW3(w io.Writer) error {
+
+	return b.withTOCPage(func() error {
+		return b.writeAZW3(w)
+	})
+}
+
+func (b *Book) writeAZW3(w io.Writer) error {
+
+	if len(b.Body) < 2 {
+		return ErrNoBody
+	}
+
+	b.setStableUUID()
+
+	authors, readings := b.authors()
 
 	mb := mobi.Book{
-		Title:       b.Title,
-		Authors:     []string{b.Creator},
-		Publisher:   b.Publisher,
-		DocType:     "EBOK",
-		Language:    language.Japanese,
-		FixedLayout: false,
-		Vertical:    true,
-		RightToLeft: true,
-		UniqueID:    rand.Uint32(),
-		CSSFlows:    []string{b.CSS + string(verticalCSS()), string(aozoraCSS())},
-		CoverImage:  b.CoverImage,
-		ThumbImage:  b.CoverImage,
-		Images:      b.Images,
-	}
-
-	//fix image links
+		Title:             b.Title,
+		TitleFurigana:     furigana(b.TitleReading),
+		Authors:           authors,
+		AuthorsFurigana:   readings,
+		PublisherFurigana: furigana(b.publisherReading()),
+		Contributors:      b.contributors(),
+		Publisher:         b.Publisher,
+		Subject:           strings.Join(b.Subjects, "; "),
+		Source:            b.Source,
+		Description:       b.Description(),
+		Rights:            b.Rights,
+		PublishedDate:     b.publishedDate(),
+		DocType:           "EBOK",
+		Language:          language.Japanese,
+		FixedLayout:       false,
+		Vertical:          true,
+		RightToLeft:       true,
+		UniqueID:          b.uniqueID(),
+		Compress:          true,
+		Joint:             b.JointMOBI,
+		CreatedDate:       b.modTime(),
+		CSSFlows:          []string{b.CSS + string(verticalCSS()), string(aozoraCSS())},
+		CoverImage:        b.CoverImage,
+		ThumbImage:        b.CoverImage,
+		Images:            b.Images,
+	}
+
+	//fix image links; images used several times, such as gaiji,
+	//have a single record.
+	recs := make(map[string]int)
+	for _, f := range b.Files {
+		if f.Mtype == "image/png" || f.Mtype == "image/jpeg" {
+			recs[f.Name] = len(recs) + 1
+		}
+	}
+
 	imc := 0
 	for _, t := range b.Body {
 		if isImg(t) {
 			filename := getAttr(t, "src")
 			imc++
-			p := strings.ToUpper(strconv.FormatInt(int64(imc), 16))
-			for len(p) < 4 {
-				p = "0" + p
+			rec, ok := recs[filename]
+			if !ok {
+				rec = imc
 			}
-			p = "kindle:embed:" + p + "?mime=" + mime.TypeByExtension(filepath.Ext(filename))
+			p := "kindle:embed:" + records.To32(rec) + "?mime=" + mime.TypeByExtension(filepath.Ext(filename))
 
 			delAttr(t, "src")
 			setAttr(t, "src", p)
@@ -252,63 +354,197 @@ func (b *Book) RenderAZW3() []byte {
 		}
 	}
 
-	text = ""
+	mb.Chapters, mb.Guide = b.azw3Chapters()
+
+	db, err := mb.Realize()
+	if errors.Is(err, mobi.ErrTemplate) {
+		return fmt.Errorf("%w: %w", ErrTemplate, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	return db.Write(w)
+
+}
+
+// azw3Chapters returns the sections of b as chapters for AZW3 output
+// together with the guide. A chapter is split into chunks at the
+// landmarks inside it so that the guide can refer to them.
+func (b *Book) azw3Chapters() ([]mobi.Chapter, []mobi.GuideEntry) {
+
+	type part struct {
+		title      string
+		depth      int
+		start, end int
+	}
+
+	var parts []part
+
 	if b.TopSection != nil {
 
-		var sec *section
-		//		st := 0
-		for sec = b.TopSection; sec.nextSibling != nil; sec = sec.nextSibling {
+		secs := b.sectionList()
 
-			if sec == b.TopSection {
-				text = renderTokens(b.Body[sec.start+1 : sec.nextSibling.start])
-			} else {
-				text = renderTokens(b.Body[sec.start:sec.nextSibling.start])
+		for i, sec := range secs {
+
+			p := part{sec.title, sec.depth, sec.start, len(b.Body)}
+			if i == 0 {
+				p.start++
+			}
+			if i+1 < len(secs) {
+				p.end = secs[i+1].start
 			}
 
-			mb.Chapters = append(mb.Chapters, mobi.Chapter{
-				Title:  sec.title,
-				Chunks: mobi.Chunks(text),
-			})
+			parts = append(parts, p)
+		}
 
+	} else {
+		parts = append(parts, part{b.Title, 0, 1, len(b.Body) - 1})
+	}
+
+	marks := make(map[*html.Token]landmark)
+	for _, l := range b.landmarks() {
+		if l.token != nil {
+			marks[l.token] = l
 		}
+	}
 
-		text = renderTokens(b.Body[sec.start:])
-		mb.Chapters = append(mb.Chapters, mobi.Chapter{
-			Title:  sec.title,
-			Chunks: mobi.Chunks(text),
-		})
+	// chunks also start at the targets of links inside the book so
+	// that the links can refer to the start of a chunk
+	targets := make(map[string]bool)
+	for _, t := range b.Body {
+		if ref := getAttr(t, "href"); len(ref) > 1 && ref[0] == '#' {
+			targets[ref[1:]] = true
+		}
+	}
 
-	} else {
-		text = renderTokens(b.Body[1 : len(b.Body)-1])
+	var guide []mobi.GuideEntry
 
-		mb.Chapters = append(mb.Chapters, mobi.Chapter{
-			Title:  b.Title,
-			Chunks: mobi.Chunks(text),
-		})
+	// fids holds the index in the whole book of the chunk each
+	// link target starts.
+	fids := make(map[string]int)
 
+	// a landmark or link target on the element enclosing the body
+	// is at the start of the first chapter
+	if l, ok := marks[b.Body[0]]; ok {
+		guide = append(guide, mobi.GuideEntry{Type: l.guideType, Title: l.title})
+	}
+	if id := getAttr(b.Body[0], "id"); targets[id] {
+		fids[id] = 0
 	}
 
-	buf := new(bytes.Buffer)
-	err := mb.Realize().Write(buf)
-	if err != nil {
-		log.Println(err)
+	// splits holds the indices in b.Body at which the chunks of
+	// each part start.
+	splits := make([][]int, len(parts))
+
+	n := 0
+
+	for i, p := range parts {
+
+		splits[i] = []int{p.start}
+
+		for k := p.start; k < p.end; k++ {
+
+			l, mark := marks[b.Body[k]]
+			id := getAttr(b.Body[k], "id")
+
+			if !mark && !targets[id] {
+				continue
+			}
+
+			if k > splits[i][len(splits[i])-1] {
+				splits[i] = append(splits[i], k)
+			}
+
+			chunk := len(splits[i]) - 1
+
+			if targets[id] {
+				fids[id] = n + chunk
+			}
+
+			if mark {
+				guide = append(guide, mobi.GuideEntry{
+					Type:    l.guideType,
+					Title:   l.title,
+					Chapter: i,
+					Chunk:   chunk,
+				})
+			}
+		}
+
+		n += len(splits[i])
 	}
-	return buf.Bytes()
 
+	var chapters []mobi.Chapter
+
+	for i, p := range parts {
+
+		c := mobi.Chapter{Title: p.title, Depth: p.depth}
+
+		for k, from := range splits[i] {
+
+			to := p.end
+			if k+1 < len(splits[i]) {
+				to = splits[i][k+1]
+			}
+
+			c.Chunks = append(c.Chunks, mobi.Chunks(renderTokens(kindleLinks(b.Body[from:to], fids)))...)
+		}
+
+		chapters = append(chapters, c)
+	}
+
+	return chapters, guide
 }
 
-// AddFiles adds the CSS style files as well as
-// all the image files requested by the book.
-func (b *Book) AddFiles() {
+// kindleLinks returns tokens with the links to the ids in fids
+// replaced by kindle:pos links to the start of their chunk.
+func kindleLinks(tokens []*html.Token, fids map[string]int) []*html.Token {
 
-	u, err := url.Parse(b.URI)
+	out := make([]*html.Token, len(tokens))
 
-	if err != nil {
-		log.Println(err)
-		return
+	for i, t := range tokens {
+
+		out[i] = t
+
+		ref := getAttr(t, "href")
+		if !strings.HasPrefix(ref, "#") {
+			continue
+		}
+
+		fid, ok := fids[ref[1:]]
+		if !ok {
+			continue
+		}
+
+		nt := copyToken(t)
+		setAttr(nt, "href", mobi.PosLink(fid, 0))
+		out[i] = nt
 	}
 
-	for _, t := range b.Body {
+	return out
+}
+
+// AddFiles adds the CSS style files as well as
+// all the image files requested by the book. Images
+// that cannot be added are reported in the returned
+// error, which wraps ErrImageDownload. The CSS files
+// are added in any case.
+func (b *Book) AddFiles() error {
+
+	var errs []error
+
+	for i := 0; i < len(b.Body); i++ {
+
+		t := b.Body[i]
+
+		if isGaijiImage(t) {
+			if n, ok := b.addGaijiImage(t); !ok {
+				b.Body = slices.Replace(b.Body, i, i+1, n...)
+				i += len(n) - 1
+			}
+			continue
+		}
 
 		if isImg(t) {
 			var fi fileData
@@ -316,7 +552,11 @@ func (b *Book) AddFiles() {
 
 			alt := getAttr(t, "alt")
 			path := getAttr(t, "src")
-			fi.Location = u.Scheme + "://" + u.Host + filepath.Dir(u.Path) + "/" + path
+			fi.Location, err = b.resolve(path)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%w %s: %w", ErrImageDownload, path, err))
+				continue
+			}
 
 			t.Type = html.SelfClosingTagToken //need this to make sure tag self-closes
 
@@ -329,9 +569,10 @@ func (b *Book) AddFiles() {
 			}
 			fi.Name = fi.Name + ext
 			fi.Mtype = mime.TypeByExtension(filepath.Ext(fi.Name))
-			fi.Data, err = downloadFile(fi.Location)
+			fi.Data, err = b.fetcher().Fetch(fi.Location)
 			if err != nil {
 				log.Println("Could not add", fi.Location)
+				errs = append(errs, fmt.Errorf("%w %s: %w", ErrImageDownload, fi.Location, err))
 				continue
 			}
 
@@ -352,6 +593,12 @@ func (b *Book) AddFiles() {
 			//fix css
 			if err != nil {
 				log.Println("Could not determine size of image", fi.Location)
+				errs = append(errs, fmt.Errorf("%w %s: %w", ErrImageDownload, fi.Location, err))
+				continue
+			}
+			if im == nil {
+				log.Println("Could not determine size of image", fi.Location)
+				errs = append(errs, fmt.Errorf("%w %s: unsupported image type %s", ErrImageDownload, fi.Location, fi.Mtype))
 				continue
 			}
 
@@ -365,7 +612,7 @@ func (b *Book) AddFiles() {
 			setAttr(t, "src", fi.Name)
 			setAttr(t, "alt", alt)
 			setAttr(t, "style", fi.CSS)
-			fmt.Println("image CSS", fi.CSS)
+			log.Println("image CSS", fi.CSS)
 
 			b.Files = append(b.Files, fi)
 			b.Images = append(b.Images, records.ImageRecord{Data: fi.Data, Ext: ext})
@@ -387,7 +634,79 @@ func (b *Book) AddFiles() {
 	fi.Mtype = "text/css"
 	b.Files = append(b.Files, fi)
 
-	return
+	return errors.Join(errs...)
+}
+
+// addGaijiImage adds the image of the gaiji t, which is written at
+// the size of the text by the style sheet. Images used several times
+// are added once. If the image cannot be added, it returns false and
+// the tokens to write the gaiji with instead.
+func (b *Book) addGaijiImage(t *html.Token) ([]*html.Token, bool) {
+
+	alt := getAttr(t, "alt")
+	path := getAttr(t, "src")
+
+	idx, err := strconv.Atoi(getAttr(t, "data-gaiji"))
+	if err != nil || idx >= len(b.Gaiji) {
+		idx = -1
+	}
+
+	fail := func(err error) ([]*html.Token, bool) {
+		log.Println("Could not add gaiji", path, err)
+		if idx < 0 {
+			return []*html.Token{t}, false
+		}
+		n := b.gaijiFallback(idx)
+		if n == nil {
+			n = []*html.Token{t}
+		}
+		return n, false
+	}
+
+	loc, err := b.resolve(path)
+	if err != nil {
+		return fail(err)
+	}
+
+	var fi fileData
+
+	for _, f := range b.Files {
+		if f.Location == loc {
+			fi = f
+		}
+	}
+
+	if fi.Name == "" {
+
+		fi.Location = loc
+		fi.Data, err = b.fetcher().Fetch(loc)
+		if err != nil {
+			return fail(err)
+		}
+
+		if _, err := png.Decode(bytes.NewReader(fi.Data)); err != nil {
+			return fail(err)
+		}
+
+		fi.Name = fmt.Sprintf("%05d.png", len(b.Files)+1)
+		fi.ID = "image" + strings.TrimSuffix(fi.Name, ".png")
+		fi.Mtype = "image/png"
+
+		b.Files = append(b.Files, fi)
+		b.Images = append(b.Images, records.ImageRecord{Data: fi.Data, Ext: ".png"})
+	}
+
+	if idx >= 0 {
+		b.Gaiji[idx].Text = fi.Name
+	}
+
+	t.Type = html.SelfClosingTagToken
+	t.Attr = nil
+	setAttr(t, "class", "gaiji")
+	setAttr(t, "src", fi.Name)
+	setAttr(t, "alt", alt)
+
+	return []*html.Token{t}, true
 }
 
 func getSize(im image.Image) (w, h int) {
@@ -442,100 +761,62 @@ func (b *Book) RenderBodyInnerMonolithic() string {
 
 }
 
-func oebmain(b *Book) []byte {
+func executeTemplate(w io.Writer, t *template.Template, b any) error {
 
-	builder := new(strings.Builder)
-	err := oebHTMLTemplate().Execute(builder, b)
+	err := t.Execute(w, b)
 	if err != nil {
-		log.Println(err)
+		return fmt.Errorf("%w %s: %w", ErrTemplate, t.Name(), err)
 	}
 
-	return []byte(builder.String())
+	return nil
 }
 
-func oebtitle(b *Book) []byte {
-
-	builder := new(strings.Builder)
-	err := oebTitleTemplate().Execute(builder, b)
-	if err != nil {
-		log.Println(err)
-	}
-
-	return []byte(builder.String())
+// zipWriter is a zip.Writer that gives all files the same
+// modification time.
+type zipWriter struct {
+	*zip.Writer
+	modified time.Time
 }
 
-func metainf(b *Book) []byte {
+func newZipWriter(out io.Writer, modified time.Time) *zipWriter {
 
-	builder := new(strings.Builder)
-	err := oebMetaInf().Execute(builder, b)
-	if err != nil {
-		log.Println(err)
+	if modified.Before(zipEpoch) {
+		modified = zipEpoch
 	}
 
-	return []byte(builder.String())
+	// zip stores times with a resolution of two seconds
+	return &zipWriter{zip.NewWriter(out), modified.Truncate(2 * time.Second)}
 }
 
-func contentopf(b *Book) []byte {
-
-	builder := new(strings.Builder)
-	err := contentopfTemplate().Execute(builder, b)
-	if err != nil {
-		log.Println(err)
-	}
+// Create adds a compressed file called name to the archive.
+func (w *zipWriter) Create(name string) (io.Writer, error) {
 
-	return []byte(builder.String())
+	return w.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: w.modified,
+	})
 }
 
-func tocncx(b *Book) []byte {
+func writeTemplateFile(w *zipWriter, name string, t *template.Template, b any) error {
 
-	builder := new(strings.Builder)
-	err := tocTemplate().Execute(builder, b)
+	f, err := w.Create(name)
 	if err != nil {
-		log.Println(err)
-	}
-
-	return []byte(builder.String())
-}
-
-func tocep3(b *Book) []byte {
-	builder := new(strings.Builder)
-	err := tocep3Template().Execute(builder, b)
-	if err != nil {
-		log.Println(err)
+		return err
 	}
 
-	return []byte(builder.String())
+	return executeTemplate(f, t, b)
 }
 
-func downloadFile(location string) (data []byte, err error) {
-	path, _ := url.Parse(location)
-
-	/*if path.Host == "" {
+func tocncx(b *Book) []byte {
 
-		return getLocalFile(path.Path)
-	}*/
-	r, err := http.Get(path.String())
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	log.Println("file downloaded")
-	data, err = io.ReadAll(r.Body)
+	buf := new(bytes.Buffer)
+	err := executeTemplate(buf, tocTemplate(), b)
 	if err != nil {
 		log.Println(err)
-		return
 	}
 
-	r.Body.Close()
-	return data, err
-}
-
-func getLocalFile(path string) (data []byte) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		log.Println(err)
-	}
-	return
+	return buf.Bytes()
 }
 
 func renderTokens(in []*html.Token) string {
@@ -549,7 +830,7 @@ func renderTokens(in []*html.Token) string {
 	return w.String() //string(prettifyEmptyLines([]byte(w.String())))
 }
 
-func (bk *Book) addFilesFromZip(arch *zip.Reader) {
+func (bk *Book) addFilesFromZip(arch *zip.Reader) error {
 
 	for _, f := range arch.File {
 
@@ -563,16 +844,14 @@ func (bk *Book) addFilesFromZip(arch *zip.Reader) {
 
 		fi.Mtype = mime.TypeByExtension(filepath.Ext(fi.Name))
 		r, err := f.Open()
-		defer r.Close()
 		if err != nil {
-			log.Println(err)
-			return
+			return err
 		}
 
 		fi.Data, err = io.ReadAll(r)
+		r.Close()
 		if err != nil {
-			log.Println(err)
-			return
+			return err
 		}
 
 		bk.Files = append(bk.Files, fi)
@@ -581,6 +860,8 @@ func (bk *Book) addFilesFromZip(arch *zip.Reader) {
 			bk.Images = append(bk.Images, records.ImageRecord{Data: fi.Data, Ext: filepath.Ext(fi.Name)})
 		}
 	}
+
+	return nil
 }
 
 // EmbedImages adds images as inline HTMLk.
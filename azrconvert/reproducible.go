@@ -0,0 +1,103 @@
+package azrconvert
+
+import (
+	"encoding/binary"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// aozoraNamespace is the namespace of the identifiers derived from
+// the contents of books.
+var aozoraNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://www.aozora.gr.jp/"))
+
+// SetReproducible turns reproducible output on or off. If it is on,
+// converting the same book again produces byte-identical EPUB, AZW3
+// and zip files: the identifier of the book is a UUID (version 5)
+// derived from its URI or contents, and all timestamps are taken from
+// ModTime, from the environment variable SOURCE_DATE_EPOCH, or from
+// the dates in the colophon, in that order.
+func (b *Book) SetReproducible(on bool) {
+	b.Reproducible = on
+}
+
+// setStableUUID sets the identifier of b to the one derived from b if
+// reproducible output has been requested.
+func (b *Book) setStableUUID() {
+
+	if b.Reproducible {
+		b.UUID = b.stableUUID().String()
+	}
+}
+
+// stableUUID returns the identifier derived from b. Texts with an
+// absolute URI are identified by their URI so that a corrected text
+// keeps its identifier. Other texts are identified by their contents.
+func (b *Book) stableUUID() uuid.UUID {
+
+	if u, err := url.Parse(b.URI); err == nil && u.IsAbs() {
+		return uuid.NewSHA1(uuid.NameSpaceURL, []byte(b.URI))
+	}
+
+	return uuid.NewSHA1(aozoraNamespace, []byte(b.Hash))
+}
+
+// uniqueID returns the unique id of b for the MOBI header. It is
+// derived from the identifier of b.
+func (b *Book) uniqueID() uint32 {
+
+	id, err := uuid.Parse(b.UUID)
+	if err != nil {
+		id = b.stableUUID()
+	}
+
+	return binary.BigEndian.Uint32(id[:4])
+}
+
+// modTime returns the time used as modification and creation time of
+// the output.
+func (b *Book) modTime() time.Time {
+
+	if !b.ModTime.IsZero() {
+		return b.ModTime.UTC()
+	}
+
+	if !b.Reproducible {
+		return time.Now().UTC()
+	}
+
+	if t, ok := sourceDateEpoch(); ok {
+		return t
+	}
+
+	for _, d := range []string{b.Revised, b.Date} {
+		if t, err := time.Parse(time.DateOnly, d); err == nil {
+			return t
+		}
+	}
+
+	return zipEpoch
+}
+
+// zipEpoch is the earliest time that can be stored in a zip file.
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// sourceDateEpoch returns the time given by SOURCE_DATE_EPOCH. See
+// https://reproducible-builds.org/specs/source-date-epoch/
+func sourceDateEpoch() (time.Time, bool) {
+
+	s := os.Getenv("SOURCE_DATE_EPOCH")
+	if s == "" {
+		return time.Time{}, false
+	}
+
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return time.Unix(sec, 0).UTC(), true
+}
@@ -0,0 +1,88 @@
+package azrconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestReproducible(t *testing.T) {
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+
+	convert := func() (*Book, map[string][]byte) {
+
+		bk := NewBook()
+		bk.SetURI("https://www.aozora.gr.jp/cards/000000/files/1_1.html")
+		bk.SetReproducible(true)
+
+		err := bk.getBookFrom(cleanUTF8([]byte(splitTestPage)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bk.SetMetadataFromPreamble()
+
+		err = bk.GenTitlePage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out := make(map[string][]byte)
+
+		for name, write := range map[string]func(io.Writer) error{
+			"epub": bk.WriteEpub,
+			"azw3": bk.WriteAZW3,
+			"zip":  bk.WriteWebpagePackage,
+		} {
+			buf := new(bytes.Buffer)
+			err = write(buf)
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			out[name] = buf.Bytes()
+		}
+
+		return bk, out
+	}
+
+	b1, out1 := convert()
+	b2, out2 := convert()
+
+	if b1.UUID != b2.UUID {
+		t.Errorf("identifiers differ: %s, %s", b1.UUID, b2.UUID)
+	}
+
+	for name := range out1 {
+		if !bytes.Equal(out1[name], out2[name]) {
+			t.Errorf("%s output differs between runs", name)
+		}
+	}
+
+	want := time.Unix(1700000000, 0).UTC()
+
+	if b1.DateMod != "2023-11-14T22:13:20Z" {
+		t.Errorf("DateMod is %s", b1.DateMod)
+	}
+
+	arch, err := zip.NewReader(bytes.NewReader(out1["epub"]), int64(len(out1["epub"])))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, f := range arch.File {
+		if !f.Modified.Equal(want) {
+			t.Errorf("%s: modified %v, want %v", f.Name, f.Modified, want)
+		}
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+
+	b1.Revised = "2005-02-03"
+
+	if got := b1.modTime(); !got.Equal(time.Date(2005, 2, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("modTime without SOURCE_DATE_EPOCH is %v", got)
+	}
+}
@@ -0,0 +1,257 @@
+package azrconvert
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// bodyPart is one of the xhtml files the body of a book is split
+// into for EPUB output. Long texts in a single file are very slow to
+// open on e-readers.
+type bodyPart struct {
+	*Book
+	ID, Name string
+	tokens   []*html.Token
+}
+
+// RenderBody renders the part of the body in p.
+func (p *bodyPart) RenderBody() string {
+	return renderTokens(p.tokens)
+}
+
+// Parts returns the xhtml files the body of b is split into for EPUB
+// output. It is only valid while writing an EPUB.
+func (b *Book) Parts() []*bodyPart {
+	return b.parts
+}
+
+// href returns the link to the element with the given id, taking
+// into account the file it ends up in.
+func (b *Book) href(id string) string {
+
+	name, ok := b.partOf[id]
+	if !ok {
+		name = "1.html"
+	}
+
+	return name + "#" + id
+}
+
+// splitBody splits the body of b at the start of each section and at
+// page breaks. Elements enclosing a split point are closed at the end
+// of a part and opened again at the start of the next one. Links to
+// ids in other parts are rewritten. b.Body itself is not modified.
+func (b *Book) splitBody() {
+
+	b.parts = nil
+	b.partOf = make(map[string]string)
+
+	if len(b.Body) < 2 {
+		return
+	}
+
+	body := b.Body[1 : len(b.Body)-1]
+
+	points := splitPoints(body, b.sectionNodes())
+
+	var open []*html.Token
+
+	for n, start := range points {
+
+		end := len(body)
+		if n+1 < len(points) {
+			end = points[n+1]
+		}
+
+		p := &bodyPart{
+			Book: b,
+			ID:   "html" + strconv.Itoa(n+1),
+			Name: strconv.Itoa(n+1) + ".html",
+		}
+
+		if n == 0 {
+			p.tokens = append(p.tokens, b.Body[0])
+		} else {
+			p.tokens = append(p.tokens, withoutID(b.Body[0]))
+		}
+
+		for _, t := range open {
+			p.tokens = append(p.tokens, withoutID(t))
+		}
+
+		for _, t := range body[start:end] {
+
+			p.tokens = append(p.tokens, t)
+
+			if id := getAttr(t, "id"); id != "" {
+				if _, ok := b.partOf[id]; !ok {
+					b.partOf[id] = p.Name
+				}
+			}
+
+			open = updateOpen(open, t)
+		}
+
+		for i := len(open) - 1; i >= 0; i-- {
+			p.tokens = append(p.tokens, &html.Token{Type: html.EndTagToken, DataAtom: open[i].DataAtom, Data: open[i].Data})
+		}
+
+		p.tokens = append(p.tokens, b.Body[len(b.Body)-1])
+
+		b.parts = append(b.parts, p)
+	}
+
+	// ids found on the body element belong to the first part
+	if id := getAttr(b.Body[0], "id"); id != "" {
+		b.partOf[id] = b.parts[0].Name
+	}
+
+	for _, p := range b.parts {
+		for i, t := range p.tokens {
+			ref := getAttr(t, "href")
+			if !strings.HasPrefix(ref, "#") {
+				continue
+			}
+			name, ok := b.partOf[ref[1:]]
+			if !ok || name == p.Name {
+				continue
+			}
+			nt := copyToken(t)
+			setAttr(nt, "href", name+ref)
+			p.tokens[i] = nt
+		}
+	}
+
+	return
+}
+
+// sectionNodes returns the header tokens starting the sections of b.
+func (b *Book) sectionNodes() map[*html.Token]bool {
+
+	nodes := make(map[*html.Token]bool)
+
+	var walk func(s *section)
+
+	walk = func(s *section) {
+		for ; s != nil; s = s.nextSibling {
+			nodes[s.node] = true
+			walk(s.firstChild)
+		}
+	}
+
+	walk(b.TopSection)
+
+	return nodes
+}
+
+// splitPoints returns the indices in body at which new parts start.
+// The first part always starts at 0. A split point is moved in front
+// of the start tags immediately preceding it so that a header
+// enclosed in a div starts a part together with the div. Parts
+// without content are avoided.
+func splitPoints(body []*html.Token, headers map[*html.Token]bool) []int {
+
+	points := []int{0}
+
+	content := false
+
+	for i, t := range body {
+
+		if !headers[t] && !isPageBreakDiv(t) {
+			if hasContent(t) {
+				content = true
+			}
+			continue
+		}
+
+		if !content {
+			continue
+		}
+
+		p := i
+		for p > points[len(points)-1]+1 && (body[p-1].Type == html.StartTagToken || isBlank(body[p-1])) && !isVoid(body[p-1]) {
+			p--
+		}
+
+		points = append(points, p)
+
+		content = hasContent(t)
+	}
+
+	// nothing but markup after a final page break
+	if !content && len(points) > 1 {
+		points = points[:len(points)-1]
+	}
+
+	return points
+}
+
+// updateOpen returns the elements still open after t, given the
+// elements open before t.
+func updateOpen(open []*html.Token, t *html.Token) []*html.Token {
+
+	switch {
+
+	case t.Type == html.StartTagToken && !isVoid(t):
+		return append(open, t)
+
+	case t.Type == html.EndTagToken:
+		for i := len(open) - 1; i >= 0; i-- {
+			if open[i].Data == t.Data {
+				return open[:i]
+			}
+		}
+	}
+
+	return open
+}
+
+func isPageBreakDiv(t *html.Token) bool {
+
+	if t.Type != html.StartTagToken || !isDiv(t) {
+		return false
+	}
+
+	return getAttr(t, "data-amznpagebreak") != "" || strings.Contains(getAttr(t, "style"), "page-break-before")
+}
+
+func isVoid(t *html.Token) bool {
+
+	switch t.DataAtom {
+	case atom.Br, atom.Img, atom.Hr, atom.Meta, atom.Link, atom.Input, atom.Wbr:
+		return true
+	default:
+		return t.Type == html.SelfClosingTagToken
+	}
+}
+
+func isBlank(t *html.Token) bool {
+	return t.Type == html.TextToken && strings.TrimSpace(t.Data) == ""
+}
+
+func hasContent(t *html.Token) bool {
+	return (t.Type == html.TextToken && !isBlank(t)) || t.DataAtom == atom.Img
+}
+
+func copyToken(t *html.Token) *html.Token {
+
+	nt := *t
+	nt.Attr = append([]html.Attribute(nil), t.Attr...)
+
+	return &nt
+}
+
+func withoutID(t *html.Token) *html.Token {
+
+	if !hasID(t) {
+		return t
+	}
+
+	nt := copyToken(t)
+	delAttr(nt, "id")
+
+	return nt
+}
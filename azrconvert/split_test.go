@@ -0,0 +1,142 @@
+package azrconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+const splitTestPage = `<html><head><title>test</title></head>
+<body>
+<div class="metadata"><h1 class="title">題</h1><h2 class="author">作者</h2></div>
+<div class="main_text">
+<h3 class="o-midashi"><a class="midashi_anchor" id="midashi10">一</a></h3>
+第一章の本文。<a href="#note1">注</a><br />
+<div class="jisage_3" style="margin-left: 3em"><h3 class="o-midashi"><a class="midashi_anchor" id="midashi20">二</a></h3>
+第二章の本文。<br />
+</div>
+<span class="notes">［＃改ページ］</span><br />
+改ページ後の本文。<a id="note1">注の本文</a><br />
+</div>
+</body></html>`
+
+// splitTestFiles returns the book of page and the files of the EPUB
+// made of it.
+func splitTestFiles(t *testing.T, page string) (*Book, map[string]string) {
+
+	bk := NewBook()
+
+	err := bk.getBookFrom(cleanUTF8([]byte(page)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bk.SetMetadataFromPreamble()
+
+	err = bk.GenTitlePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = bk.WriteEpub(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	arch, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string)
+
+	for _, f := range arch.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(d)
+	}
+
+	return bk, files
+}
+
+func TestSplitBody(t *testing.T) {
+
+	bk, files := splitTestFiles(t, splitTestPage)
+
+	for _, name := range []string{"OEBPF/1.html", "OEBPF/2.html", "OEBPF/3.html", "OEBPF/4.html"} {
+
+		d, ok := files[name]
+		if !ok {
+			t.Fatalf("%s missing", name)
+		}
+
+		dec := xml.NewDecoder(strings.NewReader(d))
+		dec.Strict = false
+		dec.AutoClose = xml.HTMLAutoClose
+		dec.Entity = xml.HTMLEntity
+		for {
+			_, err := dec.Token()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				break
+			}
+		}
+	}
+
+	if _, ok := files["OEBPF/5.html"]; ok {
+		t.Error("too many parts")
+	}
+
+	tests := []struct {
+		file, want string
+	}{
+		{"OEBPF/2.html", `href="4.html#note1"`},
+		{"OEBPF/3.html", `<div class="jisage_3"`},
+		{"OEBPF/4.html", `id="note1"`},
+		{"OEBPF/toc.xhtml", `href="3.html#azbc_120"`},
+		{"OEBPF/content.opf", `<itemref idref="html4"/>`},
+	}
+
+	for _, tc := range tests {
+		if !strings.Contains(files[tc.file], tc.want) {
+			t.Errorf("%s does not contain %s", tc.file, tc.want)
+		}
+	}
+
+	if strings.Contains(renderTokens(bk.Body), `href="4.html#note1"`) {
+		t.Error("body was modified")
+	}
+}
+
+func TestSplitBodyTrailingPageBreak(t *testing.T) {
+
+	page := strings.Replace(splitTestPage, "注の本文</a><br />\n", "注の本文</a><br />\n<span class=\"notes\">［＃改ページ］</span><br />\n", 1)
+
+	_, files := splitTestFiles(t, page)
+
+	if _, ok := files["OEBPF/4.html"]; !ok {
+		t.Fatal("4.html missing")
+	}
+
+	if _, ok := files["OEBPF/5.html"]; ok {
+		t.Error("part after the final page break")
+	}
+
+	if !strings.Contains(files["OEBPF/4.html"], "注の本文") {
+		t.Error("last part lacks its text")
+	}
+}
@@ -17,8 +17,6 @@ type section struct {
 	start, end                                   int
 }
 
-var counter int
-
 func (b *Book) RenderTOC() string {
 	w := new(strings.Builder)
 
@@ -32,34 +30,63 @@ func (b *Book) RenderTOC() string {
 
 	s := b.TopSection
 
-	addToTOC(s, w)
+	// the play order counts the navPoints from 1
+	order := 0
+
+	b.addToTOC(s, w, &order)
 	return w.String()
 }
 
-func addToTOC(s *section, w *strings.Builder) {
+func (b *Book) addToTOC(s *section, w *strings.Builder, order *int) {
 
 	var lead string
-	counter++
+	*order++
 	//	lead = strings.Repeat("    ", headerLevel(s.node)-3)
 
 	//	if len(s.content) != 0 {
-	w.WriteString(lead + `<navPoint id="` + s.id + `" playOrder="` + strconv.Itoa(counter) + `">` + "\n")
+	w.WriteString(lead + `<navPoint id="` + s.id + `" playOrder="` + strconv.Itoa(*order) + `">` + "\n")
 	w.WriteString(lead + "\t<navLabel>\n")
-	w.WriteString(lead + "\t\t<text>" + s.title + "</text>\n")
+	w.WriteString(lead + "\t\t<text>" + html.EscapeString(s.title) + "</text>\n")
 	w.WriteString(lead + "\t</navLabel>\n")
-	w.WriteString(lead + "\t<content src=" + `"1.html#` + s.id + `" />` + "\n")
+	w.WriteString(lead + "\t<content src=" + `"` + b.href(s.id) + `" />` + "\n")
 	//	}
 	if s.firstChild != nil {
-		addToTOC(s.firstChild, w)
+		b.addToTOC(s.firstChild, w, order)
 	}
 	w.WriteString(lead + "</navPoint>\n")
 	if s.nextSibling != nil {
-		addToTOC(s.nextSibling, w)
+		b.addToTOC(s.nextSibling, w, order)
 	}
 
 	return
 }
 
+// tocEntry is a section together with its depth in the table of
+// contents.
+type tocEntry struct {
+	*section
+	depth int
+}
+
+// sectionList returns the sections of b in the order of the text.
+func (b *Book) sectionList() []tocEntry {
+
+	var list []tocEntry
+
+	var walk func(s *section, depth int)
+
+	walk = func(s *section, depth int) {
+		for ; s != nil; s = s.nextSibling {
+			list = append(list, tocEntry{s, depth})
+			walk(s.firstChild, depth+1)
+		}
+	}
+
+	walk(b.TopSection, 0)
+
+	return list
+}
+
 func (b *Book) RenderEP3TOC() string {
 
 	w := new(strings.Builder)
@@ -72,34 +99,33 @@ func (b *Book) RenderEP3TOC() string {
 
 	w.WriteString("<ol>\n")
 
-	addToEP3TOC(s, w)
+	b.addToEP3TOC(s, w)
 
 	w.WriteString("</ol>\n")
 
 	return w.String()
 }
 
-func addToEP3TOC(s *section, w *strings.Builder) {
+func (b *Book) addToEP3TOC(s *section, w *strings.Builder) {
 
 	var lead string
-	counter++
 	//lead = strings.Repeat("    ", headerLevel(s.node)-3)
 
 	//	if len(s.content) != 0 {
 	w.WriteString(lead + `<li>`)
-	w.WriteString(`<a href="1.html#` + s.id + `">` + s.title + "</a>")
+	w.WriteString(`<a href="` + b.href(s.id) + `">` + html.EscapeString(s.title) + "</a>")
 	//	}
 	if s.firstChild != nil {
 		w.WriteString("\n")
 		w.WriteString(lead + "<ol>\n")
-		addToEP3TOC(s.firstChild, w)
+		b.addToEP3TOC(s.firstChild, w)
 		w.WriteString(lead + "</ol>\n")
 	}
 
 	w.WriteString("</li>\n")
 
 	if s.nextSibling != nil {
-		addToEP3TOC(s.nextSibling, w)
+		b.addToEP3TOC(s.nextSibling, w)
 	}
 	return
 }
@@ -0,0 +1,173 @@
+package azrconvert
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/adamay909/AozoraConvert/mobi"
+	"github.com/adamay909/AozoraConvert/mobi/validate"
+)
+
+const nestedTestPage = `<html><head><title>test</title></head>
+<body>
+<div class="metadata"><h1 class="title">題</h1><h2 class="author">作者</h2></div>
+<div class="main_text">
+<h3 class="o-midashi"><a class="midashi_anchor" id="midashi10">第一部</a></h3>
+<h4 class="naka-midashi"><a class="midashi_anchor" id="midashi20">第一章</a></h4>
+第一章の本文。<br />
+<h4 class="naka-midashi"><a class="midashi_anchor" id="midashi30">第二章</a></h4>
+第二章の本文。<br />
+<h3 class="o-midashi"><a class="midashi_anchor" id="midashi40">第二部</a></h3>
+<h4 class="naka-midashi"><a class="midashi_anchor" id="midashi50">第三章</a></h4>
+第三章の本文。<br />
+</div>
+</body></html>`
+
+func TestAZW3NestedTOC(t *testing.T) {
+
+	bk := NewBook()
+
+	err := bk.getBookFrom(cleanUTF8([]byte(nestedTestPage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bk.SetMetadataFromPreamble()
+
+	err = bk.GenTitlePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = bk.WriteAZW3(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range validate.Check(buf.Bytes()) {
+		t.Error(d)
+	}
+
+	f, err := mobi.Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		title  string
+		depth  int
+		parent int
+	}{
+		{"第一部", 0, -1},
+		{"第二部", 0, -1},
+		{"第一章", 1, 0},
+		{"第二章", 1, 0},
+		{"第三章", 1, 1},
+	}
+
+	if len(f.NCX) != len(want) {
+		t.Fatalf("NCX: %+v", f.NCX)
+	}
+
+	for i, w := range want {
+		if e := f.NCX[i]; e.Title != w.title || e.Depth != w.depth || e.Parent != w.parent {
+			t.Errorf("entry %d: %+v", i, e)
+		}
+	}
+}
+
+func TestAZW3Guide(t *testing.T) {
+
+	bk := NewBook()
+
+	err := bk.getBookFrom(cleanUTF8([]byte(nestedTestPage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bk.SetMetadataFromPreamble()
+
+	buf := new(bytes.Buffer)
+
+	err = bk.WriteAZW3(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range validate.Check(buf.Bytes()) {
+		t.Error(d)
+	}
+
+	f, err := mobi.Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"text":       `<div class="main_text"`,
+		"title-page": `<div class="metadata"`,
+	}
+
+	if len(f.Guide) != len(want) {
+		t.Fatalf("guide: %+v", f.Guide)
+	}
+
+	for _, g := range f.Guide {
+		frag := f.Fragments[g.Fragment]
+		text := string(f.Text[frag.InsertPos+g.Offset:])
+		if !strings.HasPrefix(text, want[g.Type]) {
+			t.Errorf("%s points at %.40q", g.Type, text)
+		}
+	}
+}
+
+func TestRenderTOCPlayOrder(t *testing.T) {
+
+	books := make([]*Book, 4)
+
+	for i := range books {
+
+		books[i] = NewBook()
+
+		err := books[i].getBookFrom(cleanUTF8([]byte(nestedTestPage)))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := books[0].RenderTOC()
+
+	for k := 1; k <= 5; k++ {
+		if !strings.Contains(want, `playOrder="`+strconv.Itoa(k)+`"`) {
+			t.Errorf("no playOrder %d:\n%s", k, want)
+		}
+	}
+
+	if books[0].RenderEP3TOC(); books[0].RenderTOC() != want {
+		t.Errorf("play order not restarted:\n%s", books[0].RenderTOC())
+	}
+
+	// books are rendered concurrently in batch mode
+	var wg sync.WaitGroup
+
+	for _, bk := range books {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				bk.RenderEP3TOC()
+				if got := bk.RenderTOC(); got != want {
+					t.Errorf("table of contents changed:\n%s", got)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,127 @@
+package azrconvert
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// tocPageID is the id of the table of contents page in the body.
+const tocPageID = "azbc_toc"
+
+// maxTOCDepth is the deepest level of the table of contents page. The
+// three levels of headings of Aozora Bunko (大見出し, 中見出し, and
+// 小見出し) do not nest deeper, and vertical.css styles as many.
+const maxTOCDepth = 3
+
+// tocPage returns the table of contents page (目次) listing the
+// sections of b down to b.TOCDepth levels, but no more than
+// maxTOCDepth. The entries link to the sections and have no page
+// numbers since the text reflows.
+func (b *Book) tocPage() []*html.Token {
+
+	depth := min(b.TOCDepth, maxTOCDepth)
+
+	w := new(strings.Builder)
+
+	w.WriteString(`<div class="azbc_toc" id="` + tocPageID + `" data-AmznPageBreak="always">` + "\n")
+	w.WriteString(`<p class="azbc_toc_title">目次</p>` + "\n")
+
+	n := 0
+
+	for _, sec := range b.sectionList() {
+
+		if sec.depth >= depth || sec.id == "" || strings.TrimSpace(sec.title) == "" {
+			continue
+		}
+
+		n++
+
+		w.WriteString(`<p class="azbc_toc_` + strconv.Itoa(sec.depth+1) + `">`)
+		w.WriteString(`<a href="#` + sec.id + `">` + html.EscapeString(sec.title) + "</a></p>\n")
+	}
+
+	w.WriteString("</div>\n")
+
+	if n == 0 {
+		return nil
+	}
+
+	return tokenize([]byte(w.String()))
+}
+
+// tocPagePosition returns the index in body at which the table of
+// contents page goes: after the block with title and author if there
+// is one, at the start of the body otherwise.
+func tocPagePosition(body []*html.Token) int {
+
+	meta, _, _ := landmarkTokens(body)
+
+	for i, t := range body {
+		if t == meta {
+			return i + len(getNode(body[i:]))
+		}
+	}
+
+	return 1
+}
+
+// withTOCPage calls f with the table of contents page inserted into
+// b.Body if b.TOCDepth is positive. b.Body and the sections are
+// restored afterwards.
+func (b *Book) withTOCPage(f func() error) error {
+
+	if b.TOCDepth <= 0 || b.TopSection == nil || len(b.Body) < 2 {
+		return f()
+	}
+
+	page := b.tocPage()
+	if page == nil {
+		return f()
+	}
+
+	body := b.Body
+	at := tocPagePosition(body)
+
+	b.Body = make([]*html.Token, 0, len(body)+len(page))
+	b.Body = append(b.Body, body[:at]...)
+	b.Body = append(b.Body, page...)
+	b.Body = append(b.Body, body[at:]...)
+
+	b.shiftSections(at, len(page))
+
+	defer func() {
+		b.Body = body
+		b.shiftSections(at, -len(page))
+	}()
+
+	return f()
+}
+
+// shiftSections moves the sections of b that start or end at or after
+// index at in b.Body by n tokens.
+func (b *Book) shiftSections(at, n int) {
+
+	for _, sec := range b.sectionList() {
+		if sec.start >= at {
+			sec.start += n
+		}
+		if sec.end >= at {
+			sec.end += n
+		}
+	}
+}
+
+// removeTOCPage removes a table of contents page written by a previous
+// conversion from body, e.g. when reading back a web page package.
+func removeTOCPage(body []*html.Token) []*html.Token {
+
+	for i, t := range body {
+		if isDiv(t) && t.Type == html.StartTagToken && getAttr(t, "id") == tocPageID {
+			return append(body[:i:i], body[i+len(getNode(body[i:])):]...)
+		}
+	}
+
+	return body
+}
@@ -0,0 +1,193 @@
+package azrconvert
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/adamay909/AozoraConvert/epubcheck"
+	"github.com/adamay909/AozoraConvert/mobi"
+	"github.com/adamay909/AozoraConvert/mobi/validate"
+)
+
+func newTOCTestBook(t *testing.T, depth int) *Book {
+
+	bk := NewBook()
+
+	err := bk.getBookFrom(cleanUTF8([]byte(nestedTestPage)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bk.SetMetadataFromPreamble()
+
+	err = bk.GenTitlePage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bk.TOCDepth = depth
+
+	return bk
+}
+
+func TestTOCPageDepth(t *testing.T) {
+
+	tests := []struct {
+		depth      int
+		want, lack []string
+	}{
+		{0, nil, []string{"目次"}},
+		{1, []string{`<a href="#azbc_110">第一部</a>`, `<a href="#azbc_140">第二部</a>`}, []string{`#azbc_120">第一章`}},
+		{2, []string{`<p class="azbc_toc_2"><a href="#azbc_120">第一章</a></p>`, `<a href="#azbc_150">第三章</a>`}, nil},
+	}
+
+	for _, tt := range tests {
+
+		bk := newTOCTestBook(t, tt.depth)
+		n := len(bk.Body)
+
+		buf := new(bytes.Buffer)
+		if err := bk.WriteWebpage(buf); err != nil {
+			t.Fatal(err)
+		}
+		page := buf.String()
+
+		for _, s := range tt.want {
+			if !strings.Contains(page, s) {
+				t.Errorf("depth %d: page lacks %s", tt.depth, s)
+			}
+		}
+		for _, s := range tt.lack {
+			if strings.Contains(page, s) {
+				t.Errorf("depth %d: page contains %s", tt.depth, s)
+			}
+		}
+
+		if tt.depth > 0 && strings.Index(page, "azbc_toc") < strings.Index(page, `class="metadata"`) {
+			t.Errorf("depth %d: table of contents before the title", tt.depth)
+		}
+
+		if len(bk.Body) != n {
+			t.Errorf("depth %d: body not restored", tt.depth)
+		}
+
+		// reading the page back drops the table of contents
+		again := NewBook()
+		if err := again.getBookFrom(buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(renderTokens(again.Body), "目次") {
+			t.Errorf("depth %d: table of contents read back", tt.depth)
+		}
+	}
+}
+
+func TestTOCPageMaxDepth(t *testing.T) {
+
+	// sections nested deeper than headings do
+	bk := NewBook()
+	bk.TOCDepth = 9
+
+	var parent *section
+	for i := 1; i <= 5; i++ {
+		sec := &section{id: "azbc_" + strconv.Itoa(100+i), title: "見出し" + strconv.Itoa(i), parent: parent}
+		if parent == nil {
+			bk.TopSection = sec
+		} else {
+			parent.firstChild = sec
+		}
+		parent = sec
+	}
+
+	page := renderTokens(bk.tocPage())
+
+	if !strings.Contains(page, `<p class="azbc_toc_3"><a href="#azbc_103">見出し3</a></p>`) {
+		t.Errorf("third level missing in %s", page)
+	}
+	if strings.Contains(page, "azbc_toc_4") {
+		t.Errorf("more than %d levels in %s", maxTOCDepth, page)
+	}
+}
+
+func TestTOCPageEpub(t *testing.T) {
+
+	bk := newTOCTestBook(t, 2)
+
+	buf := new(bytes.Buffer)
+
+	err := bk.WriteEpub(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range epubcheck.Check(buf.Bytes()) {
+		t.Error(d)
+	}
+
+	arch, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := arch.Open("OEBPF/toc.xhtml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	nav, _ := io.ReadAll(r)
+
+	if !regexp.MustCompile(`<a epub:type="toc" href="\d+\.html#azbc_toc">目次</a>`).Match(nav) {
+		t.Errorf("no toc landmark in %s", nav)
+	}
+}
+
+var posLink = regexp.MustCompile(`kindle:pos:fid:([0-9A-V]{4}):off:([0-9A-V]{10})">([^<]*)<`)
+
+func TestTOCPageAZW3(t *testing.T) {
+
+	bk := newTOCTestBook(t, 2)
+
+	buf := new(bytes.Buffer)
+
+	err := bk.WriteAZW3(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range validate.Check(buf.Bytes()) {
+		t.Error(d)
+	}
+
+	f, err := mobi.Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	links := posLink.FindAllStringSubmatch(f.Flows[0], -1)
+	if len(links) != 5 {
+		t.Fatalf("%d links in the table of contents", len(links))
+	}
+
+	for _, m := range links {
+		fid, _ := strconv.ParseInt(m[1], 32, 32)
+		frag := f.Fragments[fid]
+		text := string(f.Text[frag.InsertPos : frag.InsertPos+frag.Length])
+		if !strings.HasPrefix(text, "<h") || !strings.Contains(text, m[3]) {
+			t.Errorf("link to %s goes to %.40q", m[3], text)
+		}
+	}
+
+	found := false
+	for _, g := range f.Guide {
+		found = found || g.Type == "toc"
+	}
+	if !found {
+		t.Errorf("no toc in guide %+v", f.Guide)
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	azrconvert "github.com/adamay909/AozoraConvert/azrconvert"
+)
+
+// progressFile records the works converted in batch mode. It is kept
+// in the output directory so that an interrupted batch can be resumed
+// by running the same command again.
+const progressFile = "azrconvert.progress"
+
+type batchResult struct {
+	location string
+	output   string
+	err      error
+	warning  error
+	skipped  bool
+}
+
+// runBatch converts all works listed in source.
+func runBatch(source string) error {
+
+	fetcher, err := newFetcher()
+	if err != nil {
+		return err
+	}
+
+	list, err := batchList(fetcher, source)
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 {
+		return errors.New(source + ": no works found.")
+	}
+
+	err = os.MkdirAll(outdir, 0755)
+	if err != nil {
+		return err
+	}
+
+	progress, err := openProgress(filepath.Join(outdir, progressFile))
+	if err != nil {
+		return err
+	}
+	defer progress.Close()
+
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	names := &outputNames{used: make(map[string]bool)}
+
+	todo := make(chan string)
+	results := make(chan batchResult)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for l := range todo {
+				results <- convertItem(fetcher, l, names)
+			}
+		}()
+	}
+
+	go func() {
+		for _, l := range list {
+			if progress.done[l] {
+				results <- batchResult{location: l, skipped: true}
+				continue
+			}
+			todo <- l
+		}
+		close(todo)
+		wg.Wait()
+		close(results)
+	}()
+
+	var failed, warned []batchResult
+	var converted, skipped, n int
+
+	for r := range results {
+
+		n++
+
+		switch {
+
+		case r.skipped:
+			skipped++
+			printmessage(fmt.Sprintf("[%d/%d] %s: already converted", n, len(list), r.location))
+			continue
+
+		case r.err != nil:
+			failed = append(failed, r)
+			printmessage(fmt.Sprintf("[%d/%d] %s: %v", n, len(list), r.location, r.err))
+			continue
+
+		case r.warning != nil:
+			warned = append(warned, r)
+		}
+
+		converted++
+
+		printmessage(fmt.Sprintf("[%d/%d] %s: %s", n, len(list), r.location, r.output))
+
+		err = progress.add(r.location)
+		if err != nil {
+			printmessage(err)
+		}
+	}
+
+	printmessage("")
+	printmessage(fmt.Sprintf("%d works: %d converted, %d already converted, %d failed.", len(list), converted, skipped, len(failed)))
+
+	if len(warned) > 0 {
+		printmessage("")
+		printmessage("Converted with warnings:")
+		for _, r := range warned {
+			printmessage("  " + r.location + ": " + r.warning.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		printmessage("")
+		printmessage("Failed:")
+		for _, r := range failed {
+			printmessage("  " + r.location + ": " + r.err.Error())
+		}
+		return fmt.Errorf("%d of %d works could not be converted.", len(failed), len(list))
+	}
+
+	return nil
+}
+
+// convertItem converts the work at location, which is either the URL of
+// a text or of a work card.
+func convertItem(fetcher azrconvert.Fetcher, location string, names *outputNames) (r batchResult) {
+
+	r.location = location
+
+	b, err := getbookFromURL(fetcher, location)
+
+	if err != nil && !errors.Is(err, azrconvert.ErrImageDownload) {
+		r.err = err
+		return
+	}
+
+	r.warning = err
+
+	r.output = names.get(b, b.URI)
+
+	r.err = writeBook(b, filepath.Join(outdir, r.output))
+
+	return
+}
+
+// batchList returns the works listed in source. source is either the
+// URL of an author page or work card, or a file containing one URL per
+// line. Empty lines and lines starting with # are ignored. Author pages
+// are replaced by the work cards they link to.
+func batchList(fetcher azrconvert.Fetcher, source string) (list []string, err error) {
+
+	var lines []string
+
+	if isURL(source) {
+		lines = []string{source}
+	} else {
+		data, err := os.ReadFile(source)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, l := range strings.Split(string(data), "\n") {
+			l = strings.TrimSpace(l)
+			if l == "" || strings.HasPrefix(l, "#") {
+				continue
+			}
+			lines = append(lines, l)
+		}
+	}
+
+	seen := make(map[string]bool)
+
+	for _, l := range lines {
+
+		works := []string{l}
+
+		if azrconvert.IsAuthorPage(l) {
+
+			page, err := fetcher.Fetch(l)
+			if err != nil {
+				return nil, err
+			}
+
+			works, err = azrconvert.WorksOf(page, l)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, w := range works {
+			if !seen[w] {
+				seen[w] = true
+				list = append(list, w)
+			}
+		}
+	}
+
+	return list, nil
+}
+
+func isURL(s string) bool {
+
+	u, err := url.Parse(s)
+
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// outputNames hands out output file names so that works with the same
+// title do not overwrite each other.
+type outputNames struct {
+	mu   sync.Mutex
+	used map[string]bool
+}
+
+func (n *outputNames) get(b *azrconvert.Book, location string) string {
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	base := fileName(strings.TrimSuffix(path.Base(location), path.Ext(location)))
+	if base == "" {
+		base = "output"
+	}
+
+	name := base
+	if t := fileName(b.Title); t != "" {
+		name = t
+	}
+
+	if n.used[name] {
+		name = name + "_" + base
+	}
+
+	n.used[name] = true
+
+	return name
+}
+
+// fileName makes title usable as the name of a file in the output
+// directory. Path separators, control characters, and the characters
+// Windows does not allow in file names become _, and dots and spaces
+// are dropped at both ends so that the name is neither hidden nor
+// refers to a directory.
+func fileName(title string) string {
+
+	name := strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, title)
+
+	return strings.Trim(name, ". ")
+}
+
+// progress keeps track of the works that have been converted. done
+// holds those converted by earlier runs and is not changed by add, so
+// that it can be read while works are added.
+type progress struct {
+	*os.File
+	done map[string]bool
+}
+
+func openProgress(name string) (*progress, error) {
+
+	p := &progress{done: make(map[string]bool)}
+
+	data, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		p.done[strings.TrimSpace(s.Text())] = true
+	}
+
+	p.File, err = os.OpenFile(name, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *progress) add(location string) error {
+
+	_, err := p.WriteString(location + "\n")
+
+	return err
+}
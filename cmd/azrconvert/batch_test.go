@@ -0,0 +1,131 @@
+package main
+
+import (
+	ziparch "archive/zip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/adamay909/AozoraConvert/azrconvert"
+)
+
+// main parses the flags in init, which runs before the test flags
+// would otherwise be defined.
+var _ = func() bool {
+	testing.Init()
+	return true
+}()
+
+func TestRunBatch(t *testing.T) {
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		n := strings.TrimSuffix(filepath.Base(r.URL.Path), ".txt")
+
+		fmt.Fprintf(w, "作品%s\n作者\n\n", n)
+
+		for i := 1; i <= 5; i++ {
+			fmt.Fprintf(w, "［＃大見出し］第%d章［＃大見出し終わり］\n本文。\n［＃改ページ］\n", i)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+
+	var list strings.Builder
+	for i := 0; i < 8; i++ {
+		fmt.Fprintf(&list, "%s/cards/000001/files/%d.txt\n", srv.URL, i)
+	}
+
+	source := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(source, []byte(list.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	outdir, jobs, epub, kindle, reproducible = filepath.Join(dir, "out"), 4, true, true, true
+	defer func() { outdir, jobs, epub, kindle, reproducible = ".", 4, false, false, false }()
+
+	if err := runBatch(source); err != nil {
+		t.Fatal(err)
+	}
+
+	chapter := regexp.MustCompile(`>第(\d)章<`)
+
+	for i := 0; i < 8; i++ {
+
+		name := filepath.Join(outdir, "作品"+strconv.Itoa(i))
+
+		if _, err := os.Stat(name + ".azw3"); err != nil {
+			t.Error(err)
+		}
+
+		arch, err := ziparch.OpenReader(name + ".epub")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := arch.Open("OEBPF/toc.xhtml")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		toc, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r.Close()
+		arch.Close()
+
+		found := chapter.FindAllStringSubmatch(string(toc), -1)
+		if len(found) != 5 {
+			t.Fatalf("work %d: %d chapters in table of contents:\n%s", i, len(found), toc)
+		}
+
+		for k, m := range found {
+			if m[1] != strconv.Itoa(k+1) {
+				t.Errorf("work %d: entry %d is chapter %s", i, k+1, m[1])
+			}
+		}
+	}
+}
+
+func TestOutputNames(t *testing.T) {
+
+	names := &outputNames{used: make(map[string]bool)}
+
+	tests := []struct {
+		title, location, want string
+	}{
+		{"羅生門", "https://www.aozora.gr.jp/cards/000879/files/127_15260.html", "羅生門"},
+		{"羅生門", "https://www.aozora.gr.jp/cards/000879/files/128_15261.html", "羅生門_128_15261"},
+		{"上/下", "https://example.com/1.html", "上_下"},
+		{"../../etc/passwd", "https://example.com/2.html", "_.._etc_passwd"},
+		{".hidden", "https://example.com/3.html", "hidden"},
+		{`a\b:c*d?e"f<g>h|i` + "\t", "https://example.com/4.html", "a_b_c_d_e_f_g_h_i_"},
+		{"...", "https://example.com/5.html", "5"},
+		{"", "https://example.com/../", "output"},
+	}
+
+	for _, tt := range tests {
+
+		b := azrconvert.NewBook()
+		b.Title = tt.title
+
+		got := names.get(b, tt.location)
+		if got != tt.want {
+			t.Errorf("%q from %s: got %q, want %q", tt.title, tt.location, got, tt.want)
+		}
+
+		if filepath.Base(got) != got {
+			t.Errorf("%q: %q is a path", tt.title, got)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg" // for reading background images
+	_ "image/png"  // for reading background images
+	"os"
+	"strconv"
+	"strings"
+
+	azrconvert "github.com/adamay909/AozoraConvert/azrconvert"
+	"golang.org/x/image/colornames"
+)
+
+var coverSize, coverColors, coverFont, coverLayout, coverAuthor, coverPublisher, coverImage string
+
+var coverRuby bool
+
+// coverOptions returns the options for the cover given by the flags.
+// It returns nil if none of the flags is given so that the default
+// cover made when reading the book is kept.
+func coverOptions() (*azrconvert.CoverOptions, error) {
+
+	if coverSize == "" && coverColors == "" && coverFont == "" && coverLayout == "" && coverAuthor == "" && coverPublisher == "" && coverImage == "" && !coverRuby {
+		return nil, nil
+	}
+
+	opts := new(azrconvert.CoverOptions)
+
+	var err error
+
+	if coverSize != "" {
+		w, h, ok := strings.Cut(coverSize, "x")
+		opts.Width, err = strconv.Atoi(w)
+		if err == nil {
+			opts.Height, err = strconv.Atoi(h)
+		}
+		if !ok || err != nil || opts.Width <= 0 || opts.Height <= 0 {
+			return nil, fmt.Errorf("cover size %q is not of the form WIDTHxHEIGHT", coverSize)
+		}
+	}
+
+	if coverColors != "" {
+		opts.Palette, err = parsePalette(coverColors)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if coverFont != "" {
+		files := strings.Split(coverFont, ",")
+		opts.Font, err = os.ReadFile(files[0])
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range files[1:] {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				return nil, err
+			}
+			opts.FallbackFonts = append(opts.FallbackFonts, data)
+		}
+	}
+
+	opts.Ruby = coverRuby
+
+	switch coverLayout {
+	case "", "classic":
+		opts.Layout = azrconvert.CoverClassic
+	case "centered":
+		opts.Layout = azrconvert.CoverCentered
+	case "vertical":
+		opts.Layout = azrconvert.CoverVertical
+	default:
+		return nil, fmt.Errorf("unknown cover layout %q", coverLayout)
+	}
+
+	opts.Author, err = parsePlacement(coverAuthor)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Publisher, err = parsePlacement(coverPublisher)
+	if err != nil {
+		return nil, err
+	}
+
+	if coverImage != "" {
+		f, err := os.Open(coverImage)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		opts.BackgroundImage, _, err = image.Decode(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", coverImage, err)
+		}
+	}
+
+	return opts, nil
+}
+
+func parsePlacement(s string) (azrconvert.Placement, error) {
+
+	switch s {
+	case "":
+		return azrconvert.PlaceDefault, nil
+	case "top":
+		return azrconvert.PlaceTop, nil
+	case "bottom":
+		return azrconvert.PlaceBottom, nil
+	case "none":
+		return azrconvert.PlaceHidden, nil
+	}
+
+	return 0, fmt.Errorf("unknown placement %q, use top, bottom, or none", s)
+}
+
+// parsePalette parses colour pairs of the form background,foreground
+// separated by semicolons.
+func parsePalette(s string) ([]azrconvert.ColorPair, error) {
+
+	var palette []azrconvert.ColorPair
+
+	for _, pair := range strings.Split(s, ";") {
+
+		bg, fg, ok := strings.Cut(pair, ",")
+		if !ok {
+			return nil, fmt.Errorf("colours %q are not of the form background,foreground", pair)
+		}
+
+		b, err := parseColor(bg)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := parseColor(fg)
+		if err != nil {
+			return nil, err
+		}
+
+		palette = append(palette, azrconvert.ColorPair{Background: b, Foreground: f})
+	}
+
+	return palette, nil
+}
+
+// parseColor parses a colour given as #rrggbb or by its SVG name.
+func parseColor(s string) (color.Color, error) {
+
+	s = strings.ToLower(strings.TrimSpace(s))
+
+	if c, ok := colornames.Map[s]; ok {
+		return c, nil
+	}
+
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok || len(hex) != 6 {
+		return nil, errors.New("unknown colour " + strconv.Quote(s))
+	}
+
+	n, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, errors.New("unknown colour " + strconv.Quote(s))
+	}
+
+	return color.RGBA{uint8(n >> 16), uint8(n >> 8), uint8(n), 255}, nil
+}
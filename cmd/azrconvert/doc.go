@@ -11,6 +11,31 @@ The text to be converted is given by the URL of the xhtml text on Aozora Bunko's
 	-kindle
 			Produces an azw3 file suitable for Kindle e-readers.
 
+	-joint
+			Together with -kindle, adds a MOBI 6 version of the
+			book to the azw3 file for older Kindle devices and
+			apps that cannot read azw3 files. The MOBI 6 version
+			is shown horizontally and without styling, and ruby
+			is shown in parentheses after the text.
+
+	-toc depth
+			Inserts a table of contents page (目次) after the
+			title and author in epub, azw3, and web output.
+			It lists the headings down to depth levels, e.g.
+			-toc 1 lists only the largest headings.
+
+	-gaiji methods
+			Writes gaiji, characters given by a note such as
+			※［＃「さんずい＋督」、第3水準1-87-3］, by trying
+			the comma-separated methods in the order given:
+			unicode (a single character), sequence (a character
+			with a variation selector or combining characters),
+			image (the image of Aozora Bunko, 1em large), and
+			description (〔さんずい＋督〕). Gaiji none of the
+			methods works for are left as they are. The default
+			is unicode,sequence,image,description. Gaiji not
+			written as Unicode are reported as warnings.
+
 	-web
 			Produces a zip file containing an html file and
 			all files necessary to display the page as
@@ -51,7 +76,130 @@ E.g.,
 
 You can in any case see the output file name on the command line.
 
-It is possible to convert a local file by using the flag -i followed by the file name. Note, however, that this will fail to download any needed graphics files as the xhtml texts from Aozoroa Bunko only have relative paths so the converter will not know where to find them.
+Instead of the xhtml text, you can also give the URL of the work card (図書カード) of the book, e.g.
+
+	$ azrconvert -epub https://www.aozora.gr.jp/cards/000879/card55.html
+
+The text linked from the card is converted, and the information on the card that is not part of the text (readings of the title and author name, 底本, 初出, 分類, 文字遣い種別, and the people who input and proofread the text) is added to the metadata of the output.
+
+The readings of title, author, and publisher are used by e-readers for sorting books. Readings not given on the card (or when converting the text directly) can be specified with
+
+	-title-reading
+		Reading of the title.
+
+	-author-reading
+		Reading of the name of the author.
+
+	-publisher-reading
+		Reading of the publisher. The reading of 青空文庫
+		is known.
+
+You can also give the URL of the zip archive containing the plain text version of the book (the one with ruby given as 《》 and annotations given as ［＃…］), or the URL of the plain text file itself.
+
+It is possible to convert a local file by using the flag -i followed by the file name. Files ending in .txt are treated as plain text files from Aozora Bunko, and files ending in .zip can be either zip archives downloaded from Aozora Bunko or the output of -web. Graphics files are looked for relative to the directory containing the local file (or inside the zip archive), so you should save the illustrations next to the text.
+
+If you have a local mirror of Aozora Bunko, you can avoid downloading graphics files with
+
+	-mirror
+		Directory containing the mirror, i.e. the directory
+		containing the cards and gaiji directories.
+
+The text is still taken from the URL but the graphics files are taken from the mirror.
+
+To avoid downloading the same files every time a book is converted, downloaded files can be kept in a cache:
+
+	-cache
+		Directory for the cache. Files in the cache are only
+		downloaded again if they have changed on the server.
+
+	-offline
+		Only use files from the cache and never access the
+		network.
+
+	-refresh
+		Download all files again and update the cache.
+
+If -offline or -refresh is given without -cache, the directory azrconvert inside the user's cache directory (e.g. ~/.cache/azrconvert) is used.
+
+The cover made from title, author, and publisher can be changed with
+
+	-cover-layout
+		classic (the default: title in the middle, author at
+		the top left, publisher at the bottom right),
+		centered, or vertical (title and author written
+		vertically).
+
+	-cover-colors
+		Background and text colour, e.g. "#f5ecdc,#493b22"
+		or "navy,white". Several pairs separated by ; are
+		chosen from by the text of the book.
+
+	-cover-size
+		Size in pixels, e.g. 1600x2560.
+
+	-cover-font
+		OpenType or TrueType font file to use. Further files
+		separated by commas are used in turn for characters
+		missing from the first, e.g. "mincho.otf,gaiji.ttf".
+
+	-cover-author, -cover-publisher
+		Where to put the author and the publisher: top,
+		bottom, or none.
+
+	-cover-image
+		PNG or JPEG image to use as background.
+
+	-cover-ruby
+		Write the reading of the title as ruby (furigana)
+		over it instead of on a line of its own.
+
+Giving the same options for all books of a series gives them a consistent look.
+
+Normally, each conversion gives the book a new identifier and the current time as modification date, so converting the same text twice yields different files. With
+
+	-reproducible
+		Produce the same output every time the same text
+		is converted.
+
+the identifier is derived from the URL of the text (or from its contents for local files), and all dates inside the output are taken from the environment variable SOURCE_DATE_EPOCH (seconds since 1970-01-01 UTC) if it is set, and otherwise from the date the text was last revised or published on Aozora Bunko. This allows you to check whether a book has changed by comparing the output files, and to replace books on e-readers without getting duplicates.
+
+Files written with -epub and -kindle can be checked with
+
+	-validate
+		Name of an epub or azw3 file to check instead of
+		converting anything.
+
+For epub files, the container, the package document, the well-formedness of all XHTML files, the links between them, and the table of contents are checked against the EPUB 3 specification. For azw3 files, the structure of the file (headers, text records, indices, and images) is checked against what Kindle devices expect. The problems found are printed. The exit status is 1 if there are errors. Warnings are about things that are unusual but do not keep the book from being read. E.g.
+
+	$ azrconvert -validate 芋粥.azw3
+
+	芋粥.azw3 is valid (0 warnings).
+
+# Batch conversion
+
+Many works can be converted at once with
+
+	-batch
+		A file containing one URL per line, or the URL of an
+		author page (person*.html) or work card (card*.html).
+
+	-j
+		Number of works converted at the same time
+		(default 4).
+
+	-d
+		Directory the output is written to (default the
+		current directory).
+
+The URLs in the file can be URLs of texts, of work cards, or of author pages. For work cards, the XHTML text linked from the card is converted (or the plain text version if there is no XHTML version), and author pages stand for all works listed on them. Empty lines and lines starting with # are ignored. E.g., to convert all works by Akutagawa Ryunosuke:
+
+	$ azrconvert -epub -cache cache -d akutagawa https://www.aozora.gr.jp/index_pages/person879.html
+
+Works that cannot be converted are reported and the remaining works are converted anyway. At the end, a summary of the conversion is printed.
+
+The works that have been converted are recorded in the file azrconvert.progress in the output directory. If a batch is interrupted or some works fail, running the same command again converts only the remaining works. Delete azrconvert.progress to convert everything again.
+
+If several works have the same title, the base name of the text is appended to the output file name. The flag -o is ignored in batch mode.
 
 # Notes
 
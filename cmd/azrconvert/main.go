@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
@@ -16,9 +15,15 @@ import (
 )
 
 var (
-	web, zip, epub, epub3, kindle, azw3, mono, verbose bool
+	web, zip, epub, epub3, kindle, azw3, mono, verbose, offline, refresh, reproducible, joint bool
 
-	infile, outfile string
+	infile, outfile, mirror, cachedir, batch, outdir, validateFile, gaiji string
+
+	titleReading, creatorReading, publisherReading string
+
+	jobs, tocDepth int
+
+	cover *azrconvert.CoverOptions
 
 	logfile *os.File
 )
@@ -39,12 +44,58 @@ func init() {
 
 	flag.BoolVar(&azw3, "azw3", false, "Alias for kindle.")
 
+	flag.BoolVar(&joint, "joint", false, "Add a MOBI 6 version to azw3 output for older Kindle devices.")
+
+	flag.IntVar(&tocDepth, "toc", 0, "Insert a table of contents page listing headings down to `depth` levels, at most 3. 0 means no such page.")
+
+	flag.StringVar(&gaiji, "gaiji", "", "Write gaiji by trying the comma-separated `methods` unicode, sequence, image, and description in the order given.")
+
 	flag.BoolVar(&verbose, "v", false, "Enable verbose logging to screen and to  azrconvert.log.")
 
 	flag.StringVar(&outfile, "o", "", "Name output  as `name` + extension. Defaults to title of document plus appropriate extension.")
 
 	flag.StringVar(&infile, "i", "", "Convert local `file`.")
 
+	flag.StringVar(&mirror, "mirror", "", "Get images from local mirror `directory` of Aozora Bunko instead of downloading them.")
+
+	flag.StringVar(&cachedir, "cache", "", "Keep downloaded files in `directory` and only download them again if they have changed.")
+
+	flag.BoolVar(&offline, "offline", false, "Only use files from the cache. Never access the network.")
+
+	flag.BoolVar(&refresh, "refresh", false, "Download all files again and update the cache.")
+
+	flag.StringVar(&batch, "batch", "", "Convert all works listed in `source`, which is a file of URLs or the URL of an author page or work card.")
+
+	flag.IntVar(&jobs, "j", 4, "Convert up to `n` works at the same time in batch mode.")
+
+	flag.StringVar(&outdir, "d", ".", "Write output to `directory`.")
+
+	flag.StringVar(&validateFile, "validate", "", "Check the structure of the epub or azw3 `file` and report problems instead of converting.")
+
+	flag.BoolVar(&reproducible, "reproducible", false, "Produce identical output every time the same text is converted. Dates are taken from SOURCE_DATE_EPOCH if set.")
+
+	flag.StringVar(&titleReading, "title-reading", "", "Use `reading` as the reading of the title.")
+
+	flag.StringVar(&creatorReading, "author-reading", "", "Use `reading` as the reading of the name of the author.")
+
+	flag.StringVar(&publisherReading, "publisher-reading", "", "Use `reading` as the reading of the publisher.")
+
+	flag.StringVar(&coverSize, "cover-size", "", "Make the cover `WIDTHxHEIGHT` pixels large instead of 1200x1600.")
+
+	flag.StringVar(&coverColors, "cover-colors", "", "Use the `colours` background,foreground for the cover. Several pairs separated by ; are chosen from by the text.")
+
+	flag.StringVar(&coverFont, "cover-font", "", "Write the cover with the OpenType or TrueType font in `file`. Further files separated by commas are used for characters missing from the first.")
+
+	flag.StringVar(&coverLayout, "cover-layout", "", "Lay out the cover as `layout`: classic, centered, or vertical.")
+
+	flag.StringVar(&coverAuthor, "cover-author", "", "Put the author at the top, at the bottom, or none of the cover (`place`).")
+
+	flag.StringVar(&coverPublisher, "cover-publisher", "", "Put the publisher at the top, at the bottom, or none of the cover (`place`).")
+
+	flag.StringVar(&coverImage, "cover-image", "", "Use the PNG or JPEG image in `file` as the background of the cover.")
+
+	flag.BoolVar(&coverRuby, "cover-ruby", false, "Write the reading of the title as ruby over the title on the cover.")
+
 	flag.Parse()
 
 	var err error
@@ -84,9 +135,20 @@ func main() {
 
 	var b *azrconvert.Book
 	var location, filename string
+	var err error
 
 	defer logfile.Close()
 
+	if validateFile != "" {
+		err = runValidate(validateFile)
+		if err != nil {
+			printmessage(err)
+			logfile.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
 	//if flag.Arg(0) != "" {
 	//		location = flag.Arg(0)
 	//	}
@@ -97,57 +159,118 @@ func main() {
 		return
 	}
 
+	cover, err = coverOptions()
+	if err != nil {
+		printmessage(err)
+		logfile.Close()
+		os.Exit(1)
+	}
+
+	if gaiji != "" {
+		azrconvert.DefaultGaijiPolicy, err = azrconvert.ParseGaijiPolicy(gaiji)
+		if err != nil {
+			printmessage(err)
+			logfile.Close()
+			os.Exit(1)
+		}
+	}
+
+	if batch != "" {
+		err = runBatch(batch)
+		if err != nil {
+			printmessage(err)
+			logfile.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
 	if infile == "" {
-		b = getbookFromURL(flag.Arg(0))
+		b, err = getbookFromURL(nil, flag.Arg(0))
 	} else {
-		b = getbookFromLocal(infile)
+		b, err = getbookFromLocal(infile)
+	}
+
+	if err != nil && !errors.Is(err, azrconvert.ErrImageDownload) {
+		printmessage(err)
+		logfile.Close()
+		os.Exit(1)
+	}
+
+	if err != nil {
+		printmessage("Warning: " + err.Error())
 	}
 
 	filename = setOutputName(b, location)
 
-	if web {
+	err = writeBook(b, filepath.Join(outdir, filename))
+	if err != nil {
+		printmessage(err)
+	}
+}
 
-		err := os.WriteFile(filename+".zip", b.RenderWebpagePackage(), 0644)
+// writeBook writes b in all requested formats to files named
+// filename plus the appropriate extension.
+func writeBook(b *azrconvert.Book, filename string) error {
 
-		if err != nil {
-			printmessage(err)
-		}
+	var errs []error
 
-		printmessage("Output written to " + filename + ".zip.")
+	for _, g := range b.UnresolvedGaiji() {
+		printmessage("Warning: gaiji " + g.String() + " in " + b.Title + " is not written as Unicode.")
 	}
 
-	if epub {
+	b.SetReproducible(reproducible)
 
-		err := os.WriteFile(filename+".epub", b.RenderEpub(), 0644)
+	b.JointMOBI = joint
 
-		if err != nil {
-			printmessage(err)
-		}
+	b.TOCDepth = tocDepth
 
-		printmessage("Output written to " + filename + ".epub.")
+	if cover != nil {
+		errs = append(errs, b.GenCover(*cover))
 	}
 
-	if kindle {
-
-		err := os.WriteFile(filename+".azw3", b.RenderAZW3(), 0644)
+	if web {
+		errs = append(errs, writeOutput(filename+".zip", b.WriteWebpagePackage))
+	}
 
-		if err != nil {
-			printmessage(err)
-		}
+	if epub {
+		errs = append(errs, writeOutput(filename+".epub", b.WriteEpub))
+	}
 
-		printmessage("Output written to " + filename + ".azw3.")
+	if kindle {
+		errs = append(errs, writeOutput(filename+".azw3", b.WriteAZW3))
 	}
 
 	if mono {
+		errs = append(errs, writeOutput(filename+".html", b.WriteMonolithicHTML))
+	}
+
+	return errors.Join(errs...)
+}
 
-		err := os.WriteFile(filename+".html", b.RenderMonolithicHTML(), 0644)
+// writeOutput writes the output of write to the file name.
+// Incomplete files are removed.
+func writeOutput(name string, write func(io.Writer) error) error {
 
-		if err != nil {
-			printmessage(err)
-		}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
 
-		printmessage("Output written to " + filename + ".html")
+	err = write(f)
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
 	}
+
+	if err != nil {
+		os.Remove(name)
+		return err
+	}
+
+	printmessage("Output written to " + name + ".")
+
+	return nil
 }
 
 func printmessage[Q any](m Q) {
@@ -162,65 +285,203 @@ func printmessage[Q any](m Q) {
 
 }
 
-func getbookFromLocal(path string) (b *azrconvert.Book) {
+func getbookFromLocal(path string) (b *azrconvert.Book, err error) {
 
 	data, err := os.ReadFile(path)
 
 	if err != nil {
-		printmessage(err)
-		logfile.Close()
-		os.Exit(1)
+		return nil, err
+	}
+
+	switch filepath.Ext(path) {
+	case `.zip`:
+		b, err = azrconvert.NewBookFromZip(data)
+	case `.txt`:
+		b = newLocalBook(path)
+		err = b.GetBookFromText(data)
+	default:
+		b = newLocalBook(path)
+		err = b.GetBookFrom(data)
 	}
 
+	if err != nil && !errors.Is(err, azrconvert.ErrImageDownload) {
+		return nil, err
+	}
+
+	// NewBookFromZip has done the metadata and title page already
 	if filepath.Ext(path) == `.zip` {
-		b = azrconvert.NewBookFromZip(data)
-		return
+		setReadings(b)
+		return b, err
 	}
-	log.Println("Converting from local files won't download any external graphics.")
-	b = azrconvert.NewBookFrom(data)
+
 	b.SetMetadataFromPreamble()
-	b.GenTitlePage()
-	return
 
+	setReadings(b)
+
+	return b, errors.Join(err, b.GenTitlePage())
+
+}
+
+// newLocalBook returns a new Book for the local file path whose
+// images are looked for next to path.
+func newLocalBook(path string) *azrconvert.Book {
+
+	b := azrconvert.NewBook()
+
+	b.SetURI(filepath.Base(path))
+
+	b.SetFetcher(azrconvert.DirFetcher{Root: filepath.Dir(path)})
+
+	return b
+}
+
+// setReadings sets the readings given on the command line. They are
+// ignored in batch mode.
+func setReadings(b *azrconvert.Book) {
+
+	if batch != "" {
+		return
+	}
+
+	if titleReading != "" {
+		b.SetTitleReading(titleReading)
+	}
+
+	if creatorReading != "" {
+		b.SetCreatorReading(creatorReading)
+	}
+
+	if publisherReading != "" {
+		b.SetPublisherReading(publisherReading)
+	}
 }
 
-func getbookFromURL(location string) *azrconvert.Book {
+// getbookFromURL downloads the book at location using fetcher. If
+// fetcher is nil, the Fetcher given by the flags is used. location
+// can also be the URL of a work card, in which case the text linked
+// from the card is converted using the metadata on the card.
+func getbookFromURL(fetcher azrconvert.Fetcher, location string) (b *azrconvert.Book, err error) {
 
 	log.Println("Converting book at " + location)
 
 	if location == "" {
-		printmessage("Please specify URL of Aozora Bunko book you want to convert.")
+		return nil, errors.New("Please specify URL of Aozora Bunko book you want to convert.")
+	}
 
+	if fetcher == nil {
+		fetcher, err = newFetcher()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var card *azrconvert.Card
+
+	if azrconvert.IsCard(location) {
+
+		page, err := fetcher.Fetch(location)
+		if err != nil {
+			return nil, err
+		}
+
+		card, err = azrconvert.ParseCard(page, location)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", location, err)
+		}
+
+		location = card.Text
+
+		log.Println("Text of card is at " + location)
 	}
 
 	path, err := url.Parse(location)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	r, err := http.Get(path.String())
-
+	data, err := fetcher.Fetch(path.String())
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	switch filepath.Ext(path.Path) {
+	case `.zip`:
+		b, err = azrconvert.NewBookFromZip(data)
+	case `.txt`:
+		b = newBookAt(fetcher, location)
+		err = b.GetBookFromText(data)
+	default:
+		b = newBookAt(fetcher, location)
+		err = b.GetBookFrom(data)
+	}
+
+	if err != nil && !errors.Is(err, azrconvert.ErrImageDownload) {
+		return nil, err
+	}
+
+	b.SetURI(location)
+
+	// NewBookFromZip has done the metadata and title page already
+	zipped := filepath.Ext(path.Path) == `.zip`
+
+	if !zipped {
+		b.SetMetadataFromPreamble()
 	}
 
-	if r.StatusCode != 200 {
-		panic(errors.New(r.Status))
+	if card != nil {
+		b.SetMetadataFromCard(card)
 	}
 
-	data, _ := io.ReadAll(r.Body)
+	setReadings(b)
+
+	if zipped && card == nil {
+		return b, err
+	}
+
+	return b, errors.Join(err, b.GenTitlePage())
+}
+
+// newBookAt returns a new Book for the text at location whose
+// images are retrieved using fetcher or the mirror.
+func newBookAt(fetcher azrconvert.Fetcher, location string) *azrconvert.Book {
 
 	b := azrconvert.NewBook()
 
 	b.SetURI(location)
 
-	b.GetBookFrom(data)
+	b.SetFetcher(fetcher)
+
+	if mirror != "" {
+		b.SetFetcher(azrconvert.DirFetcher{Root: mirror})
+	}
 
-	b.SetMetadataFromPreamble()
-	b.GenTitlePage()
 	return b
 }
 
+// newFetcher returns the Fetcher used for downloading files. A cache
+// is used if any of -cache, -offline, or -refresh is given.
+func newFetcher() (azrconvert.Fetcher, error) {
+
+	if cachedir == "" && !offline && !refresh {
+		return azrconvert.HTTPFetcher{}, nil
+	}
+
+	if offline && refresh {
+		return nil, errors.New("-offline and -refresh cannot be used together.")
+	}
+
+	mode := azrconvert.CacheRevalidate
+
+	switch {
+	case offline:
+		mode = azrconvert.CacheOffline
+	case refresh:
+		mode = azrconvert.CacheRefresh
+	}
+
+	return azrconvert.NewCache(cachedir, mode)
+}
+
 func setOutputName(b *azrconvert.Book, location string) (filename string) {
 
 	if location == "" {
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/adamay909/AozoraConvert/epubcheck"
+	"github.com/adamay909/AozoraConvert/mobi/validate"
+)
+
+// runValidate checks the structure of the epub or azw3 file name and
+// prints the problems found.
+func runValidate(name string) error {
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	var problems []fmt.Stringer
+	var invalid bool
+
+	switch strings.ToLower(filepath.Ext(name)) {
+
+	case ".epub":
+		ds := epubcheck.Check(data)
+		for _, d := range ds {
+			problems = append(problems, d)
+		}
+		invalid = epubcheck.HasErrors(ds)
+
+	default:
+		ds := validate.Check(data)
+		for _, d := range ds {
+			problems = append(problems, d)
+		}
+		invalid = validate.HasErrors(ds)
+	}
+
+	for _, p := range problems {
+		printmessage(p)
+	}
+
+	if invalid {
+		return errors.New(name + " is not valid.")
+	}
+
+	printmessage(fmt.Sprintf("%s is valid (%d warnings).", name, len(problems)))
+
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 	"log"
 	"strings"
 
+	"golang.org/x/image/draw"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
 	"golang.org/x/image/math/fixed"
@@ -207,69 +208,41 @@ func AddCenteredText(s string, f font.Face, fg color.Color, canvas *Canvas) {
 
 // BreakLines breaks s into one or more lines to make sure each
 // part fits within the margins of canvas. It also returns the
-// expected maximal line height.
+// expected maximal line height. Lines are broken as by LayoutLines.
 func BreakLines(s string, f font.Face, canvas *Canvas) (lines []string, lineHeight float64) {
 
-	canvas.drawer.Face = f
-	bounds, _ := canvas.drawer.BoundString(s)
-
-	xmin := bounds.Min.X.Round()
-	xmax := bounds.Max.X.Round()
-	textwidth := xmax - xmin
-
-	ymin := bounds.Min.Y.Round()
-	ymax := bounds.Max.Y.Round()
-	lineHeight = float64(ymax-ymin) / float64(canvas.maxYPr)
-
-	divisor := textwidth/canvas.maxXPr + 1
-
-	sRunes := []rune(s)
-	strlen := len(sRunes) / divisor
-
-	var line []rune
-
-	for i := 0; i < len(sRunes); i++ {
-		line = append(line, sRunes[i])
-		if len(line) == strlen {
-			lines = append(lines, string(line))
-			line = nil
-		}
+	for _, l := range LayoutLines([]Segment{{Text: s}}, f, canvas) {
+		lines = append(lines, l.Text)
+		lineHeight = max(lineHeight, l.Height())
 	}
-	lines = append(lines, string(line))
-	//	for ok := kinsoku(lines); !ok; kinsoku(lines) {
-	//	}
+
 	return lines, lineHeight
 }
 
-// ensure compliance with Japanese line breaking rules.
-// returns ok=true if no changes were needed.
-func kinsoku(lines []string) (ok bool) {
+// DrawBackground draws img onto canvas, scaled to cover the whole
+// canvas and centred. Parts sticking out are cut off.
+func DrawBackground(canvas *Canvas, img image.Image) {
 
-	ok = true
+	dst, ok := canvas.drawer.Dst.(draw.Image)
+	if !ok {
+		return
+	}
 
-	for i := range lines {
-		r := []rune(lines[i])
-		last := len(r) - 1
-		if last < 1 {
-			continue
-		}
-		if strings.ContainsAny(string(r[0]), ",)]｝、〕〉》」』】〙〗〟’”｠»ゝゞーァィゥェォッャュョヮヵヶぁぃぅぇぉっゃゅょゎゕゖㇰㇱㇲㇳㇴㇵㇶㇷㇸㇹㇷ゚ㇺㇻㇼㇽㇾㇿ々〻‐゠–〜～?!‼⁇⁈⁉・:;/。.") {
-			if i == 0 {
-				continue
-			}
-			lines[i-1] = lines[i-1] + string(r[0])
-			lines[i] = string(r[1:])
-			ok = false
-		}
-		if strings.ContainsAny(string(r[last]), "([｛〔〈《「『【〘〖〝‘“｟«") {
-			if i == len(lines)-1 {
-				continue
-			}
-			ok = false
-			lines[i+1] = string(r[last]) + lines[i+1]
-			lines[i] = string(r[:last-1])
-		}
+	b := img.Bounds()
+	if b.Empty() {
+		return
 	}
 
-	return
+	// scale so that the image covers the canvas
+	w, h := canvas.maxX, canvas.maxY
+	if b.Dx()*h > b.Dy()*w {
+		w = b.Dx() * h / b.Dy()
+	} else {
+		h = b.Dy() * w / b.Dx()
+	}
+
+	x := (canvas.maxX - w) / 2
+	y := (canvas.maxY - h) / 2
+
+	draw.CatmullRom.Scale(dst, image.Rect(x, y, x+w, y+h), img, b, draw.Over, nil)
 }
@@ -0,0 +1,87 @@
+package drawtext
+
+import (
+	"image"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// FaceChain is a font face made of several faces. Each character is
+// written with the first face that has a glyph for it, so that
+// characters missing from the main font, such as gaiji, are taken
+// from fallback fonts instead of being written as tofu. The metrics
+// are those of the first face.
+type FaceChain []font.Face
+
+// MakeFaceChain makes a chain of faces of size from fonts in the
+// order given, as MakeNewFontFace does for a single font.
+func MakeFaceChain(size int, fonts ...*opentype.Font) FaceChain {
+
+	var chain FaceChain
+
+	for _, f := range fonts {
+		chain = append(chain, MakeNewFontFace(f, size))
+	}
+
+	return chain
+}
+
+// faceFor returns the face of c for writing r.
+func (c FaceChain) faceFor(r rune) font.Face {
+
+	for _, f := range c {
+		if _, ok := f.GlyphAdvance(r); ok {
+			return f
+		}
+	}
+
+	return c[0]
+}
+
+// Close closes all faces of c.
+func (c FaceChain) Close() error {
+
+	var err error
+
+	for _, f := range c {
+		if e := f.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
+
+// Glyph satisfies the font.Face interface.
+func (c FaceChain) Glyph(dot fixed.Point26_6, r rune) (dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, ok bool) {
+	return c.faceFor(r).Glyph(dot, r)
+}
+
+// GlyphBounds satisfies the font.Face interface.
+func (c FaceChain) GlyphBounds(r rune) (bounds fixed.Rectangle26_6, advance fixed.Int26_6, ok bool) {
+	return c.faceFor(r).GlyphBounds(r)
+}
+
+// GlyphAdvance satisfies the font.Face interface.
+func (c FaceChain) GlyphAdvance(r rune) (advance fixed.Int26_6, ok bool) {
+	return c.faceFor(r).GlyphAdvance(r)
+}
+
+// Kern satisfies the font.Face interface. Characters from different
+// faces are not kerned.
+func (c FaceChain) Kern(r0, r1 rune) fixed.Int26_6 {
+
+	f := c.faceFor(r0)
+	if f != c.faceFor(r1) {
+		return 0
+	}
+
+	return f.Kern(r0, r1)
+}
+
+// Metrics satisfies the font.Face interface.
+func (c FaceChain) Metrics() font.Metrics {
+	return c[0].Metrics()
+}
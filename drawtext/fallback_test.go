@@ -0,0 +1,38 @@
+package drawtext
+
+import (
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestFaceChain(t *testing.T) {
+
+	// main has digits only, the fallback everything but wider
+	main := *basicfont.Face7x13
+	main.Ranges = []basicfont.Range{{Low: '0', High: '9' + 1, Offset: 16}}
+
+	fallback := *basicfont.Face7x13
+	fallback.Advance = 9
+
+	chain := FaceChain{&main, &fallback}
+
+	tests := []struct {
+		r   rune
+		adv int
+	}{
+		{'5', 7},
+		{'a', 9},
+	}
+
+	for _, tt := range tests {
+		if adv, _ := chain.GlyphAdvance(tt.r); adv != fixed.I(tt.adv) {
+			t.Errorf("%c: advance %v, want %d", tt.r, adv, tt.adv)
+		}
+	}
+
+	if chain.Metrics() != main.Metrics() {
+		t.Error("metrics not of the first face")
+	}
+}
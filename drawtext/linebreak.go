@@ -0,0 +1,374 @@
+package drawtext
+
+import (
+	"image/color"
+	"strings"
+	"unicode"
+
+	"github.com/adamay909/AozoraConvert/jptools"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Line breaking follows the rules of JIS X 4051: characters of
+// noLineStart do not start a line, characters of noLineEnd do not end
+// one, and inseparable characters such as ―― or …… and Latin words
+// are kept together. Among the allowed break points, those after
+// punctuation or spaces are preferred, then those between bunsetsu,
+// which are guessed from the change from hiragana to other scripts.
+
+// noLineStart holds the characters that must not start a line and
+// noLineEnd those that must not end one (kinsoku shori).
+const (
+	noLineStart = "" +
+		// closing brackets
+		",)]}）］｝、〕〉》」』】〙〗〟’”｠»" +
+		// small kana and iteration marks
+		"ゝゞーァィゥェォッャュョヮヵヶぁぃぅぇぉっゃゅょゎゕゖㇰㇱㇲㇳㇴㇵㇶㇷㇸㇹㇷ゚ㇺㇻㇼㇽㇾㇿ々〻ヽヾ" +
+		// hyphens, punctuation, and middle dots
+		"‐゠–〜～?!‼⁇⁈⁉？！・:;：；/。.，．"
+	noLineEnd = "([{（［｛〔〈《「『【〘〖〝‘“｟«"
+
+	// closing holds the characters after which lines are best
+	// broken.
+	closing = ",.!?)]}、。，．！？）］｝〕〉》」』】〙〗〟’”｠»"
+)
+
+// Segment is a piece of text to be laid out, a styled run. A segment
+// with Ruby is a ruby group, which is never broken across lines; the
+// ruby is written at half the size of the text above it in horizontal
+// text and to the right of it in vertical text. Segments without Face
+// or Color are written with the face and colour given for the whole
+// text.
+type Segment struct {
+	Text string
+	Ruby string
+
+	Face  font.Face
+	Color color.Color
+}
+
+// Line is a line of text laid out by LayoutLines. Width is relative
+// to the printable width of the canvas and Ascent and Descent to the
+// printable height, as for SetCursor.
+type Line struct {
+	Text     string
+	Segments []Segment
+
+	Width, Ascent, Descent float64
+}
+
+// Height returns the height of l relative to the printable height of
+// the canvas.
+func (l Line) Height() float64 {
+	return l.Ascent + l.Descent
+}
+
+// unit is the smallest piece of text that is not broken: a single
+// character or a ruby group.
+type unit struct {
+	text, ruby string
+	seg        int
+	width      fixed.Int26_6
+}
+
+// break classes ordered by preference
+const (
+	breakAfterPunct = iota
+	breakBunsetsu
+	breakOther
+	breakNever
+)
+
+// units splits segs into units measured with f.
+func units(segs []Segment, f font.Face) []unit {
+
+	var out []unit
+
+	for i, s := range segs {
+
+		face := faceOf(s, f)
+
+		if s.Ruby != "" {
+			w := max(font.MeasureString(face, s.Text), font.MeasureString(face, s.Ruby)/2)
+			out = append(out, unit{s.Text, s.Ruby, i, w})
+			continue
+		}
+
+		for _, r := range s.Text {
+			out = append(out, unit{string(r), "", i, runeAdvance(face, r)})
+		}
+	}
+
+	return out
+}
+
+// faceOf returns the face of s, which is f unless s has its own.
+func faceOf(s Segment, f font.Face) font.Face {
+
+	if s.Face != nil {
+		return s.Face
+	}
+
+	return f
+}
+
+func runeAdvance(f font.Face, r rune) fixed.Int26_6 {
+	adv, _ := f.GlyphAdvance(r)
+	return adv
+}
+
+// breakClass returns how good a break between a and b is.
+func breakClass(a, b unit) int {
+
+	ra, _ := lastRune(a.text)
+	rb, _ := firstRune(b.text)
+
+	switch {
+
+	case strings.ContainsRune(noLineStart, rb), strings.ContainsRune(noLineEnd, ra):
+		return breakNever
+
+	// inseparable characters
+	case ra == rb && strings.ContainsRune("―—…‥", ra):
+		return breakNever
+
+	// Latin words and numbers
+	case isWordRune(ra) && isWordRune(rb):
+		return breakNever
+
+	case unicode.IsSpace(ra), unicode.IsSpace(rb):
+		return breakAfterPunct
+
+	case strings.ContainsRune(closing, ra), strings.ContainsRune(noLineEnd, rb):
+		return breakAfterPunct
+
+	case a.ruby != "" || b.ruby != "":
+		return breakBunsetsu
+
+	case jptools.IsHiragana(ra) && !jptools.IsHiragana(rb):
+		return breakBunsetsu
+	}
+
+	return breakOther
+}
+
+func isWordRune(r rune) bool {
+	return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) ||
+		jptools.CharType(r)&(jptools.LatinF|jptools.ArabNumF) != 0
+}
+
+func firstRune(s string) (rune, bool) {
+	for _, r := range s {
+		return r, true
+	}
+	return 0, false
+}
+
+func lastRune(s string) (rune, bool) {
+	r := []rune(s)
+	if len(r) == 0 {
+		return 0, false
+	}
+	return r[len(r)-1], true
+}
+
+// costs of breaking lines, which are weighed against the square of
+// the relative space left at the end of lines times slackCost
+const (
+	lineCost  = 1000
+	slackCost = 100
+)
+
+var classCost = [...]float64{
+	breakAfterPunct: 0,
+	breakBunsetsu:   10,
+	breakOther:      30,
+}
+
+// breakUnits breaks u into lines of at most width. It returns the
+// index of the first unit of each line after the first.
+//
+// It uses the fewest lines possible and, among the ways of breaking
+// into that many lines, chooses the one with the best break points
+// and with lines of about equal length. Lines are only broken where
+// the rules allow it. Text between two allowed break points that is
+// wider than width is set on a line of its own, which overflows, as
+// are long Latin words.
+func breakUnits(u []unit, width fixed.Int26_6) (breaks []int) {
+
+	if len(u) == 0 {
+		return nil
+	}
+
+	allowed := func(j int) bool {
+		return j == 0 || breakClass(u[j-1], u[j]) != breakNever
+	}
+
+	// cost[i] is the least cost of setting u[:i] and from[i] the
+	// start of the last line of it.
+	cost := make([]float64, len(u)+1)
+	from := make([]int, len(u)+1)
+
+	// pos[i] is the width of u[:i]
+	pos := make([]fixed.Int26_6, len(u)+1)
+	for i, v := range u {
+		pos[i+1] = pos[i] + v.width
+	}
+
+	for i := 1; i <= len(u); i++ {
+
+		cost[i] = -1
+
+		if i < len(u) && !allowed(i) {
+			continue
+		}
+
+		// inner is set once the line u[j:i] could be broken
+		inner := false
+
+		for j := i - 1; j >= 0; j-- {
+
+			a, b := j, i
+			for a < b && isSpace(u[a]) {
+				a++
+			}
+			for b > a && isSpace(u[b-1]) {
+				b--
+			}
+
+			w := pos[b] - pos[a]
+			if isHanging(u[:i], i-1) {
+				w -= u[i-1].width
+			}
+
+			if w > width && inner {
+				break
+			}
+
+			if !allowed(j) || cost[j] < 0 {
+				continue
+			}
+
+			inner = j > 0
+
+			c := cost[j] + lineCost
+			if j > 0 {
+				c += classCost[breakClass(u[j-1], u[j])]
+			}
+			if w < width {
+				slack := float64(width-w) / float64(width)
+				c += slackCost * slack * slack
+			}
+
+			if cost[i] < 0 || c < cost[i] {
+				cost[i], from[i] = c, j
+			}
+		}
+	}
+
+	for i := from[len(u)]; i > 0; i = from[i] {
+		breaks = append([]int{i}, breaks...)
+	}
+
+	return breaks
+}
+
+// isHanging reports whether u[n] may hang beyond the end of the line
+// (burasage).
+func isHanging(u []unit, n int) bool {
+	return n > 0 && strings.Contains(hanging, u[n].text) && !strings.Contains(hanging, u[n-1].text)
+}
+
+func isSpace(v unit) bool {
+	return strings.TrimSpace(v.text) == ""
+}
+
+func trimSpace(u []unit) []unit {
+
+	for len(u) > 0 && isSpace(u[0]) {
+		u = u[1:]
+	}
+	for len(u) > 0 && isSpace(u[len(u)-1]) {
+		u = u[:len(u)-1]
+	}
+
+	return u
+}
+
+func lineWidth(u []unit) (w fixed.Int26_6) {
+	for _, v := range u {
+		w += v.width
+	}
+	return w
+}
+
+// LayoutLines breaks segs into lines that fit within the margins of
+// canvas when written with f. Texts needing several lines are broken
+// into lines of about equal length rather than filling all but the
+// last line.
+func LayoutLines(segs []Segment, f font.Face, canvas *Canvas) []Line {
+
+	u := units(segs, f)
+
+	var lines []Line
+
+	start := 0
+	for _, end := range append(breakUnits(u, fixed.I(canvas.maxXPr)), len(u)) {
+		lines = append(lines, makeLine(u[start:end], segs, f, canvas))
+		start = end
+	}
+
+	return lines
+}
+
+// makeLine makes the line of u, dropping spaces at its ends.
+func makeLine(u []unit, segs []Segment, f font.Face, canvas *Canvas) Line {
+
+	u = trimSpace(u)
+
+	var l Line
+
+	text := new(strings.Builder)
+
+	for i, v := range u {
+
+		text.WriteString(v.text)
+
+		switch {
+		case v.ruby != "":
+			l.Segments = append(l.Segments, segs[v.seg])
+		case i > 0 && u[i-1].seg == v.seg && u[i-1].ruby == "":
+			l.Segments[len(l.Segments)-1].Text += v.text
+		default:
+			s := segs[v.seg]
+			s.Text = v.text
+			l.Segments = append(l.Segments, s)
+		}
+	}
+
+	l.Text = text.String()
+	l.Width = float64(lineWidth(u).Ceil()) / float64(canvas.maxXPr)
+
+	var ascent, descent fixed.Int26_6
+
+	for _, s := range l.Segments {
+
+		face := faceOf(s, f)
+
+		bounds, _ := font.BoundString(face, s.Text)
+
+		a := -bounds.Min.Y
+		if s.Ruby != "" {
+			a += face.Metrics().Height / 2
+		}
+
+		ascent = max(ascent, a)
+		descent = max(descent, bounds.Max.Y)
+	}
+
+	l.Ascent = float64(ascent.Round()) / float64(canvas.maxYPr)
+	l.Descent = float64(descent.Round()) / float64(canvas.maxYPr)
+
+	return l
+}
@@ -0,0 +1,118 @@
+package drawtext
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestBreakLines(t *testing.T) {
+
+	// the printable width holds 11 characters of basicfont
+	canvas := NewCanvas(color.White, 100, 100)
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"羅生門", "羅生門"},
+		{"吾輩は猫である。名前はまだ無い。", "吾輩は猫である。|名前はまだ無い。"},
+		{"あいうえおかきくけこさしすせそ", "あいうえおかきく|けこさしすせそ"},
+		// closing brackets and small kana do not start a line
+		{"「あいうえおかき」くけこさし", "「あいうえおかき」|くけこさし"},
+		{"あいうえおかきっくけこさし", "あいうえおか|きっくけこさし"},
+		// opening brackets do not end a line
+		{"あいうえおか「きくけこさ」", "あいうえおか|「きくけこさ」"},
+		// bunsetsu
+		{"ことばの意味とその歴史について", "ことばの意味とその|歴史について"},
+		{"あいうえおかき漢字くけこ", "あいうえおかき|漢字くけこ"},
+		// Latin words are not broken
+		{"the quick brown fox jumps", "the quick|brown fox|jumps"},
+		// inseparable dashes
+		{"あいうえお――かきくけこ", "あいうえお|――かきくけこ"},
+	}
+
+	for _, tt := range tests {
+
+		lines, h := BreakLines(tt.in, basicfont.Face7x13, canvas)
+
+		if g := strings.Join(lines, "|"); g != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.in, g, tt.want)
+		}
+
+		if h <= 0 {
+			t.Errorf("%s: line height %v", tt.in, h)
+		}
+	}
+}
+
+func TestBreakLinesNarrow(t *testing.T) {
+
+	tests := []string{
+		"吾輩は猫である。名前はまだ無い。",
+		"「」」」」」」」」」」」」」」」」」」」」」」」」",
+		"あ」」」」」」」」」」」」」」」」」」」」」」」い",
+		"あっっっっっっっっっっっっっっっっっっっっい「「「「う",
+		"あいうえお」」」」」」」」」」」」」」」」」」」」」」」」」」」」」」」」」」」",
+	}
+
+	// lines are only broken where the rules allow it, however narrow
+	// the canvas, and the runs that do not fit overflow
+	for _, width := range []int{1, 10, 30, 100} {
+
+		canvas := NewCanvas(color.White, width, 100)
+
+		for _, in := range tests {
+
+			lines, _ := BreakLines(in, basicfont.Face7x13, canvas)
+
+			if g := strings.Join(lines, ""); g != in {
+				t.Errorf("width %d: %s: got %s", width, in, strings.Join(lines, "|"))
+			}
+
+			for i, l := range lines {
+				r := []rune(l)
+				if i > 0 && strings.ContainsRune(noLineStart, r[0]) {
+					t.Errorf("width %d: %s: line %s starts with %c", width, in, l, r[0])
+				}
+				if i < len(lines)-1 && strings.ContainsRune(noLineEnd, r[len(r)-1]) {
+					t.Errorf("width %d: %s: line %s ends with %c", width, in, l, r[len(r)-1])
+				}
+			}
+		}
+	}
+}
+
+func TestLayoutLines(t *testing.T) {
+
+	canvas := NewCanvas(color.White, 100, 100)
+
+	segs := []Segment{
+		{Text: "あいうえお"},
+		{Text: "東京特許", Ruby: "とうきょうとっきょ"},
+		{Text: "きょかきょく"},
+	}
+
+	lines := LayoutLines(segs, basicfont.Face7x13, canvas)
+
+	if len(lines) != 2 {
+		t.Fatalf("%d lines", len(lines))
+	}
+
+	// the ruby group ends the first line
+	if n := len(lines[0].Segments); lines[0].Segments[n-1] != segs[1] {
+		t.Errorf("ruby group broken: %+v", lines)
+	}
+
+	if lines[0].Ascent <= lines[1].Ascent {
+		t.Errorf("no room for ruby: %+v", lines)
+	}
+
+	for _, l := range lines {
+		if l.Width <= 0 || l.Width > 1 {
+			t.Errorf("line %s has width %v", l.Text, l.Width)
+		}
+	}
+}
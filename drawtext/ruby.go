@@ -0,0 +1,153 @@
+package drawtext
+
+import (
+	"image"
+	"image/color"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// WriteSegments writes segs onto canvas in as many lines as needed,
+// broken as by LayoutLines, each line placed below the cursor as by
+// WriteLine. Segments without a face or colour of their own are
+// written with f and fg. Align is as for WriteLine.
+func WriteSegments(segs []Segment, align string, f font.Face, fg color.Color, canvas *Canvas) {
+
+	for _, l := range LayoutLines(segs, f, canvas) {
+		writeLine(l, align, f, fg, canvas)
+	}
+}
+
+// writeLine writes l on a new line.
+func writeLine(l Line, align string, f font.Face, fg color.Color, canvas *Canvas) {
+
+	width := int(l.Width * float64(canvas.maxXPr))
+	height := int(l.Height() * float64(canvas.maxYPr))
+
+	x := canvas.drawer.Dot.X.Round()
+	y := canvas.drawer.Dot.Y.Round() + int(float64(height)*1.35)
+
+	switch align {
+	case "center":
+		x = (canvas.maxX - width) / 2
+	case "left":
+		x = canvas.xoff
+	case "right":
+		x = canvas.xoff + canvas.maxXPr - width
+	}
+
+	canvas.drawer.Dot = fixed.P(x, y)
+
+	for _, s := range l.Segments {
+
+		face := faceOf(s, f)
+
+		canvas.drawer.Face = face
+		canvas.drawer.Src = image.NewUniform(colorOf(s, fg))
+
+		if s.Ruby == "" {
+			canvas.drawer.DrawString(s.Text)
+			continue
+		}
+
+		// centre base and ruby on each other
+		bw := font.MeasureString(face, s.Text)
+		rw := font.MeasureString(face, s.Ruby) / 2
+		gw := max(bw, rw)
+
+		dot := canvas.drawer.Dot
+
+		canvas.drawer.Dot.X += (gw - bw) / 2
+		canvas.drawer.DrawString(s.Text)
+
+		mask := rubyMask(s.Ruby, face)
+		bounds, _ := font.BoundString(face, s.Text)
+
+		rx := (dot.X + (gw-rw)/2).Round()
+		ry := (dot.Y + bounds.Min.Y).Round() - mask.Bounds().Dy()
+		canvas.drawMask(mask, rx, ry)
+
+		canvas.drawer.Dot = dot
+		canvas.drawer.Dot.X += gw
+	}
+
+	// leave the cursor at the baseline like WriteLine
+	canvas.drawer.Dot = fixed.P(0, y)
+}
+
+// colorOf returns the colour of s, which is fg unless s has its own.
+func colorOf(s Segment, fg color.Color) color.Color {
+
+	if s.Color != nil {
+		return s.Color
+	}
+
+	return fg
+}
+
+// rubyMask returns s written horizontally with f and scaled to half
+// the size.
+func rubyMask(s string, f font.Face) *image.Alpha {
+
+	m := f.Metrics()
+
+	w := font.MeasureString(f, s).Ceil()
+	h := m.Height.Ceil()
+
+	full := image.NewAlpha(image.Rect(0, 0, w, h))
+
+	d := &font.Drawer{
+		Dst:  full,
+		Src:  image.Opaque,
+		Face: f,
+		Dot:  fixed.Point26_6{Y: m.Ascent},
+	}
+
+	d.DrawString(s)
+
+	return halve(full)
+}
+
+// halve returns mask scaled to half its size.
+func halve(mask *image.Alpha) *image.Alpha {
+
+	b := mask.Bounds()
+
+	out := image.NewAlpha(image.Rect(0, 0, (b.Dx()+1)/2, (b.Dy()+1)/2))
+
+	draw.CatmullRom.Scale(out, out.Bounds(), mask, b, draw.Src, nil)
+
+	return out
+}
+
+// rubyColumn returns s written top to bottom with f and scaled to
+// half the size, for ruby of vertical text. Characters that are
+// turned in vertical text are turned here as well.
+func rubyColumn(s string, f font.Face) *image.Alpha {
+
+	size := em(f)
+
+	r := []rune(s)
+
+	col := image.NewAlpha(image.Rect(0, 0, size, size*len(r)))
+
+	for i, c := range r {
+
+		adv := font.MeasureString(f, string(c)).Ceil()
+
+		mask := textMask(string(c), f, max(adv, 1), size)
+
+		x, y := (size-adv)/2, i*size
+		if strings.ContainsRune(sideways, c) {
+			mask = rotateMask(mask)
+			x, y = 0, y+(size-adv)/2
+		}
+
+		draw.Draw(col, mask.Bounds().Add(image.Pt(x, y)), mask, image.Point{}, draw.Over)
+	}
+
+	return halve(col)
+}
@@ -0,0 +1,95 @@
+package drawtext
+
+import (
+	"image/color"
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+// inked returns the number of rows and columns of canvas that are not
+// white.
+func inked(canvas *Canvas) (rows, cols int) {
+
+	img := ImageOf(canvas)
+	b := img.Bounds()
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r != 0xffff {
+				rows++
+				break
+			}
+		}
+	}
+
+	for x := b.Min.X; x < b.Max.X; x++ {
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			if r, _, _, _ := img.At(x, y).RGBA(); r != 0xffff {
+				cols++
+				break
+			}
+		}
+	}
+
+	return rows, cols
+}
+
+func TestRuby(t *testing.T) {
+
+	f := basicfont.Face7x13
+
+	plain := []Segment{{Text: "ABC"}}
+	ruby := []Segment{{Text: "ABC", Ruby: "abcdef"}}
+
+	c1 := NewCanvas(color.White, 200, 200)
+	WriteSegments(plain, "center", f, color.Black, c1)
+
+	c2 := NewCanvas(color.White, 200, 200)
+	WriteSegments(ruby, "center", f, color.Black, c2)
+
+	r1, _ := inked(c1)
+	r2, _ := inked(c2)
+	if r2 <= r1 {
+		t.Errorf("no ruby above the text: %d rows against %d", r2, r1)
+	}
+
+	c3 := NewCanvas(color.White, 200, 200)
+	WriteColumnSegments(plain, f, color.Black, c3, 0.5, 0)
+
+	c4 := NewCanvas(color.White, 200, 200)
+	WriteColumnSegments(ruby, f, color.Black, c4, 0.5, 0)
+
+	_, k3 := inked(c3)
+	_, k4 := inked(c4)
+	if k4 <= k3 {
+		t.Errorf("no ruby next to the column: %d columns against %d", k4, k3)
+	}
+}
+
+func TestSegmentColor(t *testing.T) {
+
+	red := color.RGBA{255, 0, 0, 255}
+
+	canvas := NewCanvas(color.White, 200, 200)
+	WriteSegments([]Segment{{Text: "AB"}, {Text: "CD", Color: red}}, "left", basicfont.Face7x13, color.Black, canvas)
+
+	img := ImageOf(canvas)
+	b := img.Bounds()
+
+	black, reds := false, false
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			switch img.At(x, y) {
+			case color.RGBA{0, 0, 0, 255}:
+				black = true
+			case red:
+				reds = true
+			}
+		}
+	}
+
+	if !black || !reds {
+		t.Errorf("black text %v, red text %v", black, reds)
+	}
+}
@@ -0,0 +1,443 @@
+package drawtext
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"unicode"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Vertical layout (tategaki) writes text top to bottom in columns
+// that follow each other from right to left. Most characters stand
+// upright in a square cell of one em. Long vowel marks, dashes,
+// brackets, and runs of Latin letters are turned by 90 degrees, and
+// numbers of one or two digits are set horizontally in a single cell
+// (tate-chu-yoko).
+
+// sideways holds the characters that are turned by 90 degrees in
+// vertical text.
+const sideways = "ー－―—–‐〜～…‥＝=()（）「」『』［］【】〔〕〈〉《》｛｝〘〙〖〗｟｠＜＞<>[]{}"
+
+// hanging holds the punctuation that may hang below the end of a
+// column instead of being moved to the next one (burasage).
+const hanging = "、。，．"
+
+type cellMode int
+
+const (
+	upright cellMode = iota
+	turned
+	tcy
+)
+
+// cell is a unit of vertical layout: a character, a run of Latin
+// letters written sideways, a number set horizontally, or a ruby
+// group.
+type cell struct {
+	text string
+	mode cellMode
+
+	// length of the cell along the column in pixels
+	length int
+
+	face  font.Face
+	color color.Color
+
+	// ruby and the cells of the base text of ruby groups
+	ruby string
+	base []cell
+}
+
+// cells splits s into the cells of vertical layout.
+func cells(s string, f font.Face) []cell {
+	return segmentCells([]Segment{{Text: s}}, f, nil)
+}
+
+// segmentCells splits segs into the cells of vertical layout using f
+// and fg for segments without face or colour.
+func segmentCells(segs []Segment, f font.Face, fg color.Color) []cell {
+
+	var out []cell
+
+	for _, s := range segs {
+
+		face, fg := faceOf(s, f), colorOf(s, fg)
+
+		cs := textCells(s.Text, face, fg)
+
+		if s.Ruby == "" {
+			out = append(out, cs...)
+			continue
+		}
+
+		length := 0
+		for _, c := range cs {
+			length += c.length
+		}
+
+		rl := em(face) * len([]rune(s.Ruby)) / 2
+
+		out = append(out, cell{
+			text:   s.Text,
+			length: max(length, rl),
+			face:   face,
+			color:  fg,
+			ruby:   s.Ruby,
+			base:   cs,
+		})
+	}
+
+	return out
+}
+
+// textCells splits s into cells written with f in colour fg.
+func textCells(s string, f font.Face, fg color.Color) []cell {
+
+	size := em(f)
+
+	var out []cell
+
+	add := func(text string, mode cellMode, length int) {
+		out = append(out, cell{text: text, mode: mode, length: length, face: f, color: fg})
+	}
+
+	r := []rune(s)
+
+	for i := 0; i < len(r); i++ {
+
+		// runs of ASCII letters and digits
+		k := i
+		for k < len(r) && r[k] < unicode.MaxASCII && (unicode.IsLetter(r[k]) || unicode.IsDigit(r[k]) || r[k] == '!' || r[k] == '?') {
+			k++
+		}
+
+		switch {
+
+		case k > i && k-i <= 2 && (isDigits(r[i:k]) || isMarks(r[i:k])):
+			add(string(r[i:k]), tcy, size)
+			i = k - 1
+
+		case k > i:
+			run := string(r[i:k])
+			add(run, turned, font.MeasureString(f, run).Ceil())
+			i = k - 1
+
+		case strings.ContainsRune(sideways, r[i]):
+			adv, _ := f.GlyphAdvance(r[i])
+			add(string(r[i]), turned, max(adv.Ceil(), size/2))
+
+		default:
+			add(string(r[i]), upright, size)
+		}
+	}
+
+	return out
+}
+
+func isDigits(r []rune) bool {
+
+	for _, c := range r {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isMarks(r []rune) bool {
+
+	for _, c := range r {
+		if c != '!' && c != '?' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// breakColumns breaks cs into columns of at most height pixels,
+// following the kinsoku rules: characters of noLineStart do not start
+// a column and those of noLineEnd do not end one. Punctuation of
+// hanging may stick out below the end of a column.
+func breakColumns(cs []cell, height int) [][]cell {
+
+	var cols [][]cell
+
+	for len(cs) > 0 {
+
+		n, h := 0, 0
+		for n < len(cs) && (n == 0 || h+cs[n].length <= height) {
+			h += cs[n].length
+			n++
+		}
+
+		if n < len(cs) && isHangingCell(cs[n]) {
+			n++
+		}
+
+		// as for lines, a column that cannot be broken where it is
+		// full overflows up to the next allowed break
+		allowed := func(n int) bool {
+			return n == len(cs) || !startsWith(cs[n], noLineStart) && !endsWith(cs[n-1], noLineEnd)
+		}
+
+		m := n
+		for m > 1 && !allowed(m) {
+			m--
+		}
+
+		if !allowed(m) {
+			for m = n; !allowed(m); m++ {
+			}
+		}
+
+		n = m
+
+		cols = append(cols, cs[:n])
+		cs = cs[n:]
+	}
+
+	return cols
+}
+
+func isHangingCell(c cell) bool {
+	return c.ruby == "" && len([]rune(c.text)) == 1 && strings.Contains(hanging, c.text)
+}
+
+func startsWith(c cell, chars string) bool {
+	r, ok := firstRune(c.text)
+	return ok && strings.ContainsRune(chars, r)
+}
+
+func endsWith(c cell, chars string) bool {
+	r, ok := lastRune(c.text)
+	return ok && strings.ContainsRune(chars, r)
+}
+
+// em returns the size of the em square of f in pixels, which is the
+// advance of CJK ideographs.
+func em(f font.Face) int {
+
+	if adv, ok := f.GlyphAdvance('一'); ok && adv > 0 {
+		return adv.Ceil()
+	}
+
+	return f.Metrics().Height.Ceil()
+}
+
+// ColumnHeight returns the height s takes up when written with
+// WriteColumn relative to the printable height of canvas, ignoring
+// the breaking into several columns.
+func ColumnHeight(s string, f font.Face, canvas *Canvas) float64 {
+
+	h := 0
+	for _, c := range cells(s, f) {
+		h += c.length
+	}
+
+	return float64(h) / float64(canvas.maxYPr)
+}
+
+// BreakColumns breaks s into the columns WriteColumn writes it in if
+// the first column starts at ypos, relative to the printable height of
+// canvas.
+func BreakColumns(s string, f font.Face, canvas *Canvas, ypos float64) []string {
+
+	var out []string
+
+	for _, col := range breakColumns(cells(s, f), columnSpace(canvas, ypos)) {
+		w := new(strings.Builder)
+		for _, c := range col {
+			w.WriteString(c.text)
+		}
+		out = append(out, w.String())
+	}
+
+	return out
+}
+
+// columnSpace returns the space in pixels from ypos to the bottom
+// margin of canvas.
+func columnSpace(canvas *Canvas, ypos float64) int {
+	return canvas.maxYPr - int(float64(canvas.maxYPr)*ypos)
+}
+
+// WriteColumn writes s top to bottom in a column centred at xpos
+// starting at ypos, both relative to the printable area as for
+// SetCursor. Text that does not fit above the bottom margin continues
+// in further columns to the left, starting at ypos again. Lines are
+// broken following the kinsoku rules. It returns the width of all
+// columns relative to the printable width.
+func WriteColumn(s string, f font.Face, fg color.Color, canvas *Canvas, xpos, ypos float64) (width float64) {
+	return WriteColumnSegments([]Segment{{Text: s}}, f, fg, canvas, xpos, ypos)
+}
+
+// WriteColumnSegments writes segs like WriteColumn. Segments without
+// a face or colour of their own are written with f and fg. Ruby
+// groups are not broken across columns and their ruby is written to
+// the right of them, for which the columns are set further apart.
+func WriteColumnSegments(segs []Segment, f font.Face, fg color.Color, canvas *Canvas, xpos, ypos float64) (width float64) {
+
+	size := em(f)
+
+	x := int(float64(canvas.maxXPr)*xpos) + canvas.xoff
+	y0 := int(float64(canvas.maxYPr)*ypos) + canvas.yoff
+
+	cs := segmentCells(segs, f, fg)
+
+	// columns are a little wider than the characters
+	step := size * 5 / 4
+	for _, c := range cs {
+		if c.ruby != "" {
+			step = size * 7 / 4
+			break
+		}
+	}
+
+	cols := breakColumns(cs, columnSpace(canvas, ypos))
+
+	for _, col := range cols {
+
+		y := y0
+
+		for _, c := range col {
+			canvas.drawCell(c, x, y)
+			y += c.length
+		}
+
+		x -= step
+	}
+
+	return float64(len(cols)*step) / float64(canvas.maxXPr)
+}
+
+// drawCell draws c with its top at y and centred at x.
+func (canvas *Canvas) drawCell(c cell, x, y int) {
+
+	f := c.face
+
+	canvas.drawer.Face = f
+	canvas.drawer.Src = image.NewUniform(c.color)
+
+	size := em(f)
+	descent := f.Metrics().Descent.Round()
+
+	if c.ruby != "" {
+
+		length := 0
+		for _, b := range c.base {
+			length += b.length
+		}
+
+		by := y + (c.length-length)/2
+		for _, b := range c.base {
+			canvas.drawCell(b, x, by)
+			by += b.length
+		}
+
+		mask := rubyColumn(c.ruby, f)
+		canvas.drawer.Src = image.NewUniform(c.color)
+		canvas.drawMask(mask, x+size/2, y+(c.length-mask.Bounds().Dy())/2)
+
+		return
+	}
+
+	switch c.mode {
+
+	case upright:
+		adv := font.MeasureString(f, c.text).Round()
+		dx, dy := 0, 0
+		if isHangingCell(c) {
+			// punctuation sits in the upper right of the cell
+			dx, dy = size*3/5, -size*3/5
+		}
+		canvas.drawer.Dot = fixed.P(x-adv/2+dx, y+size-descent+dy)
+		canvas.drawer.DrawString(c.text)
+
+	case tcy:
+		adv := font.MeasureString(f, c.text).Round()
+		if adv <= size {
+			canvas.drawer.Dot = fixed.P(x-adv/2, y+size-descent)
+			canvas.drawer.DrawString(c.text)
+			return
+		}
+		// squeeze numbers wider than the cell
+		mask := textMask(c.text, f, adv, size)
+		canvas.drawMask(scaleMask(mask, size, size), x-size/2, y)
+
+	case turned:
+		adv := font.MeasureString(f, c.text).Ceil()
+		mask := textMask(c.text, f, adv, size)
+		canvas.drawMask(rotateMask(mask), x-size/2, y+(c.length-adv)/2)
+	}
+}
+
+// textMask returns s written horizontally with f on a mask of the
+// given width and an em square high.
+func textMask(s string, f font.Face, width, size int) *image.Alpha {
+
+	mask := image.NewAlpha(image.Rect(0, 0, width, size))
+
+	d := &font.Drawer{
+		Dst:  mask,
+		Src:  image.Opaque,
+		Face: f,
+		Dot:  fixed.P(0, size-f.Metrics().Descent.Round()),
+	}
+
+	d.DrawString(s)
+
+	return mask
+}
+
+// drawMask draws the colour of the canvas through mask with the top
+// left corner of mask at x, y.
+func (canvas *Canvas) drawMask(mask *image.Alpha, x, y int) {
+
+	dst, ok := canvas.drawer.Dst.(draw.Image)
+	if !ok {
+		return
+	}
+
+	r := mask.Bounds().Add(image.Pt(x, y))
+
+	draw.DrawMask(dst, r, canvas.drawer.Src, image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+// rotateMask returns mask turned by 90 degrees clockwise.
+func rotateMask(mask *image.Alpha) *image.Alpha {
+
+	b := mask.Bounds()
+
+	out := image.NewAlpha(image.Rect(0, 0, b.Dy(), b.Dx()))
+
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			out.SetAlpha(b.Dy()-1-y, x, mask.AlphaAt(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+
+	return out
+}
+
+// scaleMask returns mask scaled to width × height by picking the
+// nearest pixels.
+func scaleMask(mask *image.Alpha, width, height int) *image.Alpha {
+
+	b := mask.Bounds()
+
+	out := image.NewAlpha(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			out.SetAlpha(x, y, mask.AlphaAt(b.Min.X+x*b.Dx()/width, b.Min.Y+y*b.Dy()/height))
+		}
+	}
+
+	return out
+}
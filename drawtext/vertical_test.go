@@ -0,0 +1,80 @@
+package drawtext
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestCells(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		want []cellMode
+	}{
+		{"第12回", []cellMode{upright, tcy, upright}},
+		{"第123回", []cellMode{upright, turned, upright}},
+		{"「ABC」", []cellMode{turned, turned, turned}},
+		{"スープ!?", []cellMode{upright, turned, upright, tcy}},
+	}
+
+	for _, tt := range tests {
+
+		cs := cells(tt.in, basicfont.Face7x13)
+
+		if len(cs) != len(tt.want) {
+			t.Errorf("%s: %d cells, want %d", tt.in, len(cs), len(tt.want))
+			continue
+		}
+
+		for i, c := range cs {
+			if c.mode != tt.want[i] {
+				t.Errorf("%s: cell %q has mode %d, want %d", tt.in, c.text, c.mode, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestBreakColumns(t *testing.T) {
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"あいうえおかきく", "あいう|えおか|きく"},
+		// punctuation hangs below the column
+		{"あいう。えお", "あいう。|えお"},
+		// small kana do not start a column
+		{"あいうっえお", "あい|うっえ|お"},
+		// opening brackets do not end a column
+		{"あい「うえ」", "あい|「う|え」"},
+		// hanging punctuation followed by a closing bracket
+		{"あいう。」え", "あい|う。」|え"},
+		// runs that cannot be broken overflow the column
+		{"あっっっっえ", "あっっっっ|え"},
+		{"「「「「あい", "「「「「あ|い"},
+		{"」」」」」あいう", "」」」」」|あいう"},
+	}
+
+	for _, tt := range tests {
+
+		var cs []cell
+		for _, r := range tt.in {
+			cs = append(cs, cell{text: string(r), mode: upright, length: 10})
+		}
+
+		var got []string
+		for _, col := range breakColumns(cs, 30) {
+			s := ""
+			for _, c := range col {
+				s += c.text
+			}
+			got = append(got, s)
+		}
+
+		if g := strings.Join(got, "|"); g != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.in, g, tt.want)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package epubcheck
+
+import (
+	"regexp"
+	"strings"
+)
+
+// document is a parsed XML document of the manifest.
+type document struct {
+	item *item
+	root *node
+	ids  map[string]bool
+	refs []reference
+}
+
+// reference is a URL found in a document.
+type reference struct {
+	line int
+	url  string
+
+	// hyperlink is true for links followed by the reader, false
+	// for resources shown as part of the document.
+	hyperlink bool
+}
+
+// resourceAttrs are the attributes of XHTML and SVG elements that
+// refer to resources.
+var resourceAttrs = map[string][]string{
+	"img":    {"src"},
+	"link":   {"href"},
+	"script": {"src"},
+	"source": {"src"},
+	"audio":  {"src"},
+	"video":  {"src", "poster"},
+	"track":  {"src"},
+	"embed":  {"src"},
+	"iframe": {"src"},
+	"object": {"data"},
+	"image":  {"href"},
+	"use":    {"href"},
+}
+
+var cssURL = regexp.MustCompile(`url\(\s*['"]?([^'")]+?)['"]?\s*\)|@import\s+['"]([^'"]+)['"]`)
+
+// checkDocuments parses the XHTML, SVG, and NCX documents and the
+// style sheets of the manifest and collects their ids and references.
+func (c *checker) checkDocuments() {
+
+	c.docs = make(map[string]*document)
+
+	for _, it := range c.items {
+
+		if it.path == "" || c.files[it.path] == nil {
+			continue
+		}
+
+		switch it.mediaType {
+
+		case "application/xhtml+xml", "image/svg+xml", "application/x-dtbncx+xml":
+
+			data, _ := c.read(it.path)
+
+			root, err := parseXML(data)
+			if err != nil {
+				c.errorf(it.path, "not well-formed: %v", err)
+				continue
+			}
+
+			doc := &document{item: it, root: root, ids: make(map[string]bool)}
+			c.docs[it.path] = doc
+
+			c.checkDocument(doc)
+
+		case "text/css":
+
+			data, _ := c.read(it.path)
+
+			doc := &document{item: it}
+			c.docs[it.path] = doc
+
+			for i, line := range strings.Split(string(data), "\n") {
+				for _, m := range cssURL.FindAllStringSubmatch(line, -1) {
+					doc.refs = append(doc.refs, reference{line: i + 1, url: m[1] + m[2]})
+				}
+			}
+		}
+	}
+}
+
+func (c *checker) checkDocument(doc *document) {
+
+	it, root := doc.item, doc.root
+
+	switch it.mediaType {
+
+	case "application/xhtml+xml":
+		if root.name.Local != "html" || root.name.Space != nsXHTML {
+			c.errorf(it.path, "root element is not html in the XHTML namespace")
+			return
+		}
+		if len(root.find("head")) != 1 || len(root.find("body")) != 1 {
+			c.errorf(it.path, "document must have one head and one body")
+		}
+		if t := root.find("title"); len(t) == 0 || strings.TrimSpace(t[0].textContent()) == "" {
+			c.warnf(it.path, "document has no title")
+		}
+
+	case "image/svg+xml":
+		if root.name.Local != "svg" || root.name.Space != nsSVG {
+			c.errorf(it.path, "root element is not svg in the SVG namespace")
+			return
+		}
+
+	case "application/x-dtbncx+xml":
+		if root.name.Local != "ncx" || root.name.Space != nsNCX {
+			c.errorf(it.path, "root element is not ncx in the NCX namespace")
+			return
+		}
+	}
+
+	root.walk(func(n *node) {
+
+		if undeclared(n.name.Space) {
+			c.errorf(it.path, "line %d: undeclared namespace prefix %s", n.line, n.name.Space)
+		}
+
+		for _, a := range n.attrs {
+
+			if undeclared(a.Name.Space) && a.Name.Space != "xmlns" {
+				c.errorf(it.path, "line %d: undeclared namespace prefix %s", n.line, a.Name.Space)
+			}
+
+			if a.Name.Local != "id" || (a.Name.Space != "" && a.Name.Space != nsXML) {
+				continue
+			}
+
+			if doc.ids[a.Value] {
+				c.errorf(it.path, "line %d: duplicate id %s", n.line, a.Value)
+			}
+			doc.ids[a.Value] = true
+		}
+
+		switch {
+
+		case it.mediaType == "application/x-dtbncx+xml":
+			if n.name.Local == "content" {
+				doc.refs = append(doc.refs, reference{n.line, n.attr("", "src"), true})
+			}
+
+		case n.name.Local == "a" || n.name.Local == "area":
+			ref := n.attr("", "href")
+			if ref == "" {
+				ref = n.attr(nsXLink, "href")
+			}
+			if ref != "" {
+				doc.refs = append(doc.refs, reference{n.line, ref, true})
+			}
+
+		default:
+			for _, key := range resourceAttrs[n.name.Local] {
+				ref := n.attr("", key)
+				if ref == "" && key == "href" {
+					ref = n.attr(nsXLink, key)
+				}
+				if ref != "" {
+					doc.refs = append(doc.refs, reference{n.line, ref, false})
+				}
+			}
+		}
+	})
+}
+
+// undeclared reports whether space is a namespace prefix the XML
+// decoder could not map to a namespace.
+func undeclared(space string) bool {
+	return space != "" && !strings.Contains(space, ":")
+}
+
+// checkLinks checks that the references found in the documents refer
+// to files in the manifest, that hyperlinks lead to documents in the
+// spine, and that fragments exist.
+func (c *checker) checkLinks() {
+
+	for _, it := range c.items {
+
+		doc := c.docs[it.path]
+		if doc == nil {
+			continue
+		}
+
+		for _, ref := range doc.refs {
+
+			p, fragment, local, err := resolve(it.path, ref.url)
+
+			switch {
+			case err != nil:
+				c.errorf(it.path, "line %d: invalid reference %q: %v", ref.line, ref.url, err)
+				continue
+			case !local:
+				continue
+			case c.files[p] == nil:
+				c.errorf(it.path, "line %d: %s refers to missing file %s", ref.line, ref.url, p)
+				continue
+			case c.byPath[p] == nil:
+				c.errorf(it.path, "line %d: %s refers to %s which is not in the manifest", ref.line, ref.url, p)
+				continue
+			case ref.hyperlink && p != it.path && !c.spine[p]:
+				c.errorf(it.path, "line %d: %s links to %s which is not in the spine", ref.line, ref.url, p)
+			}
+
+			target := c.docs[p]
+
+			if fragment == "" || target == nil || target.ids == nil || strings.ContainsAny(fragment, "(=") {
+				continue
+			}
+
+			if !target.ids[fragment] {
+				c.errorf(it.path, "line %d: %s refers to fragment %s which is not defined in %s", ref.line, ref.url, fragment, p)
+			}
+		}
+	}
+}
+
+// checkNav checks the structure of the navigation document.
+func (c *checker) checkNav() {
+
+	var doc *document
+	for _, it := range c.items {
+		if it.has("nav") {
+			doc = c.docs[it.path]
+		}
+	}
+
+	if doc == nil || doc.root.name.Space != nsXHTML {
+		return
+	}
+
+	name := doc.item.path
+
+	toc := 0
+
+	for _, nav := range doc.root.find("nav") {
+
+		types := strings.Fields(nav.attr(nsOPS, "type"))
+
+		for _, tp := range types {
+			if tp == "toc" {
+				toc++
+			}
+		}
+
+		var lists []*node
+		for k, n := range nav.children {
+			switch {
+			case n.name.Local == "ol":
+				lists = append(lists, n)
+			case k == 0 && isHeading(n):
+			default:
+				c.errorf(name, "line %d: unexpected %s in nav", n.line, n.name.Local)
+			}
+		}
+
+		if len(lists) != 1 {
+			c.errorf(name, "line %d: nav must contain one ol, not %d", nav.line, len(lists))
+			continue
+		}
+
+		landmarks := len(types) == 1 && types[0] == "landmarks"
+
+		c.checkNavList(name, lists[0], landmarks)
+	}
+
+	if toc != 1 {
+		c.errorf(name, "%d toc nav elements instead of one", toc)
+	}
+}
+
+func (c *checker) checkNavList(name string, ol *node, landmarks bool) {
+
+	if len(ol.children) == 0 {
+		c.errorf(name, "line %d: empty ol in nav", ol.line)
+	}
+
+	for _, li := range ol.children {
+
+		if li.name.Local != "li" {
+			c.errorf(name, "line %d: %s in nav list", li.line, li.name.Local)
+			continue
+		}
+
+		if len(li.children) == 0 || (li.children[0].name.Local != "a" && li.children[0].name.Local != "span") {
+			c.errorf(name, "line %d: nav list item must start with a or span", li.line)
+			continue
+		}
+
+		label := li.children[0]
+
+		if strings.TrimSpace(label.textContent()) == "" && label.attr("", "title") == "" {
+			c.errorf(name, "line %d: nav entry without label", label.line)
+		}
+
+		switch {
+		case label.name.Local == "a" && label.attr("", "href") == "":
+			c.errorf(name, "line %d: nav link without href", label.line)
+		case label.name.Local == "a" && landmarks && label.attr(nsOPS, "type") == "":
+			c.errorf(name, "line %d: landmark without epub:type", label.line)
+		case label.name.Local == "span" && len(li.children) < 2:
+			c.errorf(name, "line %d: nav heading without sublist", label.line)
+		}
+
+		for _, n := range li.children[1:] {
+			if n.name.Local != "ol" {
+				c.errorf(name, "line %d: unexpected %s in nav list item", n.line, n.name.Local)
+				continue
+			}
+			c.checkNavList(name, n, landmarks)
+		}
+	}
+}
+
+func isHeading(n *node) bool {
+
+	switch n.name.Local {
+	case "h1", "h2", "h3", "h4", "h5", "h6", "hgroup":
+		return true
+	}
+
+	return false
+}
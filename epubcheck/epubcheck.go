@@ -0,0 +1,309 @@
+// Package epubcheck checks the conformance of EPUB 3 files such as
+// the ones written by package azrconvert.
+//
+// The checks cover the OCF container (the mimetype file and
+// META-INF/container.xml), the package document (metadata, manifest,
+// and spine), the well-formedness of all XML files, the links and
+// resources referred to by XHTML documents and style sheets, the
+// structure of the navigation document, and the media types of the
+// files in the manifest. It is not a replacement for the full rules of
+// the EPUB specification but catches the problems that keep reading
+// systems from opening a book.
+package epubcheck
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/adamay909/AozoraConvert/internal/diag"
+)
+
+// Severity tells how bad a problem is. It is the same as in package
+// mobi/validate.
+type Severity = diag.Severity
+
+const (
+	Warning = diag.Warning
+	Error   = diag.Error
+)
+
+// Diagnostic describes a problem found in an EPUB file. File is the
+// name of the file inside the container the problem was found in, or
+// empty if the problem is about the container as a whole.
+type Diagnostic struct {
+	Severity Severity
+	File     string
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+
+	if d.File == "" {
+		return d.Severity.String() + ": " + d.Message
+	}
+
+	return d.Severity.String() + ": " + d.File + ": " + d.Message
+}
+
+// HasErrors reports whether ds contains errors.
+func HasErrors(ds []Diagnostic) bool {
+
+	for _, d := range ds {
+		if d.Severity == Error {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check checks the EPUB file data.
+func Check(data []byte) []Diagnostic {
+
+	c := &checker{data: data}
+
+	c.check()
+
+	return c.ds
+}
+
+type checker struct {
+	data []byte
+
+	files map[string]*zip.File
+	names []string
+
+	opf string
+
+	items  []*item
+	byID   map[string]*item
+	byPath map[string]*item
+	spine  map[string]bool
+
+	docs map[string]*document
+
+	ds []Diagnostic
+}
+
+// item is an item of the manifest.
+type item struct {
+	id, href, mediaType, fallback string
+
+	// path of the file in the container
+	path string
+
+	properties []string
+}
+
+func (it *item) has(property string) bool {
+
+	for _, p := range it.properties {
+		if p == property {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *checker) errorf(file, format string, args ...any) {
+	c.ds = append(c.ds, Diagnostic{Error, file, fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) warnf(file, format string, args ...any) {
+	c.ds = append(c.ds, Diagnostic{Warning, file, fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) check() {
+
+	if !c.checkContainer() {
+		return
+	}
+
+	if !c.checkPackage() {
+		return
+	}
+
+	c.checkMediaTypes()
+	c.checkDocuments()
+	c.checkNav()
+	c.checkLinks()
+}
+
+// read returns the contents of the file name in the container.
+func (c *checker) read(name string) ([]byte, bool) {
+
+	f, ok := c.files[name]
+	if !ok {
+		return nil, false
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		c.errorf(name, "cannot open: %v", err)
+		return nil, false
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		c.errorf(name, "cannot read: %v", err)
+		return nil, false
+	}
+
+	return data, true
+}
+
+// checkContainer checks the zip archive, the mimetype file, and
+// META-INF/container.xml, and finds the package document.
+func (c *checker) checkContainer() bool {
+
+	arch, err := zip.NewReader(bytes.NewReader(c.data), int64(len(c.data)))
+	if err != nil {
+		c.errorf("", "cannot read zip archive: %v", err)
+		return false
+	}
+
+	c.files = make(map[string]*zip.File)
+
+	folded := make(map[string]string)
+
+	for _, f := range arch.File {
+
+		name := f.Name
+
+		switch {
+		case strings.HasSuffix(name, "/"):
+			continue
+		case strings.HasPrefix(name, "/") || strings.Contains(name, "\\") || path.Clean(name) != name || strings.HasPrefix(name, "../"):
+			c.errorf(name, "invalid file name")
+		case c.files[name] != nil:
+			c.errorf(name, "file appears more than once in the archive")
+			continue
+		}
+
+		if other, ok := folded[strings.ToLower(name)]; ok {
+			c.warnf(name, "file name differs from %s only in case", other)
+		}
+		folded[strings.ToLower(name)] = name
+
+		c.files[name] = f
+		c.names = append(c.names, name)
+	}
+
+	c.checkMimetype(arch)
+
+	data, ok := c.read("META-INF/container.xml")
+	if !ok {
+		c.errorf("", "META-INF/container.xml is missing")
+		return false
+	}
+
+	root, err := parseXML(data)
+	if err != nil {
+		c.errorf("META-INF/container.xml", "not well-formed: %v", err)
+		return false
+	}
+
+	if root.name.Local != "container" || root.name.Space != nsContainer {
+		c.errorf("META-INF/container.xml", "root element is %s, not container", root.name.Local)
+	}
+
+	for _, rf := range root.find("rootfile") {
+		if rf.attr("", "media-type") == "application/oebps-package+xml" {
+			c.opf = rf.attr("", "full-path")
+			break
+		}
+	}
+
+	switch {
+	case c.opf == "":
+		c.errorf("META-INF/container.xml", "no rootfile for the package document")
+		return false
+	case c.files[c.opf] == nil:
+		c.errorf("META-INF/container.xml", "package document %s is missing", c.opf)
+		return false
+	}
+
+	return true
+}
+
+func (c *checker) checkMimetype(arch *zip.Reader) {
+
+	if len(arch.File) == 0 || arch.File[0].Name != "mimetype" {
+		c.errorf("mimetype", "mimetype must be the first file in the archive")
+		return
+	}
+
+	f := arch.File[0]
+
+	if f.Method != zip.Store {
+		c.errorf("mimetype", "mimetype must not be compressed")
+	}
+
+	if len(f.Extra) > 0 {
+		c.errorf("mimetype", "mimetype has an extra field of length %d", len(f.Extra))
+	}
+
+	data, ok := c.read("mimetype")
+	if ok && string(data) != "application/epub+zip" {
+		c.errorf("mimetype", "contents are %q, not application/epub+zip", data)
+	}
+
+	// reading systems identify EPUB files by the bytes at offset 30
+	if !bytes.HasPrefix(c.data[30:], []byte("mimetypeapplication/epub+zip")) {
+		c.errorf("mimetype", "mimetype does not start at offset 30 of the file")
+	}
+}
+
+// resolve returns the path in the container and the fragment of the
+// URL ref found in the file base. ok is false for links to other
+// sites and data URLs.
+func resolve(base, ref string) (p, fragment string, ok bool, err error) {
+
+	u, err := parseURL(ref)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	if u.Scheme != "" || u.Host != "" {
+		return "", "", false, nil
+	}
+
+	if u.Path == "" {
+		return base, u.Fragment, true, nil
+	}
+
+	p = path.Join(path.Dir(base), u.Path)
+
+	if p == ".." || strings.HasPrefix(p, "../") {
+		return "", "", false, fmt.Errorf("%s is outside the container", ref)
+	}
+
+	return p, u.Fragment, true, nil
+}
+
+// unlisted returns the files of the container that are not in the
+// manifest and are not part of the OCF container.
+func (c *checker) unlisted() []string {
+
+	var out []string
+
+	for _, name := range c.names {
+		if name == "mimetype" || name == c.opf || strings.HasPrefix(name, "META-INF/") {
+			continue
+		}
+		if c.byPath[name] == nil {
+			out = append(out, name)
+		}
+	}
+
+	sort.Strings(out)
+
+	return out
+}
@@ -0,0 +1,187 @@
+package epubcheck
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const (
+	testOPF = `<?xml version="1.0" encoding="utf-8"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0" unique-identifier="uid">
+<metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+<dc:title id="title">題</dc:title>
+<meta refines="#title" property="title-type">main</meta>
+<dc:language>ja</dc:language>
+<dc:identifier id="uid">urn:uuid:0</dc:identifier>
+<meta property="dcterms:modified">2020-01-02T03:04:05Z</meta>
+</metadata>
+<manifest>
+<item id="nav" href="toc.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+<item id="c1" href="1.html" media-type="application/xhtml+xml"/>
+<item id="c2" href="2.html" media-type="application/xhtml+xml"/>
+<item id="css" href="style.css" media-type="text/css"/>
+<item id="cover" href="img/cover.png" media-type="image/png" properties="cover-image"/>
+</manifest>
+<spine page-progression-direction="rtl">
+<itemref idref="c1"/>
+<itemref idref="c2"/>
+</spine>
+<guide>
+<reference type="text" title="本文" href="1.html#s1"/>
+</guide>
+</package>`
+
+	testNav = `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>目次</title></head>
+<body>
+<nav epub:type="toc"><h1>目次</h1>
+<ol><li><a href="1.html#s1">一</a><ol><li><a href="2.html">二</a></li></ol></li></ol>
+</nav>
+<nav epub:type="landmarks" hidden="hidden">
+<ol><li><a epub:type="bodymatter" href="1.html#s1">本文</a></li></ol>
+</nav>
+</body>
+</html>`
+
+	testChapter1 = `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>一</title><link rel="stylesheet" type="text/css" href="style.css"/></head>
+<body><h3 id="s1">一</h3><p><img src="img/cover.png" alt=""/><a href="2.html#n1">注</a></p></body>
+</html>`
+
+	testChapter2 = `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>二</title></head>
+<body><p id="n1">注の本文 &amp; <a href="#n1">戻る</a></p></body>
+</html>`
+)
+
+// testFiles returns the files of a small valid EPUB in the order they
+// are written to the archive.
+func testFiles() [][2]string {
+
+	return [][2]string{
+		{"mimetype", "application/epub+zip"},
+		{"META-INF/container.xml", `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+<rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`},
+		{"OEBPS/content.opf", testOPF},
+		{"OEBPS/toc.xhtml", testNav},
+		{"OEBPS/1.html", testChapter1},
+		{"OEBPS/2.html", testChapter2},
+		{"OEBPS/style.css", `body { background: url("img/cover.png"); }`},
+		{"OEBPS/img/cover.png", "\x89PNG\r\n\x1a\n"},
+	}
+}
+
+func zipFiles(t *testing.T, files [][2]string, compressMimetype bool) []byte {
+
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+
+	for _, f := range files {
+
+		method := zip.Deflate
+		if f[0] == "mimetype" && !compressMimetype {
+			method = zip.Store
+		}
+
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: f[0], Method: method})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = fw.Write([]byte(f[1]))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestCheckValid(t *testing.T) {
+
+	for _, d := range Check(zipFiles(t, testFiles(), false)) {
+		t.Error(d)
+	}
+}
+
+func TestCheckBroken(t *testing.T) {
+
+	tests := []struct {
+		name, file, old, new string
+		want                 string
+		warning              bool
+	}{
+		{"not zip", "", "", "", "cannot read zip archive", false},
+		{"compressed mimetype", "", "", "", "mimetype must not be compressed", false},
+		{"rootfile", "META-INF/container.xml", "OEBPS/content.opf", "content.opf", "package document content.opf is missing", false},
+		{"title", "OEBPS/content.opf", `<dc:title id="title">題</dc:title>`, "", "no dc:title", false},
+		{"unique identifier", "OEBPS/content.opf", `id="uid"`, `id="x"`, `unique-identifier "uid" does not refer`, false},
+		{"modified", "OEBPS/content.opf", "2020-01-02T03:04:05Z", "2020-01-02", "not of the form", false},
+		{"missing file", "OEBPS/content.opf", `href="2.html"`, `href="3.html"`, "manifest item c2 refers to missing file OEBPS/3.html", false},
+		{"unlisted file", "", "", "", "OEBPS/notes.txt: file is not listed in the manifest", true},
+		{"spine", "OEBPS/content.opf", `<itemref idref="c2"/>`, `<itemref idref="c3"/>`, "spine item c3 is not in the manifest", false},
+		{"spine media type", "OEBPS/content.opf", `<itemref idref="c2"/>`, `<itemref idref="css"/>`, "spine item css has media-type text/css", false},
+		{"nav property", "OEBPS/content.opf", ` properties="nav"`, "", "0 manifest items with property nav", false},
+		{"media type", "OEBPS/content.opf", `href="img/cover.png" media-type="image/png"`, `href="img/cover.png" media-type="image/jpeg"`, "OEBPS/img/cover.png: contents are not image/jpeg", false},
+		{"entity", "OEBPS/2.html", "&amp;", "&nbsp;", "OEBPS/2.html: not well-formed", false},
+		{"escaping", "OEBPS/2.html", "&amp;", "&", "OEBPS/2.html: not well-formed", false},
+		{"namespace", "OEBPS/toc.xhtml", ` xmlns:epub="http://www.idpf.org/2007/ops"`, "", "undeclared namespace prefix epub", false},
+		{"fragment", "OEBPS/1.html", "2.html#n1", "2.html#n2", "refers to fragment n2 which is not defined in OEBPS/2.html", false},
+		{"manifest id", "OEBPS/content.opf", `id="c2"`, `id="c1"`, "OEBPS/content.opf: line 13: duplicate id c1", false},
+		{"title id", "OEBPS/content.opf", `id="cover"`, `id="title"`, "OEBPS/content.opf: line 15: duplicate id title", false},
+		{"duplicate id", "OEBPS/2.html", "<body>", `<body id="n1">`, "duplicate id n1", false},
+		{"image", "OEBPS/1.html", "img/cover.png", "img/cover.jpg", "img/cover.jpg refers to missing file OEBPS/img/cover.jpg", false},
+		{"css", "OEBPS/style.css", "img/cover.png", "cover.png", "line 1: cover.png refers to missing file OEBPS/cover.png", false},
+		{"not in spine", "OEBPS/1.html", "2.html#n1", "toc.xhtml", "links to OEBPS/toc.xhtml which is not in the spine", false},
+		{"toc nav", "OEBPS/toc.xhtml", `epub:type="toc"`, `epub:type="page-list"`, "0 toc nav elements instead of one", false},
+		{"nav label", "OEBPS/toc.xhtml", `<a href="2.html">二</a>`, `<a href="2.html"></a>`, "nav entry without label", false},
+		{"guide", "OEBPS/content.opf", `href="1.html#s1"`, `href="3.html"`, "guide reference to OEBPS/3.html which is not in the manifest", false},
+		{"landmarks", "OEBPS/toc.xhtml", `<a epub:type="bodymatter" href="1.html#s1">`, `<a href="1.html#s1">`, "landmark without epub:type", false},
+		{"nav structure", "OEBPS/toc.xhtml", `<li><a href="2.html">二</a></li>`, `<a href="2.html">二</a>`, "a in nav list", false},
+	}
+
+	for _, tc := range tests {
+
+		files := testFiles()
+		for i, f := range files {
+			if f[0] == tc.file {
+				if !strings.Contains(f[1], tc.old) {
+					t.Fatalf("%s: %s does not contain %s", tc.name, tc.file, tc.old)
+				}
+				files[i][1] = strings.Replace(f[1], tc.old, tc.new, 1)
+			}
+		}
+
+		if tc.name == "unlisted file" {
+			files = append(files, [2]string{"OEBPS/notes.txt", "覚書"})
+		}
+
+		data := zipFiles(t, files, tc.name == "compressed mimetype")
+		if tc.name == "not zip" {
+			data = data[:100]
+		}
+
+		ds := Check(data)
+
+		found := false
+		for _, d := range ds {
+			found = found || strings.Contains(d.String(), tc.want)
+		}
+
+		if !found || HasErrors(ds) == tc.warning {
+			t.Errorf("%s: want %q, got %v", tc.name, tc.want, ds)
+		}
+	}
+}
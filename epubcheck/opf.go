@@ -0,0 +1,400 @@
+package epubcheck
+
+import (
+	"bytes"
+	"mime"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var modifiedDate = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z$`)
+
+// checkPackage checks the metadata, manifest, and spine of the
+// package document.
+func (c *checker) checkPackage() bool {
+
+	data, _ := c.read(c.opf)
+
+	root, err := parseXML(data)
+	if err != nil {
+		c.errorf(c.opf, "not well-formed: %v", err)
+		return false
+	}
+
+	if root.name.Local != "package" || root.name.Space != nsOPF {
+		c.errorf(c.opf, "root element is %s, not package", root.name.Local)
+		return false
+	}
+
+	if v := root.attr("", "version"); !strings.HasPrefix(v, "3.") {
+		c.errorf(c.opf, "package version is %q, not 3.0", v)
+	}
+
+	c.checkMetadata(root)
+
+	c.checkManifest(root)
+
+	c.checkSpine(root)
+
+	c.checkGuide(root)
+
+	return true
+}
+
+func (c *checker) checkMetadata(root *node) {
+
+	var metadata *node
+	for _, n := range root.children {
+		if n.name.Local == "metadata" {
+			metadata = n
+		}
+	}
+
+	if metadata == nil {
+		c.errorf(c.opf, "no metadata element")
+		return
+	}
+
+	count := func(local string) int {
+		k := 0
+		for _, n := range metadata.children {
+			if n.name.Space == nsDC && n.name.Local == local && strings.TrimSpace(n.text) != "" {
+				k++
+			}
+		}
+		return k
+	}
+
+	if count("title") == 0 {
+		c.errorf(c.opf, "no dc:title")
+	}
+
+	if count("language") == 0 {
+		c.errorf(c.opf, "no dc:language")
+	}
+
+	uid := root.attr("", "unique-identifier")
+	found := false
+	for _, n := range metadata.children {
+		if n.name.Space == nsDC && n.name.Local == "identifier" && n.attr("", "id") == uid {
+			found = strings.TrimSpace(n.text) != ""
+		}
+	}
+	if !found {
+		c.errorf(c.opf, "unique-identifier %q does not refer to a dc:identifier", uid)
+	}
+
+	modified := 0
+	for _, n := range metadata.children {
+		if n.name.Local != "meta" || n.attr("", "property") != "dcterms:modified" || n.attr("", "refines") != "" {
+			continue
+		}
+		modified++
+		if !modifiedDate.MatchString(strings.TrimSpace(n.text)) {
+			c.errorf(c.opf, "dcterms:modified %q is not of the form CCYY-MM-DDThh:mm:ssZ", n.text)
+		}
+	}
+	if modified != 1 {
+		c.errorf(c.opf, "%d dcterms:modified elements instead of one", modified)
+	}
+
+	// ids are unique in the whole package document, not only among
+	// the manifest items
+	ids := make(map[string]bool)
+	root.walk(func(n *node) {
+		id := n.attr("", "id")
+		if id == "" {
+			return
+		}
+		if ids[id] {
+			c.errorf(c.opf, "line %d: duplicate id %s", n.line, id)
+		}
+		ids[id] = true
+	})
+
+	for _, n := range metadata.children {
+		ref := n.attr("", "refines")
+		if strings.HasPrefix(ref, "#") && !ids[ref[1:]] {
+			c.errorf(c.opf, "line %d: refines %s which does not exist", n.line, ref)
+		}
+	}
+}
+
+func (c *checker) checkManifest(root *node) {
+
+	c.byID = make(map[string]*item)
+	c.byPath = make(map[string]*item)
+
+	var manifest *node
+	for _, n := range root.children {
+		if n.name.Local == "manifest" {
+			manifest = n
+		}
+	}
+
+	if manifest == nil {
+		c.errorf(c.opf, "no manifest element")
+		return
+	}
+
+	nav := 0
+
+	for _, n := range manifest.children {
+
+		if n.name.Local != "item" {
+			continue
+		}
+
+		it := &item{
+			id:         n.attr("", "id"),
+			href:       n.attr("", "href"),
+			mediaType:  n.attr("", "media-type"),
+			fallback:   n.attr("", "fallback"),
+			properties: strings.Fields(n.attr("", "properties")),
+		}
+
+		switch {
+		case it.id == "":
+			c.errorf(c.opf, "line %d: manifest item without id", n.line)
+			continue
+		case c.byID[it.id] != nil:
+			// reported by checkMetadata
+			continue
+		case it.mediaType == "":
+			c.errorf(c.opf, "manifest item %s has no media-type", it.id)
+		}
+
+		c.byID[it.id] = it
+		c.items = append(c.items, it)
+
+		p, _, local, err := resolve(c.opf, it.href)
+		switch {
+		case it.href == "":
+			c.errorf(c.opf, "manifest item %s has no href", it.id)
+			continue
+		case err != nil:
+			c.errorf(c.opf, "manifest item %s: %v", it.id, err)
+			continue
+		case !local:
+			// remote resources are allowed for audio, video, and fonts
+			continue
+		case c.byPath[p] != nil:
+			c.errorf(c.opf, "manifest items %s and %s refer to the same file %s", c.byPath[p].id, it.id, p)
+			continue
+		case c.files[p] == nil:
+			c.errorf(c.opf, "manifest item %s refers to missing file %s", it.id, p)
+		}
+
+		it.path = p
+		c.byPath[p] = it
+
+		if it.has("nav") {
+			nav++
+			if it.mediaType != "application/xhtml+xml" {
+				c.errorf(c.opf, "navigation document %s is not XHTML", it.id)
+			}
+		}
+
+		if it.has("cover-image") && !strings.HasPrefix(it.mediaType, "image/") {
+			c.errorf(c.opf, "cover image %s has media-type %s", it.id, it.mediaType)
+		}
+	}
+
+	if nav != 1 {
+		c.errorf(c.opf, "%d manifest items with property nav instead of one", nav)
+	}
+
+	for _, it := range c.items {
+		if it.fallback != "" && c.byID[it.fallback] == nil {
+			c.errorf(c.opf, "fallback %s of manifest item %s does not exist", it.fallback, it.id)
+		}
+	}
+
+	for _, name := range c.unlisted() {
+		c.warnf(name, "file is not listed in the manifest")
+	}
+}
+
+func (c *checker) checkSpine(root *node) {
+
+	c.spine = make(map[string]bool)
+
+	var spine *node
+	for _, n := range root.children {
+		if n.name.Local == "spine" {
+			spine = n
+		}
+	}
+
+	if spine == nil {
+		c.errorf(c.opf, "no spine element")
+		return
+	}
+
+	switch ppd := spine.attr("", "page-progression-direction"); ppd {
+	case "", "ltr", "rtl", "default":
+	default:
+		c.errorf(c.opf, "invalid page-progression-direction %q", ppd)
+	}
+
+	if toc := spine.attr("", "toc"); toc != "" {
+		if it := c.byID[toc]; it == nil || it.mediaType != "application/x-dtbncx+xml" {
+			c.errorf(c.opf, "spine toc %s is not an NCX file in the manifest", toc)
+		}
+	}
+
+	linear := 0
+
+	for _, n := range spine.children {
+
+		if n.name.Local != "itemref" {
+			continue
+		}
+
+		id := n.attr("", "idref")
+		it := c.byID[id]
+
+		switch {
+		case it == nil:
+			c.errorf(c.opf, "spine item %s is not in the manifest", id)
+			continue
+		case c.spine[it.path]:
+			c.errorf(c.opf, "spine item %s appears more than once", id)
+		case !c.contentDocument(it):
+			c.errorf(c.opf, "spine item %s has media-type %s and no XHTML fallback", id, it.mediaType)
+		}
+
+		c.spine[it.path] = true
+
+		if n.attr("", "linear") != "no" {
+			linear++
+		}
+	}
+
+	if linear == 0 {
+		c.errorf(c.opf, "spine has no linear items")
+	}
+}
+
+// checkGuide checks that the references of the EPUB 2 guide, which
+// EPUB 3 keeps for older reading systems, refer to manifest items.
+func (c *checker) checkGuide(root *node) {
+
+	for _, n := range root.children {
+
+		if n.name.Local != "guide" {
+			continue
+		}
+
+		for _, ref := range n.children {
+
+			if ref.name.Local != "reference" {
+				continue
+			}
+
+			if ref.attr("", "type") == "" {
+				c.errorf(c.opf, "line %d: guide reference without type", ref.line)
+			}
+
+			p, _, local, err := resolve(c.opf, ref.attr("", "href"))
+			switch {
+			case err != nil:
+				c.errorf(c.opf, "line %d: guide reference: %v", ref.line, err)
+			case local && c.byPath[p] == nil:
+				c.errorf(c.opf, "line %d: guide reference to %s which is not in the manifest", ref.line, p)
+			}
+		}
+	}
+}
+
+// contentDocument reports whether it or one of its fallbacks is an
+// XHTML or SVG content document.
+func (c *checker) contentDocument(it *item) bool {
+
+	seen := make(map[*item]bool)
+
+	for it != nil && !seen[it] {
+		if it.mediaType == "application/xhtml+xml" || it.mediaType == "image/svg+xml" {
+			return true
+		}
+		seen[it] = true
+		it = c.byID[it.fallback]
+	}
+
+	return false
+}
+
+// coreTypes are the core media types of EPUB 3 and the file name
+// extensions used for them.
+var coreTypes = map[string][]string{
+	"application/xhtml+xml":       {".xhtml", ".html", ".htm"},
+	"image/svg+xml":               {".svg"},
+	"image/png":                   {".png"},
+	"image/jpeg":                  {".jpg", ".jpeg"},
+	"image/gif":                   {".gif"},
+	"image/webp":                  {".webp"},
+	"text/css":                    {".css"},
+	"application/x-dtbncx+xml":    {".ncx"},
+	"font/otf":                    {".otf"},
+	"font/ttf":                    {".ttf"},
+	"font/woff":                   {".woff"},
+	"font/woff2":                  {".woff2"},
+	"application/font-sfnt":       {".otf", ".ttf"},
+	"application/vnd.ms-opentype": {".otf"},
+	"application/font-woff":       {".woff"},
+	"application/javascript":      {".js"},
+	"text/javascript":             {".js"},
+	"application/smil+xml":        {".smil"},
+	"audio/mpeg":                  {".mp3"},
+	"audio/mp4":                   {".m4a", ".mp4"},
+}
+
+// magic are the first bytes of image files.
+var magic = map[string][]byte{
+	"image/png":  []byte("\x89PNG\r\n\x1a\n"),
+	"image/jpeg": []byte("\xff\xd8\xff"),
+	"image/gif":  []byte("GIF8"),
+}
+
+// checkMediaTypes checks that the media types of the manifest items
+// are core media types matching the file names and contents.
+func (c *checker) checkMediaTypes() {
+
+	for _, it := range c.items {
+
+		if it.path == "" || c.files[it.path] == nil {
+			continue
+		}
+
+		mt, _, err := mime.ParseMediaType(it.mediaType)
+		if err != nil {
+			c.errorf(c.opf, "manifest item %s has invalid media-type %q", it.id, it.mediaType)
+			continue
+		}
+
+		exts, ok := coreTypes[mt]
+		if !ok {
+			if it.fallback == "" {
+				c.warnf(it.path, "media-type %s is not a core media type and there is no fallback", mt)
+			}
+			continue
+		}
+
+		ext := strings.ToLower(path.Ext(it.path))
+		match := false
+		for _, e := range exts {
+			match = match || e == ext
+		}
+		if !match {
+			c.warnf(it.path, "file name extension %s does not match media-type %s", ext, mt)
+		}
+
+		if m, ok := magic[mt]; ok {
+			data, _ := c.read(it.path)
+			if !bytes.HasPrefix(data, m) {
+				c.errorf(it.path, "contents are not %s", mt)
+			}
+		}
+	}
+}
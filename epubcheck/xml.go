@@ -0,0 +1,142 @@
+package epubcheck
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strings"
+)
+
+const (
+	nsContainer = "urn:oasis:names:tc:opendocument:xmlns:container"
+	nsOPF       = "http://www.idpf.org/2007/opf"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsXHTML     = "http://www.w3.org/1999/xhtml"
+	nsOPS       = "http://www.idpf.org/2007/ops"
+	nsSVG       = "http://www.w3.org/2000/svg"
+	nsXLink     = "http://www.w3.org/1999/xlink"
+	nsXML       = "http://www.w3.org/XML/1998/namespace"
+	nsNCX       = "http://www.daisy.org/z3986/2005/ncx/"
+)
+
+// node is an element of an XML document.
+type node struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*node
+	parent   *node
+	text     string
+	line     int
+}
+
+// parseXML parses data as XML without tolerating any errors. Only the
+// entities predefined by XML are known, which is what EPUB requires
+// of XHTML documents.
+func parseXML(data []byte) (*node, error) {
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = true
+
+	var root, cur *node
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+
+		case xml.StartElement:
+			line, _ := d.InputPos()
+			n := &node{name: tok.Name, attrs: tok.Attr, parent: cur, line: line}
+			if cur == nil {
+				if root != nil {
+					return nil, &xml.SyntaxError{Msg: "more than one root element", Line: line}
+				}
+				root = n
+			} else {
+				cur.children = append(cur.children, n)
+			}
+			cur = n
+
+		case xml.EndElement:
+			cur = cur.parent
+
+		case xml.CharData:
+			if cur != nil {
+				cur.text += string(tok)
+			}
+		}
+	}
+
+	if root == nil {
+		return nil, &xml.SyntaxError{Msg: "no root element", Line: 1}
+	}
+
+	return root, nil
+}
+
+// attr returns the value of the attribute with the given namespace and
+// local name.
+func (n *node) attr(space, local string) string {
+
+	for _, a := range n.attrs {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value
+		}
+	}
+
+	return ""
+}
+
+// find returns the descendants of n with the given local name in
+// document order.
+func (n *node) find(local string) []*node {
+
+	var out []*node
+
+	n.walk(func(m *node) {
+		if m != n && m.name.Local == local {
+			out = append(out, m)
+		}
+	})
+
+	return out
+}
+
+// walk calls f for n and all its descendants in document order.
+func (n *node) walk(f func(*node)) {
+
+	f(n)
+
+	for _, c := range n.children {
+		c.walk(f)
+	}
+}
+
+// textContent returns the text inside n and its descendants.
+func (n *node) textContent() string {
+
+	w := new(strings.Builder)
+
+	var text func(m *node)
+	text = func(m *node) {
+		w.WriteString(m.text)
+		for _, c := range m.children {
+			text(c)
+		}
+	}
+
+	text(n)
+
+	return w.String()
+}
+
+func parseURL(ref string) (*url.URL, error) {
+	return url.Parse(strings.TrimSpace(ref))
+}
@@ -0,0 +1,21 @@
+// Package diag holds what the checkers of package epubcheck and
+// mobi/validate have in common: the severity of the problems they
+// report. Their Diagnostic types and HasErrors stay with each checker
+// since they locate problems differently, by file in an EPUB container
+// and by record in a Palm database.
+package diag
+
+// Severity tells how bad a problem is.
+type Severity int
+
+const (
+	Warning Severity = iota
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
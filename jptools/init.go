@@ -8,6 +8,9 @@ import (
 //Based on data published by Project X0213 (included in
 //data directory).
 
+//go:embed data/jisx0213-2004-std.txt
+var table string
+
 type jisuni struct {
 	jis string
 	uni string
@@ -22,4 +25,6 @@ func init() {
 		Utf8of[d.jis] = d.uni
 
 	}
+
+	readTable(table)
 }
@@ -0,0 +1,221 @@
+package jptools
+
+import (
+	"bufio"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// jisOfUni maps Unicode characters, including the combining sequences
+// of JIS X 0213, to JIS X 0213 code points. Fullwidth and Windows
+// (CP932) forms map to the same code points as the characters they
+// stand for.
+var jisOfUni map[string]string
+
+// in0208 holds the JIS X 0213 code points that are already in
+// JIS X 0208.
+var in0208 map[string]bool
+
+// readTable reads the mapping table of Project X0213 in the data
+// directory into jisOfUni and in0208.
+func readTable(table string) {
+
+	jisOfUni = make(map[string]string)
+	in0208 = make(map[string]bool)
+
+	var aliases [][2]string
+
+	sc := bufio.NewScanner(strings.NewReader(table))
+
+	for sc.Scan() {
+
+		// e.g. 3-2124	U+002C	# COMMA	Fullwidth: U+FF0C
+		f := strings.Split(sc.Text(), "\t")
+		if strings.HasPrefix(f[0], "#") || len(f) < 3 || f[1] == "" {
+			continue
+		}
+
+		jis := strings.ToLower(f[0])
+
+		jisOfUni[uniOfTable(f[1])] = jis
+
+		note := strings.Join(f[2:], "\t")
+
+		if !strings.Contains(note, "[2000]") && !strings.Contains(note, "[2004]") {
+			in0208[jis] = true
+		}
+
+		for _, alias := range []string{"Fullwidth: ", "Windows: "} {
+			if i := strings.Index(note, alias); i >= 0 {
+				u := strings.Fields(note[i+len(alias):])[0]
+				aliases = append(aliases, [2]string{uniOfTable(u), jis})
+			}
+		}
+	}
+
+	// the characters themselves come first
+	for _, a := range aliases {
+		if _, ok := jisOfUni[a[0]]; !ok {
+			jisOfUni[a[0]] = a[1]
+		}
+	}
+}
+
+// uniOfTable returns the characters written as U+304B or U+304B+309A
+// in the mapping table.
+func uniOfTable(u string) string {
+
+	var s []rune
+
+	for _, c := range strings.Split(strings.TrimPrefix(u, "U+"), "+") {
+		r, _ := strconv.ParseUint(c, 16, 32)
+		s = append(s, rune(r))
+	}
+
+	return string(s)
+}
+
+// JisOf returns the code point of r in JIS X 0213 as plane (面), row
+// (区), and cell (点), and the level (水準) of r if it is a kanji.
+// The level is 1 or 2 for the kanji of JIS X 0208, 3 for the other
+// kanji of plane 1, 4 for the kanji of plane 2, and 0 for characters
+// other than kanji. ok is false if r is not in JIS X 0213.
+func JisOf(r rune) (plane, row, cell int, level int, ok bool) {
+	return JisOfString(string(r))
+}
+
+// JisOfString is like JisOf for s, which is a single character or one
+// of the combining sequences of JIS X 0213 such as か゚.
+func JisOfString(s string) (plane, row, cell int, level int, ok bool) {
+
+	jis, ok := jisOfUni[s]
+	if !ok {
+		return
+	}
+
+	plane = 1
+	if strings.HasPrefix(jis, "4-") {
+		plane = 2
+	}
+
+	k, _ := strconv.ParseInt(jis[2:4], 16, 0)
+	t, _ := strconv.ParseInt(jis[4:6], 16, 0)
+
+	row, cell = int(k-32), int(t-32)
+
+	switch {
+	case plane == 2:
+		level = 4
+	case row < 14:
+		level = 0
+	case !in0208[jis]:
+		level = 3
+	case row < 48:
+		level = 1
+	default:
+		level = 2
+	}
+
+	return
+}
+
+// Mkt returns the code point of s in JIS X 0213 in the 面-区-点
+// (men-ku-ten) format as used by Aozora Bunko, e.g. "1-87-3", or ""
+// if s is not in JIS X 0213.
+func Mkt(s string) string {
+
+	plane, row, cell, _, ok := JisOfString(s)
+	if !ok {
+		return ""
+	}
+
+	return strconv.Itoa(plane) + "-" + strconv.Itoa(row) + "-" + strconv.Itoa(cell)
+}
+
+// LevelName returns the name of level as used in Aozora Bunko notes,
+// e.g. 第3水準, or "" for level 0.
+func LevelName(level int) string {
+
+	if level < 1 || level > 4 {
+		return ""
+	}
+
+	return "第" + strconv.Itoa(level) + "水準"
+}
+
+// Repertoire is a set of characters given by the highest level of
+// kanji in it.
+type Repertoire int
+
+// Define repertoires.
+const (
+	// Level1 is JIS X 0208 without the kanji of level 2.
+	Level1 Repertoire = iota + 1
+
+	// Level2 is JIS X 0208.
+	Level2
+
+	// Level3 is plane 1 of JIS X 0213.
+	Level3
+
+	// Level4 is JIS X 0213.
+	Level4
+
+	// JISX0208 and JISX0213 are the repertoires of the standards.
+	JISX0208 = Level2
+	JISX0213 = Level4
+)
+
+// Contains reports whether the character or combining sequence s is in
+// rp. ASCII characters, which Shift_JIS has as well, are in every
+// repertoire.
+func (rp Repertoire) Contains(s string) bool {
+
+	if len(s) == 1 && s[0] < utf8.RuneSelf {
+		return true
+	}
+
+	_, _, _, level, ok := JisOfString(s)
+	if !ok {
+		return false
+	}
+
+	if level > 0 {
+		return level <= int(rp)
+	}
+
+	// characters other than kanji are all in plane 1
+	return rp >= Level3 || in0208[jisOfUni[s]]
+}
+
+// Outside returns the characters of text that are not in rp, each once
+// in the order they first appear. Combining sequences of JIS X 0213
+// count as single characters.
+func (rp Repertoire) Outside(text string) (out []string) {
+
+	seen := make(map[string]bool)
+
+	r := []rune(text)
+
+	for i := 0; i < len(r); i++ {
+
+		c := string(r[i])
+
+		if i+1 < len(r) {
+			if _, ok := jisOfUni[string(r[i:i+2])]; ok {
+				c = string(r[i : i+2])
+				i++
+			}
+		}
+
+		if rp.Contains(c) || seen[c] {
+			continue
+		}
+
+		seen[c] = true
+		out = append(out, c)
+	}
+
+	return out
+}
@@ -0,0 +1,72 @@
+package jptools
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestJisOf(t *testing.T) {
+
+	tests := []struct {
+		in    string
+		mkt   string
+		level int
+	}{
+		{"亜", "1-16-1", 1},
+		{"弌", "1-48-1", 2},
+		{"凜", "1-84-5", 2},
+		{"漐", "1-87-3", 3},
+		{"俱", "1-14-1", 3},
+		{"𠂉", "2-1-1", 4},
+		{"あ", "1-4-2", 0},
+		{"，", "1-1-4", 0},
+		{"①", "1-13-1", 0},
+		{"か゚", "1-4-87", 0},
+		{"葛\U000e0100", "", 0},
+		{"😀", "", 0},
+	}
+
+	for _, tt := range tests {
+
+		_, _, _, level, ok := JisOfString(tt.in)
+
+		if m := Mkt(tt.in); m != tt.mkt || level != tt.level || ok != (tt.mkt != "") {
+			t.Errorf("%s: got %s level %d, want %s level %d", tt.in, m, level, tt.mkt, tt.level)
+		}
+	}
+
+	// every character converts back
+	for jis, s := range Utf8of {
+		if c, err := Convert(Mkt(s)); err != nil || c != s {
+			t.Errorf("%+q of %s converts back to %+q", s, jis, c)
+		}
+	}
+}
+
+func TestLevelName(t *testing.T) {
+
+	if LevelName(3) != "第3水準" || LevelName(0) != "" {
+		t.Errorf("got %s and %s", LevelName(3), LevelName(0))
+	}
+}
+
+func TestOutside(t *testing.T) {
+
+	text := "亜弌漐①か゚ABC、𠂉亜葛\U000e0100😀"
+
+	tests := []struct {
+		rp   Repertoire
+		want []string
+	}{
+		{Level1, []string{"弌", "漐", "①", "か゚", "𠂉", "\U000e0100", "😀"}},
+		{JISX0208, []string{"漐", "①", "か゚", "𠂉", "\U000e0100", "😀"}},
+		{Level3, []string{"𠂉", "\U000e0100", "😀"}},
+		{JISX0213, []string{"\U000e0100", "😀"}},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rp.Outside(text); !slices.Equal(got, tt.want) {
+			t.Errorf("level %d: got %q, want %q", tt.rp, got, tt.want)
+		}
+	}
+}
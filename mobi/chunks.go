@@ -1,5 +1,13 @@
 package mobi
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	r "github.com/adamay909/AozoraConvert/mobi/records"
+)
+
 // Chunks produces a list of chunks from one or more strings.
 //
 // In the resulting list of chunks, each chunk exactly corresponds the
@@ -15,6 +23,14 @@ func Chunks(list ...string) []Chunk {
 	return result
 }
 
+// PosLink returns a link to the position offset bytes into a chunk for
+// use in href attributes of the chunks. Chunks are numbered through
+// the whole book in the order of the chapters.
+func PosLink(chunk, offset int) string {
+	off := strings.ToUpper(strconv.FormatInt(int64(offset), 32))
+	return fmt.Sprintf("kindle:pos:fid:%s:off:%010v", r.To32(chunk), off)
+}
+
 func Split(s string) (list []string) {
 
 	var p []rune
@@ -0,0 +1,62 @@
+package mobi
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	r "github.com/adamay909/AozoraConvert/mobi/records"
+)
+
+// GuideEntry is a location in the book listed in its guide. Kindle
+// devices open books at the entry of type "text" and go there for "Go
+// to Beginning".
+type GuideEntry struct {
+	// Type is the type of the location as in the guide of OPF
+	// files, e.g. "cover", "title-page", "toc", or "text".
+	Type  string
+	Title string
+
+	// Chapter and Chunk are the indices of the chunk the location
+	// is at the start of.
+	Chapter, Chunk int
+}
+
+// ErrGuide is returned by Realize when a guide entry refers to a
+// chunk that does not exist.
+var ErrGuide = errors.New("mobi: guide entry refers to missing chunk")
+
+// guideInfo returns the guide entries of m sorted by type, as Kindle
+// devices look them up by type. Chunks are given by their index in
+// the whole book.
+func (m Book) guideInfo() ([]r.GuideInfo, error) {
+
+	var first []int
+
+	n := 0
+	for _, c := range m.Chapters {
+		first = append(first, n)
+		n += len(c.Chunks)
+	}
+
+	var info []r.GuideInfo
+
+	for _, g := range m.Guide {
+
+		if g.Chapter < 0 || g.Chapter >= len(m.Chapters) || g.Chunk < 0 || g.Chunk >= len(m.Chapters[g.Chapter].Chunks) {
+			return nil, fmt.Errorf("%w: %s at chapter %d, chunk %d", ErrGuide, g.Type, g.Chapter, g.Chunk)
+		}
+
+		info = append(info, r.GuideInfo{
+			Type:  g.Type,
+			Title: g.Title,
+			Chunk: first[g.Chapter] + g.Chunk,
+		})
+	}
+
+	sort.SliceStable(info, func(i, j int) bool {
+		return info[i].Type < info[j].Type
+	})
+
+	return info, nil
+}
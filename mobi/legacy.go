@@ -0,0 +1,277 @@
+package mobi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/adamay909/AozoraConvert/mobi/pdb"
+	t "github.com/adamay909/AozoraConvert/mobi/types"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// realizeLegacy returns the MOBI 6 version of m without image
+// records. Realize points its first image index to the image records
+// of the KF8 version, which come in the same order, so that the EXTH
+// cover offsets are valid for both.
+func (m Book) realizeLegacy() (pdb.Database, error) {
+	db := pdb.NewDatabase(m.Title, m.CreatedDate)
+
+	text := legacyHTML(m)
+
+	textRecords, err := textToRecords(text, nil, m.Compress)
+	if err != nil {
+		return db, err
+	}
+
+	// Null record
+	null := m.createNullRecord()
+	h := t.NewMOBIHeader()
+	h.UniqueID = null.MOBIHeader.UniqueID
+	h.Locale = null.MOBIHeader.Locale
+	null.MOBIHeader = t.KF8Header{MOBIHeader: h}
+	db.AddRecord(null)
+
+	// Text records
+	if m.Compress {
+		null.PalmDocHeader.Compression = t.CompressionPalmDoc
+	}
+	null.PalmDocHeader.TextRecordCount = uint16(len(textRecords))
+	null.PalmDocHeader.TextLength = uint32(len(text))
+	for _, rec := range textRecords {
+		db.AddRecord(rec)
+	}
+	null.MOBIHeader.FirstNonBookIndex = uint32(db.Idx() + 1)
+	null.MOBIHeader.FirstContentRecordNumberOrFDSTNumberMSB = 1
+	null.MOBIHeader.LastContentRecordNumberOrFDSTNumberLSB = uint16(db.Idx())
+
+	// FLIS Record
+	db.AddRecord(t.NewFLISRecord())
+	null.MOBIHeader.FLISRecordCount = 1
+	null.MOBIHeader.FLISRecordNumber = uint32(db.Idx())
+
+	// FCIS Record
+	db.AddRecord(t.NewFCISRecord(uint32(len(text))))
+	null.MOBIHeader.FCISRecordCount = 1
+	null.MOBIHeader.FCISRecordNumber = uint32(db.Idx())
+
+	db.ReplaceRecord(0, null)
+
+	return db, nil
+}
+
+// legacyTags are the elements kept in MOBI 6 html. Attributes are
+// dropped since MOBI 6 readers do not support CSS anyway.
+var legacyTags = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Br: true, atom.Hr: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Blockquote: true, atom.Ul: true, atom.Ol: true, atom.Li: true,
+	atom.Table: true, atom.Tr: true, atom.Td: true, atom.Th: true,
+	atom.B: true, atom.I: true, atom.Em: true, atom.Strong: true, atom.U: true,
+	atom.Sub: true, atom.Sup: true, atom.Small: true, atom.Big: true,
+}
+
+// legacyHTML converts the KF8 html of the chapters of m into html for
+// MOBI 6 readers. Top level chapters are separated by page breaks,
+// ruby is written as base text followed by the reading in
+// parentheses, and images refer to their record by recindex. The
+// guide refers to the start of chunks by their file position.
+func legacyHTML(m Book) string {
+
+	pos := make([][]int, len(m.Chapters))
+
+	// starts holds the position of each chunk in the order of
+	// the book, which is how links refer to them
+	var starts []int
+
+	body := func(filepos func(chunk, offset int) int) string {
+
+		w := new(strings.Builder)
+
+		starts = starts[:0]
+
+		for i, c := range m.Chapters {
+			if i > 0 && c.Depth == 0 {
+				w.WriteString("<mbp:pagebreak/>")
+			}
+			pos[i] = pos[i][:0]
+			for _, chunk := range c.Chunks {
+				pos[i] = append(pos[i], w.Len())
+				starts = append(starts, w.Len())
+				writeLegacy(w, chunk.Body, filepos)
+			}
+		}
+
+		return w.String()
+	}
+
+	// file positions have a fixed width, so the length of the text
+	// does not depend on them
+	body(func(int, int) int { return 0 })
+
+	guide := func(offset int) string {
+		g := new(strings.Builder)
+		for _, e := range m.Guide {
+			if e.Chapter < 0 || e.Chapter >= len(pos) || e.Chunk < 0 || e.Chunk >= len(pos[e.Chapter]) {
+				continue
+			}
+			fmt.Fprintf(g, `<reference type="%s" title="%s" filepos=%010d />`,
+				html.EscapeString(e.Type), html.EscapeString(e.Title), offset+pos[e.Chapter][e.Chunk])
+		}
+		return g.String()
+	}
+
+	head := "<html><head><guide>"
+	tail := "</guide></head><body>"
+
+	offset := len(head) + len(guide(0)) + len(tail)
+
+	// offsets into chunks refer to the KF8 html, which is longer
+	// than the MOBI 6 html, so links go to the start of the chunk
+	text := body(func(chunk, _ int) int {
+		if chunk < 0 || chunk >= len(starts) {
+			return 0
+		}
+		return offset + starts[chunk]
+	})
+
+	return head + guide(offset) + tail + text + "</body></html>"
+}
+
+func writeLegacy(w *strings.Builder, s string, filepos func(chunk, offset int) int) {
+
+	z := html.NewTokenizer(strings.NewReader(s))
+
+	skip := 0
+	rp := false
+
+	for {
+		tt := z.Next()
+
+		switch tt {
+
+		case html.ErrorToken:
+			return
+
+		case html.TextToken:
+			if skip == 0 {
+				w.WriteString(html.EscapeString(string(z.Text())))
+			}
+
+		case html.StartTagToken, html.EndTagToken, html.SelfClosingTagToken:
+
+			tok := z.Token()
+			start := tt != html.EndTagToken
+
+			switch tok.DataAtom {
+
+			case atom.Head, atom.Title, atom.Style, atom.Script:
+				switch {
+				case tt == html.StartTagToken:
+					skip++
+				case tt == html.EndTagToken && skip > 0:
+					skip--
+				}
+
+			case atom.Ruby:
+				rp = false
+
+			case atom.Rp:
+				rp = true
+
+			case atom.Rt:
+				// readers without rp get parentheses of their own
+				switch {
+				case rp:
+				case start:
+					w.WriteString("（")
+				default:
+					w.WriteString("）")
+				}
+
+			case atom.A:
+				switch tt {
+				case html.EndTagToken:
+					w.WriteString("</a>")
+				case html.StartTagToken:
+					if chunk, offset, ok := posLink(attr(tok, "href")); ok {
+						fmt.Fprintf(w, "<a filepos=%010d>", filepos(chunk, offset))
+					} else {
+						w.WriteString("<a>")
+					}
+				}
+
+			case atom.Img:
+				if n, ok := embedIndex(attr(tok, "src")); ok {
+					fmt.Fprintf(w, `<img recindex="%05d"/>`, n)
+				}
+
+			default:
+				if !legacyTags[tok.DataAtom] {
+					continue
+				}
+				switch tt {
+				case html.StartTagToken:
+					w.WriteString("<" + tok.Data + ">")
+				case html.EndTagToken:
+					w.WriteString("</" + tok.Data + ">")
+				default:
+					w.WriteString("<" + tok.Data + "/>")
+				}
+			}
+		}
+	}
+}
+
+// embedIndex returns the index of the image referred to by a
+// kindle:embed link.
+func embedIndex(src string) (int, bool) {
+
+	id, ok := strings.CutPrefix(src, "kindle:embed:")
+	if !ok {
+		return 0, false
+	}
+
+	id, _, _ = strings.Cut(id, "?")
+
+	n, err := strconv.ParseInt(id, 32, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return int(n), true
+}
+
+// posLink returns the chunk and offset a kindle:pos link refers to.
+func posLink(href string) (chunk, offset int, ok bool) {
+
+	href, ok = strings.CutPrefix(href, "kindle:pos:fid:")
+	if !ok {
+		return 0, 0, false
+	}
+
+	fid, off, ok := strings.Cut(href, ":off:")
+	if !ok {
+		return 0, 0, false
+	}
+
+	c, err1 := strconv.ParseInt(fid, 32, 32)
+	o, err2 := strconv.ParseInt(off, 32, 32)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+
+	return int(c), int(o), true
+}
+
+func attr(tok html.Token, key string) string {
+
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,99 @@
+package mobi
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/adamay909/AozoraConvert/mobi/pdb"
+)
+
+func TestJoint(t *testing.T) {
+
+	in := testBook()
+	in.Joint = true
+	in.Compress = true
+	in.Chapters = append(in.Chapters, Chapter{
+		Title: "三",
+		Chunks: Chunks(`<p class="x" style="y"><ruby><rb>吾輩</rb><rp>（</rp><rt>わがはい</rt><rp>）</rp></ruby>は` +
+			`<ruby>猫<rt>ねこ</rt></ruby>&amp;<img src="kindle:embed:0001?mime=image/png" alt=""/></p>` +
+			`<p><a href="` + PosLink(1, 0) + `">初め</a></p>`),
+	})
+
+	db, err := in.Realize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := db.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.Legacy == nil || f.Legacy.MOBIHeader.FileVersion != 6 || f.Null.MOBIHeader.FileVersion != 8 {
+		t.Fatal("not a joint file")
+	}
+
+	legacy := string(f.LegacyText)
+
+	for _, want := range []string{
+		"<mbp:pagebreak/>",
+		"<p>吾輩（わがはい）は猫（ねこ）&amp;<img recindex=\"00001\"/></p>",
+	} {
+		if !strings.Contains(legacy, want) {
+			t.Errorf("MOBI 6 text lacks %s", want)
+		}
+	}
+
+	var pos int
+	if _, err := fmt.Sscanf(legacy[strings.Index(legacy, "filepos=")+8:], "%d", &pos); err != nil {
+		t.Fatal("no guide in MOBI 6 text")
+	}
+	if !strings.HasPrefix(legacy[pos:], "<p>どこで生れたか") {
+		t.Errorf("guide refers to %.20q", legacy[pos:])
+	}
+
+	link := strings.Index(legacy, "<a filepos=")
+	if link < 0 {
+		t.Fatal("link not converted")
+	}
+	if _, err := fmt.Sscanf(legacy[link+11:], "%d", &pos); err != nil || !strings.HasPrefix(legacy[pos:], "<p>どこで生れたか") {
+		t.Errorf("link refers to %.20q", legacy[pos:])
+	}
+
+	if strings.Contains(legacy, "aid=") || strings.Contains(legacy, "<title>") {
+		t.Errorf("MOBI 6 text contains KF8 markup")
+	}
+
+	out := f.Book()
+
+	if out.Title != in.Title || len(out.Chapters) != 3 || out.Chapters[2].Chunks[0] != in.Chapters[2].Chunks[0] {
+		t.Errorf("KF8 part not read: %+v", out)
+	}
+
+	if len(out.Images) != 1 || out.CoverImage == nil {
+		t.Errorf("KF8 images not read")
+	}
+
+	// both parts use the same image records
+	first := int(f.Null.MOBIHeader.FirstImageIndex) + f.Boundary
+	if int(f.Legacy.MOBIHeader.FirstImageIndex) != first {
+		t.Errorf("MOBI 6 images start at record %d, KF8 images at %d", f.Legacy.MOBIHeader.FirstImageIndex, first)
+	}
+
+	images := 0
+	for _, rec := range f.Database.Records[:f.Boundary] {
+		if raw, ok := rec.(pdb.RawRecord); ok && imageExt(raw) != "" {
+			images++
+		}
+	}
+	if images > 0 {
+		t.Errorf("MOBI 6 part holds %d image records", images)
+	}
+}
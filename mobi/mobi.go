@@ -1,9 +1,11 @@
-// Package mobi implements writing KF8-style formatted MOBI and AZW3 books.
+// Package mobi implements writing and reading KF8-style formatted MOBI and AZW3 books.
 package mobi
 
 import (
+	"errors"
 	"fmt"
 	"image"
+	"math"
 	"strings"
 	"text/template"
 	"time"
@@ -22,11 +24,21 @@ import (
 // structure into a PalmDB database.  This database can then be
 // written out to any io.Writer.
 type Book struct {
-	Title         string
-	Authors       []string
-	Contributors  []string
-	Publisher     string
+	Title        string
+	Authors      []string
+	Contributors []string
+	Publisher    string
+
+	// Readings of title, authors, and publisher used by Kindle
+	// readers for sorting Japanese books.
+	TitleFurigana     string
+	AuthorsFurigana   []string
+	PublisherFurigana string
+
 	Subject       string
+	Source        string
+	Description   string
+	Rights        string
 	CreatedDate   time.Time
 	PublishedDate time.Time
 	DocType       string
@@ -35,6 +47,7 @@ type Book struct {
 	RightToLeft   bool
 	Vertical      bool
 	Chapters      []Chapter
+	Guide         []GuideEntry
 	CSSFlows      []string
 	Images        []r.ImageRecord
 	CoverImage    image.Image
@@ -42,16 +55,33 @@ type Book struct {
 	UniqueID      uint32
 	Html          string
 
+	// Compress turns on PalmDoc compression of the text, which
+	// makes books about half as large.
+	Compress bool
+
+	// Joint puts a MOBI 6 version of the book in front of the KF8
+	// version for older Kindle devices and apps that cannot read
+	// KF8. The MOBI 6 version is derived from the chapters and
+	// shows the text horizontally without styling.
+	Joint bool
+
 	// hidden
 	tpl *template.Template
 }
 
+// ErrTemplate is returned by Realize when the skeleton template
+// cannot be applied.
+var ErrTemplate = errors.New("mobi: cannot execute skeleton template")
+
+// ErrNoText is returned by Realize when the book has no text.
+var ErrNoText = errors.New("mobi: book has no text")
+
 // OverrideTemplate overrides the template used in order to generate
 // the skeleton section of a KF8 HTML chunk.
 //
 // During conversion to a PalmDB database, this template is passed the
 // internal inventory type.  If the template cannot successfully be
-// applied, the conversion will panic.
+// applied, the conversion fails with ErrTemplate.
 //
 // The skeleton section generally consists of a complete HTML document
 // including head and body, with the body tag expected to contain an
@@ -80,9 +110,14 @@ func (m Book) GetThumbFilename() string {
 }
 
 // Chapter represents a chapter in a MobiBook book.
+//
+// Depth is the level of the chapter in the table of contents. A
+// chapter with a depth greater than 0 is a sub-chapter of the closest
+// preceding chapter with a smaller depth.
 type Chapter struct {
 	Title  string
 	Chunks []Chunk
+	Depth  int
 	// Start  int
 	// Length int
 }
@@ -97,16 +132,80 @@ type Chunk struct {
 	Body string
 }
 
-// Realize converts a MobiBook to a PalmDB Database.
-func (m Book) Realize() pdb.Database {
+// Realize converts a MobiBook to a PalmDB Database. If m.Joint is
+// set, the database holds a MOBI 6 version of the book followed by
+// the KF8 version, which both use the image records of the KF8 part.
+func (m Book) Realize() (pdb.Database, error) {
+
+	if !m.Joint {
+		return m.realizeKF8()
+	}
+
+	kf8, err := m.realizeKF8()
+	if err != nil {
+		return kf8, err
+	}
+
+	db, err := m.realizeLegacy()
+	if err != nil {
+		return db, err
+	}
+
+	boundary := len(db.Records) + 1
+
+	// the MOBI 6 part shares the image records of the KF8 part
+	// rather than holding copies of them
+	null := db.Records[0].(r.NullRecord)
+	null.EXTHSection.AddInt(t.EXTHKF8Boundary, boundary)
+	if first := kf8.Records[0].(r.NullRecord).MOBIHeader.FirstImageIndex; first != math.MaxUint32 {
+		null.MOBIHeader.FirstImageIndex = uint32(boundary) + first
+		null.MOBIHeader.LastContentRecordNumberOrFDSTNumberLSB = uint16(boundary + int(first) + len(m.images()) - 1)
+	}
+	db.ReplaceRecord(0, null)
+
+	db.AddRecord(t.BoundaryRecord)
+	db.Records = append(db.Records, kf8.Records...)
+
+	return db, nil
+}
+
+// images returns the image records of m followed by the cover and
+// thumbnail images.
+func (m Book) images() []r.ImageRecord {
+
+	images := append([]r.ImageRecord(nil), m.Images...)
+	if m.CoverImage != nil {
+		images = append(images, r.ImageRecord{Img: m.CoverImage, Ext: ".jpg"})
+	}
+	if m.ThumbImage != nil {
+		images = append(images, r.ImageRecord{Img: m.ThumbImage, Ext: ".jpg"})
+	}
+
+	return images
+}
+
+func (m Book) realizeKF8() (pdb.Database, error) {
 	db := pdb.NewDatabase(m.Title, m.CreatedDate)
 	html, chunks, chaps, err := chaptersToText(m)
-	text := html + strings.Join(m.CSSFlows, "")
-	textRecords := textToRecords(text, chaps)
 
 	// Handle possible template errors
 	if err != nil {
-		panic(err)
+		return db, fmt.Errorf("%w: %w", ErrTemplate, err)
+	}
+
+	text := html + strings.Join(m.CSSFlows, "")
+	if len(text) == 0 {
+		return db, ErrNoText
+	}
+
+	guide, err := m.guideInfo()
+	if err != nil {
+		return db, err
+	}
+
+	textRecords, err := textToRecords(text, chaps, m.Compress)
+	if err != nil {
+		return db, err
 	}
 
 	// Null record
@@ -114,6 +213,9 @@ func (m Book) Realize() pdb.Database {
 	db.AddRecord(null)
 
 	// Text records
+	if m.Compress {
+		null.PalmDocHeader.Compression = t.CompressionPalmDoc
+	}
 	null.PalmDocHeader.TextRecordCount = uint16(len(textRecords))
 	null.PalmDocHeader.TextLength = uint32(len(text))
 	for _, rec := range textRecords {
@@ -148,13 +250,18 @@ func (m Book) Realize() pdb.Database {
 	db.AddRecord(ncx)
 	db.AddRecord(cncx)
 
-	// Image records
-	images := m.Images
-	if m.CoverImage != nil {
-		images = append(images, r.ImageRecord{Img: m.CoverImage, Ext: ".jpg"})
+	// Guide record
+	if len(guide) > 0 {
+		gr, cncx := r.GuideIndexRecord(guide)
+		gh := r.GuideHeaderIndexRecord(guide[len(guide)-1].Type, len(guide))
+		null.MOBIHeader.GuideIndex = uint32(db.AddRecord(gh))
+		db.AddRecord(gr)
+		db.AddRecord(cncx)
 	}
+
+	// Image records
+	images := m.images()
 	if m.ThumbImage != nil {
-		images = append(images, r.ImageRecord{Img: m.ThumbImage, Ext: ".jpg"})
 		db.AddRecord(r.ImageRecord{Img: m.ThumbImage, Ext: ".jpg"})
 	}
 	if len(images) > 0 {
@@ -187,7 +294,7 @@ func (m Book) Realize() pdb.Database {
 	db.AddRecord(t.EOFRecord)
 	db.ReplaceRecord(0, null)
 
-	return db
+	return db, nil
 }
 
 func (m Book) createNullRecord() r.NullRecord {
@@ -204,11 +311,17 @@ func (m Book) createNullRecord() r.NullRecord {
 	null.EXTHSection.AddString(t.EXTHAuthor, m.Authors...)
 	null.EXTHSection.AddString(t.EXTHContributor, m.Contributors...)
 	null.EXTHSection.AddString(t.EXTHPublisher, m.Publisher)
+	null.EXTHSection.AddString(t.EXTHTitleFurigana, m.TitleFurigana)
+	null.EXTHSection.AddString(t.EXTHCreatorFurigana, m.AuthorsFurigana...)
+	null.EXTHSection.AddString(t.EXTHPublisherFurigana, m.PublisherFurigana)
 	null.EXTHSection.AddString(t.EXTHSubject, m.Subject)
+	null.EXTHSection.AddString(t.EXTHSource, m.Source)
+	null.EXTHSection.AddString(t.EXTHDescription, m.Description)
+	null.EXTHSection.AddString(t.EXTHRights, m.Rights)
 	null.EXTHSection.AddString(t.EXTHASIN, encodeASIN(m.UniqueID))
 	null.EXTHSection.AddString(t.EXTHLanguage, lang.String())
 	if m.PublishedDate != (time.Time{}) {
-		dateString := m.PublishedDate.Format("2006-01-02T15:04:05.000000+07:00")
+		dateString := m.PublishedDate.Format("2006-01-02T15:04:05.000000-07:00")
 		null.EXTHSection.AddString(t.EXTHPublishingDate, dateString)
 	}
 	if len(m.DocType) > 0 {
@@ -0,0 +1,35 @@
+package mobi
+
+import (
+	"errors"
+	"testing"
+	"text/template"
+)
+
+func TestRealizeErrors(t *testing.T) {
+
+	broken := testBook()
+	broken.OverrideTemplate(*template.Must(template.New("skeleton").Parse(`{{.NoSuchField}}`)))
+
+	empty := testBook()
+	empty.Chapters = nil
+	empty.CSSFlows = nil
+
+	tests := []struct {
+		name string
+		book Book
+		want error
+	}{
+		{"template", broken, ErrTemplate},
+		{"no text", empty, ErrNoText},
+	}
+
+	for _, tt := range tests {
+		for _, joint := range []bool{false, true} {
+			tt.book.Joint = joint
+			if _, err := tt.book.Realize(); !errors.Is(err, tt.want) {
+				t.Errorf("%s (joint %v): got %v, want %v", tt.name, joint, err, tt.want)
+			}
+		}
+	}
+}
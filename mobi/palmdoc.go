@@ -0,0 +1,177 @@
+package mobi
+
+import (
+	"errors"
+)
+
+// ErrCorruptText is returned by PalmDocDecompress when its input is
+// not valid PalmDoc compressed data.
+var ErrCorruptText = errors.New("mobi: corrupt PalmDoc compressed text")
+
+const (
+	palmDocWindow   = 2047 // maximum distance of a back reference
+	palmDocMinMatch = 3
+	palmDocMaxMatch = 10
+)
+
+// PalmDocCompress compresses data using PalmDoc (LZ77) compression.
+// data should be at most one text record long; the compression
+// works on each record separately.
+//
+// The output consists of
+//
+//	0x00, 0x09-0x7f   the byte itself
+//	0x01-0x08         that many bytes copied verbatim
+//	0x80-0xbf         with the following byte, a back reference of
+//	                  3-10 bytes up to 2047 bytes back
+//	0xc0-0xff         a space followed by the byte xor 0x80
+func PalmDocCompress(data []byte) []byte {
+
+	out := make([]byte, 0, len(data))
+
+	// head holds the last position of each three byte sequence and
+	// prev the previous position of the sequence at each position.
+	head := make(map[[3]byte]int)
+	prev := make([]int, len(data))
+
+	insert := func(i int) {
+		if i+palmDocMinMatch > len(data) {
+			return
+		}
+		k := [3]byte{data[i], data[i+1], data[i+2]}
+		p, ok := head[k]
+		if !ok {
+			p = -1
+		}
+		prev[i] = p
+		head[k] = i
+	}
+
+	for i := 0; i < len(data); {
+
+		if dist, n := palmDocMatch(data, i, head, prev); n > 0 {
+			code := 0x8000 | dist<<3 | (n - palmDocMinMatch)
+			out = append(out, byte(code>>8), byte(code))
+			for j := i; j < i+n; j++ {
+				insert(j)
+			}
+			i += n
+			continue
+		}
+
+		c := data[i]
+		insert(i)
+		i++
+
+		switch {
+
+		case c == ' ' && i < len(data) && data[i] >= 0x40 && data[i] < 0x80:
+			out = append(out, data[i]^0x80)
+			insert(i)
+			i++
+
+		case isPalmDocLiteral(c):
+			out = append(out, c)
+
+		default:
+			// a run of up to eight bytes that need escaping
+			start := i - 1
+			for i < len(data) && i-start < 8 && !isPalmDocLiteral(data[i]) {
+				insert(i)
+				i++
+			}
+			out = append(out, byte(i-start))
+			out = append(out, data[start:i]...)
+		}
+	}
+
+	return out
+}
+
+// palmDocMatch returns the distance and length of the longest earlier
+// occurrence of the bytes at i within the window. The occurrence ends
+// before i. The length is 0 if there is no match of at least three
+// bytes.
+func palmDocMatch(data []byte, i int, head map[[3]byte]int, prev []int) (dist, n int) {
+
+	if i+palmDocMinMatch > len(data) {
+		return 0, 0
+	}
+
+	p, ok := head[[3]byte{data[i], data[i+1], data[i+2]}]
+	if !ok {
+		return 0, 0
+	}
+
+	for ; p >= 0 && i-p <= palmDocWindow; p = prev[p] {
+
+		limit := min(min(palmDocMaxMatch, i-p), len(data)-i)
+
+		l := 0
+		for l < limit && data[p+l] == data[i+l] {
+			l++
+		}
+
+		if l >= palmDocMinMatch && l > n {
+			dist, n = i-p, l
+			if n == palmDocMaxMatch {
+				break
+			}
+		}
+	}
+
+	return dist, n
+}
+
+func isPalmDocLiteral(c byte) bool {
+	return c == 0 || (c >= 0x09 && c < 0x80)
+}
+
+// PalmDocDecompress reverses PalmDocCompress. The error is
+// ErrCorruptText if data is truncated or contains back references
+// pointing before its start.
+func PalmDocDecompress(data []byte) ([]byte, error) {
+
+	out := make([]byte, 0, 2*len(data))
+
+	for i := 0; i < len(data); {
+
+		c := data[i]
+		i++
+
+		switch {
+
+		case isPalmDocLiteral(c):
+			out = append(out, c)
+
+		case c <= 0x08:
+			if i+int(c) > len(data) {
+				return out, ErrCorruptText
+			}
+			out = append(out, data[i:i+int(c)]...)
+			i += int(c)
+
+		case c < 0xc0:
+			if i >= len(data) {
+				return out, ErrCorruptText
+			}
+			code := int(c)<<8 | int(data[i])
+			i++
+			dist := code >> 3 & palmDocWindow
+			n := code&0x07 + palmDocMinMatch
+			if dist == 0 || dist > len(out) {
+				return out, ErrCorruptText
+			}
+			// byte by byte since the source may overlap the
+			// destination
+			for j := 0; j < n; j++ {
+				out = append(out, out[len(out)-dist])
+			}
+
+		default:
+			out = append(out, ' ', c^0x80)
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,109 @@
+package mobi
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	r "github.com/adamay909/AozoraConvert/mobi/records"
+)
+
+func TestPalmDocRoundTrip(t *testing.T) {
+
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(random)
+
+	tests := map[string]string{
+		"empty":     "",
+		"short":     "ab",
+		"ascii":     strings.Repeat("The quick brown fox jumps over the lazy dog. ", 92)[:4096],
+		"japanese":  strings.Repeat("<p>吾輩は猫である。名前はまだ無い。</p>\n", 100),
+		"spaces":    " a b c  @ ~ \x7f end ",
+		"escapes":   "\x00\x01\x02\x08\x09\x80\xff\xc0 \x81abc\x01\x01\x01\x01\x01\x01\x01\x01\x01\x01",
+		"overlap":   strings.Repeat("a", 100),
+		"random":    string(random),
+		"truncated": "猫である"[:5],
+	}
+
+	for name, in := range tests {
+
+		c := PalmDocCompress([]byte(in))
+
+		out, err := PalmDocDecompress(c)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+
+		if !bytes.Equal(out, []byte(in)) {
+			t.Errorf("%s: round trip gives %q", name, out)
+		}
+	}
+
+	c := PalmDocCompress([]byte(tests["japanese"]))
+	if len(c) > len(tests["japanese"])/2 {
+		t.Errorf("repetitive text compressed to %d of %d bytes", len(c), len(tests["japanese"]))
+	}
+}
+
+func TestPalmDocDecompressCorrupt(t *testing.T) {
+
+	for _, in := range []string{"\x03ab", "\x80", "a\x80\x10", "\x80\x08"} {
+		_, err := PalmDocDecompress([]byte(in))
+		if err != ErrCorruptText {
+			t.Errorf("%q: error %v", in, err)
+		}
+	}
+}
+
+func TestTextRecordsMultibyte(t *testing.T) {
+
+	// three byte characters never end at a multiple of 4096
+	text := strings.Repeat("青空文庫", 1000)
+
+	for _, compress := range []bool{false, true} {
+
+		recs, err := textToRecords(text, nil, compress)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []byte
+
+		for i, rec := range recs {
+
+			buf := new(bytes.Buffer)
+			if err := rec.Write(buf); err != nil {
+				t.Fatal(err)
+			}
+			data := buf.Bytes()
+
+			// indexing entry: its size is the last byte
+			data = data[:len(data)-int(data[len(data)-1]&0x7f)]
+
+			// multibyte entry
+			n := int(data[len(data)-1] & 0x03)
+			overlap := data[len(data)-1-n : len(data)-1]
+			data = data[:len(data)-1-n]
+
+			if compress {
+				data, err = PalmDocDecompress(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			end := (i + 1) * r.TextRecordMaxSize
+			if end < len(text) && !bytes.Equal(overlap, []byte(text[end:overlapEnd(text, end)])) {
+				t.Errorf("record %d: overlap % x", i, overlap)
+			}
+
+			got = append(got, data...)
+		}
+
+		if string(got) != text {
+			t.Errorf("compress %v: text does not survive", compress)
+		}
+	}
+}
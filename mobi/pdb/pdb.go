@@ -4,6 +4,7 @@ package pdb
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
 	"io/ioutil"
 	"time"
@@ -12,6 +13,10 @@ import (
 // Endian describes the byte-order of integers in Palm databases.
 var Endian = binary.BigEndian
 
+// ErrRecordOffset is returned by ReadDatabase when the record offsets
+// do not fit the data.
+var ErrRecordOffset = errors.New("pdb: invalid record offset")
+
 // Database represents an in-memory Palm database.
 type Database struct {
 	Name    string
@@ -123,18 +128,23 @@ func ReadDatabase(r io.Reader) (*Database, error) {
 	}
 
 	records := make([]Record, 0)
-	for i := 1; i < len(offsets); i++ {
-		curr := offsets[i].Offset
-		prev := offsets[i-1].Offset
+	for i := range offsets {
+		curr := uint32(len(data))
+		if i+1 < len(offsets) {
+			curr = offsets[i+1].Offset
+		}
+		prev := offsets[i].Offset
+		if prev > curr || curr > uint32(len(data)) {
+			return nil, ErrRecordOffset
+		}
 		records = append(records, RawRecord(data[prev:curr]))
 	}
-	last := offsets[len(offsets)-1].Offset
-	records = append(records, RawRecord(data[last:]))
 
 	name := trimZeroes(string(palmDBHeader.Name[:]))
 
 	return &Database{
 		Name:    name,
+		Date:    parsePalmTime(palmDBHeader.CreationTime),
 		Records: records,
 	}, nil
 }
@@ -13,3 +13,7 @@ func calculatePalmTime(t time.Time) uint32 {
 	delta := t.Sub(time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC))
 	return uint32(delta.Seconds())
 }
+
+func parsePalmTime(t uint32) time.Time {
+	return time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC).Add(time.Duration(t) * time.Second)
+}
@@ -0,0 +1,553 @@
+package mobi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adamay909/AozoraConvert/mobi/pdb"
+	r "github.com/adamay909/AozoraConvert/mobi/records"
+	t "github.com/adamay909/AozoraConvert/mobi/types"
+	"golang.org/x/text/language"
+)
+
+// ErrNotKF8 is returned by Read when the file is not a KF8 formatted
+// MOBI file.
+var ErrNotKF8 = errors.New("mobi: not a KF8 file")
+
+// ErrCompression is returned by Read when the text is compressed with
+// an unsupported method.
+var ErrCompression = errors.New("mobi: unsupported compression")
+
+// File is a KF8 formatted MOBI or AZW3 file decoded by Read. It keeps
+// the decoded records so that files can be inspected and validated;
+// use Book to get the book they represent.
+type File struct {
+	Database *pdb.Database
+	Null     r.NullRecord
+
+	// Legacy is the null record of the MOBI 6 part of joint files,
+	// LegacyText its text, and Boundary the index of the KF8 null
+	// record. Indices in the
+	// KF8 headers are relative to Boundary.
+	Legacy     *r.NullRecord
+	LegacyText []byte
+	Boundary   int
+
+	// Text is the uncompressed text of all text records. Flows is
+	// Text split into the flows given by the FDST record; the first
+	// flow is the html of the book.
+	Text  []byte
+	Flows []string
+
+	Skeletons []Skeleton
+	Fragments []Fragment
+	NCX       []NCXEntry
+	Guide     []GuideRef
+
+	// Images holds the records from the first image record on up to
+	// the FDST record. Records that are not images have an empty
+	// Ext.
+	Images []r.ImageRecord
+}
+
+// Skeleton is an entry of the skeleton index. The skeleton of a part
+// of the book is Text[Start:Start+Length], and its fragments are
+// inserted into it.
+type Skeleton struct {
+	Name          string
+	FragmentCount int
+	Start, Length int
+}
+
+// Fragment is an entry of the chunk (fragment) index. Its text is
+// Text[InsertPos:InsertPos+Length].
+type Fragment struct {
+	InsertPos  int
+	Selector   string
+	FileNumber int
+	Sequence   int
+	Start      int
+	Length     int
+}
+
+// NCXEntry is an entry of the NCX index. Parent, FirstChild and
+// LastChild are indices into File.NCX or -1.
+type NCXEntry struct {
+	Title                         string
+	Start, Length                 int
+	Depth                         int
+	Parent, FirstChild, LastChild int
+}
+
+// GuideRef is an entry of the guide index. Fragment is an index into
+// File.Fragments and Offset the position in the fragment.
+type GuideRef struct {
+	Type, Title      string
+	Fragment, Offset int
+}
+
+// Read decodes the KF8 file in rd.
+func Read(rd io.Reader) (*File, error) {
+
+	db, err := pdb.ReadDatabase(rd)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(db.Records) == 0 {
+		return nil, ErrNotKF8
+	}
+
+	f := &File{Database: db}
+
+	f.Null, err = r.ParseNullRecord(f.record(0))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrNotKF8, err)
+	}
+
+	if b, ok := f.Null.EXTHSection.Int(t.EXTHKF8Boundary); ok && f.Null.MOBIHeader.FileVersion < 8 {
+
+		if string(f.record(b-1)) != string(t.BoundaryRecord) {
+			return nil, fmt.Errorf("%w: no boundary record in front of record %d", ErrNotKF8, b)
+		}
+
+		legacy := f.Null
+		f.Legacy = &legacy
+		f.Boundary = b
+
+		f.Null, err = r.ParseNullRecord(f.record(0))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrNotKF8, err)
+		}
+	}
+
+	if f.Null.MOBIHeader.FileVersion < 8 {
+		return nil, ErrNotKF8
+	}
+
+	err = f.readText()
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.readFlows()
+	if err != nil {
+		return nil, err
+	}
+
+	err = f.readIndices()
+	if err != nil {
+		return nil, err
+	}
+
+	f.readImages()
+
+	return f, nil
+}
+
+// record returns the record with index i relative to the KF8 null
+// record.
+func (f *File) record(i int) []byte {
+
+	i += f.Boundary
+
+	if i < 0 || i >= len(f.Database.Records) {
+		return nil
+	}
+
+	raw, _ := f.Database.Records[i].(pdb.RawRecord)
+
+	return raw
+}
+
+func (f *File) readText() (err error) {
+
+	f.Text, err = textOf(f.Null, f.record)
+	if err != nil {
+		return err
+	}
+
+	if f.Legacy != nil {
+		f.LegacyText, err = textOf(*f.Legacy, func(i int) []byte {
+			return f.record(i - f.Boundary)
+		})
+	}
+
+	return err
+}
+
+// textOf returns the uncompressed text of the text records following
+// null.
+func textOf(null r.NullRecord, record func(int) []byte) ([]byte, error) {
+
+	h := null.PalmDocHeader
+
+	text := new(bytes.Buffer)
+
+	for i := 1; i <= int(h.TextRecordCount); i++ {
+
+		data, err := r.StripTrailingData(record(i), null.MOBIHeader.ExtraRecordDataFlags)
+		if err != nil {
+			return nil, fmt.Errorf("text record %d: %w", i, err)
+		}
+
+		switch h.Compression {
+
+		case t.CompressionNone:
+
+		case t.CompressionPalmDoc:
+			data, err = PalmDocDecompress(data)
+			if err != nil {
+				return nil, fmt.Errorf("text record %d: %w", i, err)
+			}
+
+		default:
+			return nil, ErrCompression
+		}
+
+		text.Write(data)
+	}
+
+	return text.Bytes(), nil
+}
+
+func (f *File) readFlows() error {
+
+	h := f.Null.MOBIHeader
+
+	idx := int(h.FirstContentRecordNumberOrFDSTNumberMSB)<<16 | int(h.LastContentRecordNumberOrFDSTNumberLSB)
+
+	if h.FirstContentRecordNumberOrFDSTNumberMSB == math.MaxUint16 || idx == 0 {
+		f.Flows = []string{string(f.Text)}
+		return nil
+	}
+
+	entries, err := r.ParseFDSTRecord(f.record(idx))
+	if err != nil {
+		return fmt.Errorf("FDST record %d: %w", idx, err)
+	}
+
+	for _, e := range entries {
+		if e.Start > e.End || int(e.End) > len(f.Text) {
+			return fmt.Errorf("FDST record %d: %w", idx, r.ErrFormat)
+		}
+		f.Flows = append(f.Flows, string(f.Text[e.Start:e.End]))
+	}
+
+	return nil
+}
+
+// readIndex returns the entries of the index whose header is record
+// idx together with its CNCX strings.
+func (f *File) readIndex(idx uint32) ([]r.IndexEntry, map[int]string, error) {
+
+	if idx == math.MaxUint32 {
+		return nil, nil, nil
+	}
+
+	h, tagx, cbs, err := r.ParseIndexHeader(f.record(int(idx)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("INDX record %d: %w", idx, err)
+	}
+
+	var entries []r.IndexEntry
+
+	n := int(h.IndexRecordCount)
+
+	for i := 1; i <= n; i++ {
+		e, err := r.ParseIndexEntries(f.record(int(idx)+i), tagx, cbs)
+		if err != nil {
+			return nil, nil, fmt.Errorf("INDX record %d: %w", int(idx)+i, err)
+		}
+		entries = append(entries, e...)
+	}
+
+	var cncx [][]byte
+	for i := 0; i < int(h.CNCXCount); i++ {
+		cncx = append(cncx, f.record(int(idx)+n+1+i))
+	}
+
+	return entries, r.ParseCNCX(cncx), nil
+}
+
+func (f *File) readIndices() error {
+
+	h := f.Null.MOBIHeader
+
+	entries, _, err := f.readIndex(h.SkeletonIndex)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		f.Skeletons = append(f.Skeletons, Skeleton{
+			Name:          e.Label,
+			FragmentCount: tagValue(e, 1, 0, 0),
+			Start:         tagValue(e, 6, 0, 0),
+			Length:        tagValue(e, 6, 1, 0),
+		})
+	}
+
+	entries, cncx, err := f.readIndex(h.ChunkIndex)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		pos, _ := strconv.Atoi(e.Label)
+		f.Fragments = append(f.Fragments, Fragment{
+			InsertPos:  pos,
+			Selector:   cncx[tagValue(e, 2, 0, 0)],
+			FileNumber: tagValue(e, 3, 0, 0),
+			Sequence:   tagValue(e, 4, 0, 0),
+			Start:      tagValue(e, 6, 0, 0),
+			Length:     tagValue(e, 6, 1, 0),
+		})
+	}
+
+	entries, cncx, err = f.readIndex(h.INDXRecordOffset)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		f.NCX = append(f.NCX, NCXEntry{
+			Title:      cncx[tagValue(e, 3, 0, 0)],
+			Start:      tagValue(e, 1, 0, 0),
+			Length:     tagValue(e, 2, 0, 0),
+			Depth:      tagValue(e, 4, 0, 0),
+			Parent:     tagValue(e, 21, 0, -1),
+			FirstChild: tagValue(e, 22, 0, -1),
+			LastChild:  tagValue(e, 23, 0, -1),
+		})
+	}
+
+	entries, cncx, err = f.readIndex(h.GuideIndex)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		f.Guide = append(f.Guide, GuideRef{
+			Type:     e.Label,
+			Title:    cncx[tagValue(e, 1, 0, 0)],
+			Fragment: tagValue(e, 6, 0, 0),
+			Offset:   tagValue(e, 6, 1, 0),
+		})
+	}
+
+	return nil
+}
+
+// tagValue returns the i-th value of tag in e, or def if there is
+// none.
+func tagValue(e r.IndexEntry, tag byte, i, def int) int {
+
+	vals := e.Tags[tag]
+
+	if i >= len(vals) {
+		return def
+	}
+
+	return vals[i]
+}
+
+func (f *File) readImages() {
+
+	if f.Null.MOBIHeader.FirstImageIndex == math.MaxUint32 || f.Null.MOBIHeader.FirstImageIndex == 0 {
+		return
+	}
+
+	first := int(f.Null.MOBIHeader.FirstImageIndex)
+
+	for i := first; i < len(f.Database.Records)-f.Boundary; i++ {
+
+		data := f.record(i)
+
+		if isNonBookRecord(data) {
+			break
+		}
+
+		f.Images = append(f.Images, r.ImageRecord{Data: data, Ext: imageExt(data)})
+	}
+}
+
+// isNonBookRecord reports whether data is one of the records that
+// follow the images.
+func isNonBookRecord(data []byte) bool {
+
+	for _, magic := range []string{"FDST", "FLIS", "FCIS", "DATP", "SRCS", "CMET", "BOUNDARY", "\xe9\x8e\x0d\x0a"} {
+		if bytes.HasPrefix(data, []byte(magic)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func imageExt(data []byte) string {
+
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return ".jpg"
+	case bytes.HasPrefix(data, []byte("\x89PNG")):
+		return ".png"
+	case bytes.HasPrefix(data, []byte("GIF8")):
+		return ".gif"
+	default:
+		return ""
+	}
+}
+
+// EXTH returns the values of all EXTH entries of type tp as strings.
+func (f *File) EXTH(tp t.EXTHEntryType) []string {
+	return f.Null.EXTHSection.Strings(tp)
+}
+
+func (f *File) exthString(tp t.EXTHEntryType) string {
+	return strings.Join(f.EXTH(tp), "")
+}
+
+// Book returns the book represented by f. Chapters are taken from the
+// entries of the NCX and contain the fragments inserted into them;
+// CSSFlows are the flows after the first one.
+func (f *File) Book() Book {
+
+	exth := f.Null.EXTHSection
+
+	m := Book{
+		Title:             f.exthString(t.EXTHUpdatedTitle),
+		Authors:           f.EXTH(t.EXTHAuthor),
+		Contributors:      f.EXTH(t.EXTHContributor),
+		Publisher:         f.exthString(t.EXTHPublisher),
+		TitleFurigana:     f.exthString(t.EXTHTitleFurigana),
+		AuthorsFurigana:   f.EXTH(t.EXTHCreatorFurigana),
+		PublisherFurigana: f.exthString(t.EXTHPublisherFurigana),
+		Subject:           f.exthString(t.EXTHSubject),
+		Source:            f.exthString(t.EXTHSource),
+		Description:       f.exthString(t.EXTHDescription),
+		Rights:            f.exthString(t.EXTHRights),
+		CreatedDate:       f.Database.Date,
+		DocType:           f.exthString(t.EXTHDocType),
+		FixedLayout:       f.exthString(t.EXTHFixedLayout) == "true",
+		RightToLeft:       f.exthString(t.EXTHPageProgressionDirection) == "rtl",
+		Vertical:          strings.HasPrefix(f.exthString(t.EXTHPrimaryWritingMode), "vertical"),
+		UniqueID:          f.Null.MOBIHeader.UniqueID,
+		Compress:          f.Null.PalmDocHeader.Compression == t.CompressionPalmDoc,
+	}
+
+	if m.Title == "" {
+		m.Title = f.Null.FullName
+	}
+
+	if d, err := time.Parse("2006-01-02T15:04:05.999999999Z07:00", f.exthString(t.EXTHPublishingDate)); err == nil {
+		m.PublishedDate = d
+	}
+
+	if l, err := language.Parse(f.exthString(t.EXTHLanguage)); err == nil {
+		m.Language = l
+	}
+
+	if len(f.Flows) > 0 {
+		m.Html = f.Flows[0]
+		m.CSSFlows = f.Flows[1:]
+	}
+
+	var where map[int]GuideEntry
+	m.Chapters, where = f.chapters()
+
+	for _, g := range f.Guide {
+		if e, ok := where[g.Fragment]; ok {
+			e.Type, e.Title = g.Type, g.Title
+			m.Guide = append(m.Guide, e)
+		}
+	}
+
+	m.Images = f.Images
+
+	cover, hasCover := exth.Int(t.EXTHCoverOffset)
+	thumb, hasThumb := exth.Int(t.EXTHThumbOffset)
+
+	// images are stored with cover and thumbnail appended
+	switch {
+	case hasCover && hasThumb && thumb == len(f.Images)-1 && cover == thumb-1:
+		m.Images = f.Images[:cover]
+	case hasCover && cover == len(f.Images)-1:
+		m.Images = f.Images[:cover]
+	}
+
+	if hasCover {
+		m.CoverImage = f.image(cover)
+	}
+
+	if hasThumb {
+		m.ThumbImage = f.image(thumb)
+	}
+
+	return m
+}
+
+// chapters returns the entries of the NCX in the order of the text as
+// chapters containing the fragments inserted between the start of the
+// entry and the start of the next one. The map gives the chapter and
+// chunk of each fragment.
+func (f *File) chapters() ([]Chapter, map[int]GuideEntry) {
+
+	entries := append([]NCXEntry(nil), f.NCX...)
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Start < entries[j].Start
+	})
+
+	var chaps []Chapter
+	where := make(map[int]GuideEntry)
+
+	for i, e := range entries {
+
+		end := e.Start + e.Length
+		if i+1 < len(entries) {
+			end = entries[i+1].Start
+		}
+
+		c := Chapter{Title: e.Title, Depth: e.Depth}
+
+		for k, fr := range f.Fragments {
+			if fr.InsertPos < e.Start || fr.InsertPos >= end || fr.InsertPos+fr.Length > len(f.Text) {
+				continue
+			}
+			where[k] = GuideEntry{Chapter: len(chaps), Chunk: len(c.Chunks)}
+			c.Chunks = append(c.Chunks, Chunk{Body: string(f.Text[fr.InsertPos : fr.InsertPos+fr.Length])})
+		}
+
+		chaps = append(chaps, c)
+	}
+
+	return chaps, where
+}
+
+// image decodes the image with index i relative to the first image
+// record.
+func (f *File) image(i int) image.Image {
+
+	if i < 0 || i >= len(f.Images) {
+		return nil
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(f.Images[i].Data))
+	if err != nil {
+		return nil
+	}
+
+	return img
+}
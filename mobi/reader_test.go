@@ -0,0 +1,231 @@
+package mobi
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	r "github.com/adamay909/AozoraConvert/mobi/records"
+	"golang.org/x/text/language"
+)
+
+func testBook() Book {
+
+	cover := image.NewGray(image.Rect(0, 0, 60, 80))
+	for i := range cover.Pix {
+		cover.Pix[i] = uint8(i)
+	}
+
+	pic := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	pic.Set(1, 1, color.RGBA{255, 0, 0, 255})
+
+	return Book{
+		Title:           "吾輩は猫である",
+		TitleFurigana:   "ワガハイハネコデアル",
+		Authors:         []string{"夏目漱石"},
+		AuthorsFurigana: []string{"ナツメソウセキ"},
+		Contributors:    []string{"入力：小林繁雄"},
+		Publisher:       "青空文庫",
+		Subject:         "913",
+		Rights:          "Public domain",
+		CreatedDate:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		PublishedDate:   time.Date(1905, 1, 1, 0, 0, 0, 0, time.UTC),
+		DocType:         "EBOK",
+		Language:        language.Japanese,
+		Vertical:        true,
+		RightToLeft:     true,
+		UniqueID:        0x12345678,
+		CSSFlows:        []string{"body { writing-mode: vertical-rl; }"},
+		CoverImage:      cover,
+		ThumbImage:      cover,
+		Images:          []r.ImageRecord{{Img: pic, Ext: ".png"}},
+		Guide:           []GuideEntry{{Type: "text", Title: "本文", Chapter: 1, Chunk: 0}},
+		Chapters: []Chapter{
+			{Title: "一", Chunks: Chunks(strings.Repeat("<p>吾輩は猫である。名前はまだ無い。</p>", 300))},
+			{Title: "二", Chunks: Chunks("<p>どこで生れたかとんと見当がつかぬ。</p></body></html>")},
+		},
+	}
+}
+
+func TestReadRoundTrip(t *testing.T) {
+
+	for _, compress := range []bool{false, true} {
+
+		in := testBook()
+		in.Compress = compress
+
+		db, err := in.Realize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := new(bytes.Buffer)
+		if err := db.Write(buf); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := Read(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("compress %v: %v", compress, err)
+		}
+
+		out := f.Book()
+
+		if out.Title != in.Title || out.TitleFurigana != in.TitleFurigana || out.Publisher != in.Publisher ||
+			out.Subject != in.Subject || out.Rights != in.Rights || out.DocType != in.DocType {
+			t.Errorf("metadata: %+v", out)
+		}
+
+		if !reflect.DeepEqual(out.Authors, in.Authors) || !reflect.DeepEqual(out.AuthorsFurigana, in.AuthorsFurigana) ||
+			!reflect.DeepEqual(out.Contributors, in.Contributors) {
+			t.Errorf("people: %v %v %v", out.Authors, out.AuthorsFurigana, out.Contributors)
+		}
+
+		if !out.CreatedDate.Equal(in.CreatedDate) || !out.PublishedDate.Equal(in.PublishedDate) {
+			t.Errorf("dates: %v %v", out.CreatedDate, out.PublishedDate)
+		}
+
+		if out.Language != in.Language || !out.Vertical || !out.RightToLeft || out.FixedLayout ||
+			out.UniqueID != in.UniqueID || out.Compress != compress {
+			t.Errorf("flags: %+v", out)
+		}
+
+		if !reflect.DeepEqual(out.CSSFlows, in.CSSFlows) {
+			t.Errorf("css flows: %q", out.CSSFlows)
+		}
+
+		if len(out.Chapters) != len(in.Chapters) {
+			t.Fatalf("%d chapters", len(out.Chapters))
+		}
+
+		for i, c := range in.Chapters {
+			if out.Chapters[i].Title != c.Title || !reflect.DeepEqual(out.Chapters[i].Chunks, c.Chunks) {
+				t.Errorf("chapter %d: %+v", i, out.Chapters[i])
+			}
+		}
+
+		if len(f.Skeletons) != len(f.Fragments) || f.Fragments[0].Selector != "P-//*[@aid='0000']" {
+			t.Errorf("skeletons %v, fragments %v", f.Skeletons, f.Fragments)
+		}
+
+		if !reflect.DeepEqual(out.Guide, in.Guide) {
+			t.Errorf("guide: %+v", out.Guide)
+		}
+
+		if len(out.Images) != 1 || out.Images[0].Ext != ".png" {
+			t.Errorf("images: %v", out.Images)
+		}
+
+		if out.CoverImage == nil || out.CoverImage.Bounds() != in.CoverImage.Bounds() || out.ThumbImage == nil {
+			t.Errorf("cover not read")
+		}
+
+		// writing the book read gives the same file
+		db2, err := out.Realize()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf2 := new(bytes.Buffer)
+		if err := db2.Write(buf2); err != nil {
+			t.Fatal(err)
+		}
+
+		f2, err := Read(bytes.NewReader(buf2.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(f.Text, f2.Text) {
+			t.Errorf("compress %v: text changes when written again", compress)
+		}
+	}
+}
+
+func TestGuideMissingChunk(t *testing.T) {
+
+	in := testBook()
+	in.Guide = []GuideEntry{{Type: "text", Chapter: 1, Chunk: 1}}
+
+	if _, err := in.Realize(); !errors.Is(err, ErrGuide) {
+		t.Errorf("got %v, want ErrGuide", err)
+	}
+}
+
+func TestReadNotKF8(t *testing.T) {
+
+	_, err := Read(strings.NewReader(strings.Repeat("x", 100)))
+	if err == nil {
+		t.Error("no error for garbage")
+	}
+}
+
+func TestReadNCXHierarchy(t *testing.T) {
+
+	in := testBook()
+	in.Guide = nil
+	in.Chapters = []Chapter{
+		{Title: "第一部", Chunks: Chunks("<h3>第一部</h3>")},
+		{Title: "第一章", Chunks: Chunks("<h4>第一章</h4><p>本文。</p>"), Depth: 1},
+		{Title: "第一節", Chunks: Chunks("<h5>第一節</h5><p>本文。</p>"), Depth: 2},
+		{Title: "第二章", Chunks: Chunks("<h4>第二章</h4><p>本文。</p>"), Depth: 1},
+		{Title: "第二部", Chunks: Chunks("<h3>第二部</h3>", "<p>本文。</p>")},
+		{Title: "第三章", Chunks: Chunks("<h4>第三章</h4><p>本文。</p>"), Depth: 1},
+	}
+
+	db, err := in.Realize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := db.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := Read(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// entries are ordered by depth, then by position
+	want := []struct {
+		title                         string
+		depth                         int
+		parent, firstChild, lastChild int
+	}{
+		{"第一部", 0, -1, 2, 3},
+		{"第二部", 0, -1, 4, 4},
+		{"第一章", 1, 0, 5, 5},
+		{"第二章", 1, 0, -1, -1},
+		{"第三章", 1, 1, -1, -1},
+		{"第一節", 2, 2, -1, -1},
+	}
+
+	if len(f.NCX) != len(want) {
+		t.Fatalf("%d NCX entries", len(f.NCX))
+	}
+
+	for i, w := range want {
+		e := f.NCX[i]
+		if e.Title != w.title || e.Depth != w.depth || e.Parent != w.parent || e.FirstChild != w.firstChild || e.LastChild != w.lastChild {
+			t.Errorf("entry %d: %+v", i, e)
+		}
+	}
+
+	// parts cover their chapters
+	if f.NCX[0].Start+f.NCX[0].Length != f.NCX[1].Start || f.NCX[2].Start+f.NCX[2].Length != f.NCX[3].Start {
+		t.Errorf("lengths do not cover sub-chapters: %+v", f.NCX)
+	}
+
+	out := f.Book()
+
+	if !reflect.DeepEqual(out.Chapters, in.Chapters) {
+		t.Errorf("chapters: %+v", out.Chapters)
+	}
+}
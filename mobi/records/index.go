@@ -2,19 +2,37 @@ package records
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/adamay909/AozoraConvert/mobi/pdb"
 	t "github.com/adamay909/AozoraConvert/mobi/types"
 )
 
 func NCXHeaderIndexRecord(entryCount int) IndexRecord {
-	bs := encodeINDXString(fmt.Sprintf("%03v", entryCount-1))
+	bs := encodeINDXString(fmt.Sprintf("%03X", entryCount-1))
 	pad := make([]byte, 5)
 	pdb.Endian.PutUint16(pad, uint16(entryCount))
 	bs = append(bs, pad...)
 
 	return IndexRecord{
-		TAGXTable:     t.TAGXTableNCXSingle,
+		TAGXTable:     t.TAGXTableNCX,
+		Type:          2,
+		IDXTEntries:   [][]byte{bs},
+		SubEntryCount: uint32(entryCount),
+		CNCXCount:     1,
+	}
+}
+
+// GuideHeaderIndexRecord returns the header record of the guide
+// index with entryCount entries, the last of which has type last.
+func GuideHeaderIndexRecord(last string, entryCount int) IndexRecord {
+	bs := encodeINDXString(last)
+	pad := make([]byte, 5)
+	pdb.Endian.PutUint16(pad, uint16(entryCount))
+	bs = append(bs, pad...)
+
+	return IndexRecord{
+		TAGXTable:     t.TAGXTableGuide,
 		Type:          2,
 		IDXTEntries:   [][]byte{bs},
 		SubEntryCount: uint32(entryCount),
@@ -51,37 +69,160 @@ func ChunkHeaderIndexRecord(lastPos int, entryCount int) IndexRecord {
 	}
 }
 
+// NCXIndexRecord returns the NCX index of the chapters in info and
+// the CNCX record holding their titles. Sub-chapters follow their
+// parent in info and have a greater depth. Kindle devices expect the
+// entries ordered by depth and then by position, so the entries are
+// reordered accordingly and linked by their parent and child tags.
+// Each entry covers the text of its sub-chapters.
 func NCXIndexRecord(info []ChapterInfo) (IndexRecord, CNCXRecord) {
 	idxtEntries := make([][]byte, 0)
 	cncxEntries := make([][]byte, 0)
 	cncxOffset := 0
-	for _, chap := range info {
+	for i, e := range ncxEntries(info) {
 		// CNCX entries
-		s := fmt.Sprintf(chap.Title)
-		cncx := encodeCNCXString(s)
+		cncx := encodeCNCXString(e.Title)
+		cncxEntries = append(cncxEntries, cncx)
+
+		cb := t.CBNCXSingle
+		values := []interface{}{
+			encodeVwi(e.Start),    // Record offset
+			encodeVwi(e.length),   // Length of a record
+			encodeVwi(cncxOffset), // Label offset relative to CNXC record
+			encodeVwi(e.Depth),    // Depth
+		}
+		if e.parent >= 0 {
+			cb |= tagMask(t.TAGXTagEntryParent)
+			values = append(values, encodeVwi(e.parent))
+		}
+		if e.firstChild >= 0 {
+			cb |= tagMask(t.TAGXTagEntryChild1) | tagMask(t.TAGXTagEntryChildN)
+			values = append(values, encodeVwi(e.firstChild), encodeVwi(e.lastChild))
+		}
+
+		label := encodeINDXString(fmt.Sprintf("%03X", i))
+		bs := bytesSequential(pdb.Endian, append([]interface{}{label, cb}, values...)...)
+		idxtEntries = append(idxtEntries, bs)
+		cncxOffset += len(cncx)
+	}
+
+	return IndexRecord{
+		Type:          0,
+		HeaderType:    1,
+		IDXTEntries:   idxtEntries,
+		SubEntryCount: 0,
+	}, CNCXRecord{
+		entries: cncxEntries,
+	}
+}
+
+// ncxEntry is a chapter together with its place in the NCX index.
+// Parent and children are given by their position in the index or -1.
+type ncxEntry struct {
+	ChapterInfo
+	length                        int
+	parent, firstChild, lastChild int
+}
+
+// ncxEntries returns the NCX entries of the chapters in info in the
+// order they appear in the index. Depths are normalized so that a
+// sub-chapter is exactly one level below its parent.
+func ncxEntries(info []ChapterInfo) []ncxEntry {
+	entries := make([]ncxEntry, len(info))
+	parents := make([]int, len(info))
+
+	var open []int
+	for i, c := range info {
+		for len(open) > 0 && info[open[len(open)-1]].Depth >= c.Depth {
+			open = open[:len(open)-1]
+		}
+		parents[i] = -1
+		if len(open) > 0 {
+			parents[i] = open[len(open)-1]
+		}
+		entries[i] = ncxEntry{ChapterInfo: c, firstChild: -1, lastChild: -1}
+		entries[i].Depth = len(open)
+		open = append(open, i)
+	}
+
+	// an entry ends where the next entry that is not one of its
+	// descendants starts
+	for i := range entries {
+		end := info[len(info)-1].Start + info[len(info)-1].Length
+		for j := i + 1; j < len(entries); j++ {
+			if entries[j].Depth <= entries[i].Depth {
+				end = entries[j].Start
+				break
+			}
+		}
+		entries[i].length = end - entries[i].Start
+	}
+
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return entries[order[a]].Depth < entries[order[b]].Depth
+	})
+
+	pos := make([]int, len(entries))
+	for k, i := range order {
+		pos[i] = k
+	}
+
+	for i, p := range parents {
+		entries[i].parent = -1
+		if p < 0 {
+			continue
+		}
+		entries[i].parent = pos[p]
+		if entries[p].firstChild < 0 || pos[i] < entries[p].firstChild {
+			entries[p].firstChild = pos[i]
+		}
+		entries[p].lastChild = max(entries[p].lastChild, pos[i])
+	}
+
+	sorted := make([]ncxEntry, len(entries))
+	for i, e := range entries {
+		sorted[pos[i]] = e
+	}
+
+	return sorted
+}
+
+// GuideIndexRecord returns the guide index of the entries in info,
+// which must be sorted by type, and the CNCX record holding their
+// titles. Entries refer to the start of their chunk.
+func GuideIndexRecord(info []GuideInfo) (IndexRecord, CNCXRecord) {
+	idxtEntries := make([][]byte, 0)
+	cncxEntries := make([][]byte, 0)
+	cncxOffset := 0
+	for _, g := range info {
+		// CNCX entries
+		cncx := encodeCNCXString(g.Title)
 		cncxEntries = append(cncxEntries, cncx)
 
-		label := encodeINDXString(fmt.Sprintf("%03x", chap.Start))
+		label := encodeINDXString(g.Type)
 		bs := bytesSequential(pdb.Endian,
 			label,
-			calculateControlByte(t.TAGXTableChunk),
-			encodeVwi(chap.Start),  // Record offset
-			encodeVwi(chap.Length), // Length of a record
-			encodeVwi(cncxOffset),  // Label offset relative to CNXC record
-			encodeVwi(0),           // Null
+			calculateControlByte(t.TAGXTableGuide),
+			encodeVwi(cncxOffset), // Title offset relative to CNCX record
+			encodeVwi(g.Chunk),    // Chunk (fid)
+			encodeVwi(0),          // Offset in chunk
 		)
 		idxtEntries = append(idxtEntries, bs)
 		cncxOffset += len(cncx)
 	}
 
 	return IndexRecord{
-			Type:          0,
-			HeaderType:    1,
-			IDXTEntries:   idxtEntries,
-			SubEntryCount: 0,
-		}, CNCXRecord{
-			entries: cncxEntries,
-		}
+		Type:          0,
+		HeaderType:    1,
+		IDXTEntries:   idxtEntries,
+		SubEntryCount: 0,
+	}, CNCXRecord{
+		entries: cncxEntries,
+	}
 }
 
 func SkeletonIndexRecord(info []ChunkInfo) IndexRecord {
@@ -133,13 +274,13 @@ func ChunkIndexRecord(info []ChunkInfo) (IndexRecord, CNCXRecord) {
 	}
 
 	return IndexRecord{
-			Type:          0,
-			HeaderType:    1,
-			IDXTEntries:   idxtEntries,
-			SubEntryCount: 0,
-		}, CNCXRecord{
-			entries: cncxEntries,
-		}
+		Type:          0,
+		HeaderType:    1,
+		IDXTEntries:   idxtEntries,
+		SubEntryCount: 0,
+	}, CNCXRecord{
+		entries: cncxEntries,
+	}
 }
 
 type ChunkInfo struct {
@@ -149,10 +290,17 @@ type ChunkInfo struct {
 	ContentLength int
 }
 
+type GuideInfo struct {
+	Type  string
+	Title string
+	Chunk int
+}
+
 type ChapterInfo struct {
 	Title  string
 	Start  int
 	Length int
+	Depth  int
 }
 
 func encodeINDXString(s string) []byte {
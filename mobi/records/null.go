@@ -27,8 +27,14 @@ func NewNullRecord(name string) NullRecord {
 }
 
 func (n NullRecord) Write(w io.Writer) error {
+	legacy := n.MOBIHeader.HeaderLength < t.KF8HeaderLength
+
 	// Set full name offset and length
-	n.MOBIHeader.FullNameOffset = uint32(t.PalmDocHeaderLength + t.KF8HeaderLength + n.EXTHSection.Length())
+	hlen := t.KF8HeaderLength
+	if legacy {
+		hlen = t.MOBIHeaderLength
+	}
+	n.MOBIHeader.FullNameOffset = uint32(t.PalmDocHeaderLength + hlen + n.EXTHSection.Length())
 	n.MOBIHeader.FullNameLength = uint32(len(n.FullName))
 
 	// Write PalmDoc header
@@ -38,7 +44,11 @@ func (n NullRecord) Write(w io.Writer) error {
 	}
 
 	// Write MOBI header
-	err = binary.Write(w, pdb.Endian, n.MOBIHeader)
+	if legacy {
+		err = binary.Write(w, pdb.Endian, n.MOBIHeader.MOBIHeader)
+	} else {
+		err = binary.Write(w, pdb.Endian, n.MOBIHeader)
+	}
 	if err != nil {
 		return err
 	}
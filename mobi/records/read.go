@@ -0,0 +1,400 @@
+package records
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+
+	"github.com/adamay909/AozoraConvert/mobi/pdb"
+	t "github.com/adamay909/AozoraConvert/mobi/types"
+)
+
+// ErrFormat is returned when a record cannot be decoded.
+var ErrFormat = errors.New("records: malformed record")
+
+// ParseNullRecord decodes the first record of a MOBI file. Headers
+// shorter than a KF8 header (MOBI 6 and earlier) leave the KF8
+// specific fields zero.
+func ParseNullRecord(data []byte) (NullRecord, error) {
+
+	var n NullRecord
+
+	b := bytes.NewReader(data)
+
+	err := binary.Read(b, pdb.Endian, &n.PalmDocHeader)
+	if err != nil {
+		return n, ErrFormat
+	}
+
+	if len(data) < t.PalmDocHeaderLength+8 || string(data[16:20]) != "MOBI" {
+		return n, ErrFormat
+	}
+
+	hlen := int(pdb.Endian.Uint32(data[20:24]))
+
+	// pad short headers so that they can be read as KF8 headers
+	h := make([]byte, t.KF8HeaderLength)
+	copy(h, data[t.PalmDocHeaderLength:min(len(data), t.PalmDocHeaderLength+hlen)])
+
+	err = binary.Read(bytes.NewReader(h), pdb.Endian, &n.MOBIHeader)
+	if err != nil {
+		return n, ErrFormat
+	}
+
+	if n.MOBIHeader.EXTHFlags&0x40 != 0 {
+		n.EXTHSection, err = ParseEXTHSection(data[min(len(data), t.PalmDocHeaderLength+hlen):])
+		if err != nil {
+			return n, err
+		}
+	}
+
+	from := int(n.MOBIHeader.FullNameOffset)
+	to := from + int(n.MOBIHeader.FullNameLength)
+	if from <= to && to <= len(data) {
+		n.FullName = string(data[from:to])
+	}
+
+	return n, nil
+}
+
+// ParseEXTHSection decodes the EXTH section at the start of data.
+func ParseEXTHSection(data []byte) (EXTHSection, error) {
+
+	e := NewEXTHSection()
+
+	var h t.EXTHHeader
+
+	b := bytes.NewReader(data)
+
+	err := binary.Read(b, pdb.Endian, &h)
+	if err != nil || string(h.EXTH[:]) != "EXTH" {
+		return e, ErrFormat
+	}
+
+	for i := 0; i < int(h.EntryCount); i++ {
+
+		var eh t.EXTHEntryHeader
+
+		err = binary.Read(b, pdb.Endian, &eh)
+		if err != nil || eh.RecordLength < t.EXTHEntryHeaderLength || int(eh.RecordLength)-t.EXTHEntryHeaderLength > b.Len() {
+			return e, ErrFormat
+		}
+
+		d := make([]byte, eh.RecordLength-t.EXTHEntryHeaderLength)
+		b.Read(d)
+
+		e.entries = append(e.entries, NewEXTHEntry(eh.RecordType, d))
+	}
+
+	return e, nil
+}
+
+// Entries returns the entries of e.
+func (e EXTHSection) Entries() []EXTHEntry {
+	return e.entries
+}
+
+// Strings returns the data of all entries of type tp as strings.
+func (e EXTHSection) Strings(tp t.EXTHEntryType) []string {
+
+	var ss []string
+
+	for _, entry := range e.entries {
+		if entry.EntryType == tp {
+			ss = append(ss, string(entry.Data))
+		}
+	}
+
+	return ss
+}
+
+// Int returns the data of the first entry of type tp as an integer.
+func (e EXTHSection) Int(tp t.EXTHEntryType) (int, bool) {
+
+	for _, entry := range e.entries {
+		if entry.EntryType == tp && len(entry.Data) == 4 {
+			return int(pdb.Endian.Uint32(entry.Data)), true
+		}
+	}
+
+	return 0, false
+}
+
+// StripTrailingData returns the data of a text record without the
+// trailing entries indicated by flags, the extra record data flags of
+// the MOBI header.
+func StripTrailingData(data []byte, flags uint32) ([]byte, error) {
+
+	for bit := 15; bit > 0; bit-- {
+
+		if flags&(1<<bit) == 0 {
+			continue
+		}
+
+		size := trailingEntrySize(data)
+		if size == 0 || size > len(data) {
+			return nil, ErrFormat
+		}
+
+		data = data[:len(data)-size]
+	}
+
+	if flags&1 != 0 {
+
+		if len(data) == 0 {
+			return nil, ErrFormat
+		}
+
+		n := int(data[len(data)-1]&0x03) + 1
+		if n > len(data) {
+			return nil, ErrFormat
+		}
+
+		data = data[:len(data)-n]
+	}
+
+	return data, nil
+}
+
+// trailingEntrySize reads the size of the trailing entry at the end of
+// data. The size is a variable width integer read backwards, whose
+// first byte has the high bit set, and counts its own bytes.
+func trailingEntrySize(data []byte) int {
+
+	size, shift := 0, 0
+
+	for i := len(data) - 1; i >= 0 && shift < 28; i-- {
+		v := data[i]
+		size |= int(v&0x7f) << shift
+		shift += 7
+		if v&0x80 != 0 {
+			break
+		}
+	}
+
+	return size
+}
+
+// ParseFDSTRecord returns the entries of the FDST record data.
+func ParseFDSTRecord(data []byte) ([]t.FDSTEntry, error) {
+
+	var h t.FDSTHeader
+
+	b := bytes.NewReader(data)
+
+	err := binary.Read(b, pdb.Endian, &h)
+	if err != nil || string(h.FDST[:]) != "FDST" || int(h.EntryCount)*t.FDSTEntryLength > b.Len() {
+		return nil, ErrFormat
+	}
+
+	entries := make([]t.FDSTEntry, h.EntryCount)
+
+	err = binary.Read(b, pdb.Endian, entries)
+	if err != nil {
+		return nil, ErrFormat
+	}
+
+	return entries, nil
+}
+
+// IndexEntry is an entry of an INDX record. Tags maps the tag numbers
+// of the TAGX table to their values.
+type IndexEntry struct {
+	Label string
+	Tags  map[byte][]int
+}
+
+// ParseIndexHeader decodes the header of an INDX record and its TAGX
+// table, if present.
+func ParseIndexHeader(data []byte) (h t.INDXHeader, tagx t.TAGXTagTable, controlBytes int, err error) {
+
+	err = binary.Read(bytes.NewReader(data), pdb.Endian, &h)
+	if err != nil || string(h.INDX[:]) != "INDX" {
+		return h, nil, 0, ErrFormat
+	}
+
+	if h.TAGXOffset == 0 {
+		return h, nil, 0, nil
+	}
+
+	o := int(h.TAGXOffset)
+
+	var th t.TAGXHeader
+
+	if o+t.TAGXHeaderLength > len(data) {
+		return h, nil, 0, ErrFormat
+	}
+
+	binary.Read(bytes.NewReader(data[o:]), pdb.Endian, &th)
+
+	if string(th.TAGX[:]) != "TAGX" || th.HeaderLength < t.TAGXHeaderLength || o+int(th.HeaderLength) > len(data) {
+		return h, nil, 0, ErrFormat
+	}
+
+	tagx = make(t.TAGXTagTable, (th.HeaderLength-t.TAGXHeaderLength)/t.TAGXTagLength)
+
+	binary.Read(bytes.NewReader(data[o+t.TAGXHeaderLength:]), pdb.Endian, tagx)
+
+	return h, tagx, int(th.ControlByteCount), nil
+}
+
+// ParseIndexEntries decodes the entries of an INDX data record using
+// the TAGX table of the corresponding header record.
+func ParseIndexEntries(data []byte, tagx t.TAGXTagTable, controlBytes int) ([]IndexEntry, error) {
+
+	h, _, _, err := ParseIndexHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	idxt := int(h.IDXTStart)
+	n := int(h.IndexRecordCount)
+
+	if idxt+4+2*n > len(data) || string(data[idxt:idxt+4]) != "IDXT" {
+		return nil, ErrFormat
+	}
+
+	var entries []IndexEntry
+
+	for i := 0; i < n; i++ {
+
+		start := int(pdb.Endian.Uint16(data[idxt+4+2*i:]))
+		end := idxt
+		if i+1 < n {
+			end = int(pdb.Endian.Uint16(data[idxt+4+2*i+2:]))
+		}
+
+		if start >= end || end > len(data) {
+			return nil, ErrFormat
+		}
+
+		e, err := parseIndexEntry(data[start:end], tagx, controlBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+func parseIndexEntry(data []byte, tagx t.TAGXTagTable, controlBytes int) (IndexEntry, error) {
+
+	e := IndexEntry{Tags: make(map[byte][]int)}
+
+	l := int(data[0])
+	if 1+l+controlBytes > len(data) {
+		return e, ErrFormat
+	}
+
+	e.Label = string(data[1 : 1+l])
+	cbs := data[1+l : 1+l+controlBytes]
+	data = data[1+l+controlBytes:]
+
+	type tagCount struct {
+		tag, nvals byte
+		count      int // number of value groups, or -1
+		size       int // number of bytes if count is -1
+	}
+
+	var counts []tagCount
+
+	cb := 0
+
+	for _, tag := range tagx {
+
+		tg, nvals, mask, end := deconstructTag(tag)
+
+		if end == 1 {
+			cb++
+			continue
+		}
+
+		if cb >= len(cbs) {
+			return e, ErrFormat
+		}
+
+		v := cbs[cb] & mask
+		if v == 0 {
+			continue
+		}
+
+		if v == mask && bits.OnesCount8(mask) > 1 {
+			size, n := decodeVwi(data)
+			if n == 0 {
+				return e, ErrFormat
+			}
+			data = data[n:]
+			counts = append(counts, tagCount{tg, nvals, -1, size})
+			continue
+		}
+
+		counts = append(counts, tagCount{tg, nvals, int(v >> bits.TrailingZeros8(mask)), 0})
+	}
+
+	for _, c := range counts {
+
+		var vals []int
+
+		if c.count >= 0 {
+			for i := 0; i < c.count*int(c.nvals); i++ {
+				v, n := decodeVwi(data)
+				if n == 0 {
+					return e, ErrFormat
+				}
+				data = data[n:]
+				vals = append(vals, v)
+			}
+		} else {
+			for used := 0; used < c.size; {
+				v, n := decodeVwi(data)
+				if n == 0 {
+					return e, ErrFormat
+				}
+				data = data[n:]
+				used += n
+				vals = append(vals, v)
+			}
+		}
+
+		e.Tags[c.tag] = vals
+	}
+
+	return e, nil
+}
+
+// decodeVwi decodes a variable width integer as written by encodeVwi.
+// n is the number of bytes read, or 0 if data ends before the integer.
+func decodeVwi(data []byte) (v, n int) {
+
+	for i, b := range data {
+		v = v<<7 | int(b&0x7f)
+		if b&0x80 != 0 {
+			return v, i + 1
+		}
+	}
+
+	return 0, 0
+}
+
+// ParseCNCX returns the strings in the CNCX records keyed by their
+// offset as used in INDX entries.
+func ParseCNCX(recs [][]byte) map[int]string {
+
+	m := make(map[int]string)
+
+	for i, data := range recs {
+		for p := 0; p < len(data); {
+			l, n := decodeVwi(data[p:])
+			if n == 0 || l == 0 || p+n+l > len(data) {
+				break
+			}
+			m[i<<16|p] = string(data[p+n : p+n+l])
+			p += n + l
+		}
+	}
+
+	return m
+}
@@ -42,7 +42,7 @@ func NewTrailProvider(chapters []ChapterInfo) TrailProvider {
 	}
 */
 func Get(end int, from, to int) TrailingData {
-	strands := TrailingData{Multibyte: 0}
+	strands := TrailingData{}
 
 	chStart := 0
 
@@ -73,8 +73,11 @@ func Get(end int, from, to int) TrailingData {
 // to every text record as indicated by the extra data bitflags in the
 // MOBI header. This implementation only supports flags 0b11 meaning
 // entries for multibyte overlap and indexing data.
+//
+// Multibyte holds the bytes of a UTF-8 encoded character that starts
+// at the end of the record but continues in the next one.
 type TrailingData struct {
-	Multibyte byte
+	Multibyte []byte
 	Strands   *StrandData
 }
 
@@ -98,13 +101,21 @@ type SequenceData struct {
 	FlagDoesSpan              bool
 }
 
+// Encode returns the trailing entries. The multibyte entry comes
+// first, ending in the byte giving the number of multibyte bytes,
+// followed by the indexing data ending in its own size.
 func (td TrailingData) Encode() []byte {
-	b := bytes.NewBuffer([]byte{td.Multibyte})
+	b := bytes.NewBuffer(nil)
+	b.Write(td.Multibyte)
+	b.WriteByte(byte(len(td.Multibyte)))
+
+	var strands []byte
 	if td.Strands != nil {
-		b.Write(td.Strands.Encode())
+		strands = td.Strands.Encode()
 	}
+	b.Write(encodeTrailingBytes(strands))
 
-	return encodeTrailingBytes(b.Bytes())
+	return b.Bytes()
 }
 
 func (sd StrandData) Encode() []byte {
@@ -0,0 +1,56 @@
+package records
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTrailingData(t *testing.T) {
+
+	text := []byte("吾輩は猫である。")
+
+	tests := []TrailingData{
+		{},
+		{Multibyte: []byte("\xe5\x90")},
+		{Strands: &StrandData{Index: 3, FlagTBSType: 8, FlagNumSiblings: 2}},
+		{Multibyte: []byte("\xe5"), Strands: &StrandData{Index: 1000, FlagTBSType: 8, FlagDoesSpan: true}},
+	}
+
+	for _, td := range tests {
+
+		data := append(append([]byte(nil), text...), td.Encode()...)
+
+		got, err := StripTrailingData(data, 0b11)
+		if err != nil || !bytes.Equal(got, text) {
+			t.Errorf("%+v: got %q, %v", td, got, err)
+		}
+
+		got, err = StripTrailingData(data, 0b10)
+		if want := append(append(append([]byte(nil), text...), td.Multibyte...), byte(len(td.Multibyte))); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%+v: multibyte entry: got % x, %v", td, got, err)
+		}
+	}
+}
+
+func TestTrailingEntrySize(t *testing.T) {
+
+	text := []byte("吾輩は猫である。")
+
+	// sizes around the ones that need one more byte
+	for _, n := range []int{0, 1, 125, 126, 127, 128, 200, 16380, 16381, 16382, 16383, 20000} {
+
+		entry := encodeTrailingBytes(bytes.Repeat([]byte{0x81}, n))
+
+		if size := trailingEntrySize(entry); size != len(entry) {
+			t.Errorf("%d bytes: size %d, want %d", n, size, len(entry))
+		}
+
+		// a multibyte entry in front of the large entry
+		data := append(append(append([]byte(nil), text...), 0xe5, 0x01), entry...)
+
+		got, err := StripTrailingData(data, 0b11)
+		if err != nil || !bytes.Equal(got, text) {
+			t.Errorf("%d bytes: got %d bytes, %v", n, len(got), err)
+		}
+	}
+}
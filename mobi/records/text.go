@@ -1,21 +1,37 @@
 package records
 
-import "io"
+import (
+	"errors"
+	"io"
+)
 
 const TextRecordMaxSize = 4096 // 0x1000
 
+// ErrTextRecordSize is returned when the text for a text record
+// exceeds TextRecordMaxSize.
+var ErrTextRecordSize = errors.New("records: text record too large")
+
 type TextRecord struct {
 	data  []byte
 	trail []byte
 }
 
-func NewTextRecord(s string, trail TrailingData) TextRecord {
+func NewTextRecord(s string, trail TrailingData) (TextRecord, error) {
 	if len(s) > TextRecordMaxSize {
-		panic("TextRecord too large")
+		return TextRecord{}, ErrTextRecordSize
 	}
 	return TextRecord{
 		data:  []byte(s),
 		trail: trail.Encode(),
+	}, nil
+}
+
+// NewCompressedTextRecord returns a text record holding data, which is
+// the compressed form of at most TextRecordMaxSize bytes of text.
+func NewCompressedTextRecord(data []byte, trail TrailingData) TextRecord {
+	return TextRecord{
+		data:  data,
+		trail: trail.Encode(),
 	}
 }
 
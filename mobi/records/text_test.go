@@ -0,0 +1,18 @@
+package records
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewTextRecord(t *testing.T) {
+
+	if _, err := NewTextRecord(strings.Repeat("a", TextRecordMaxSize), TrailingData{}); err != nil {
+		t.Errorf("full record: %v", err)
+	}
+
+	if _, err := NewTextRecord(strings.Repeat("a", TextRecordMaxSize+1), TrailingData{}); !errors.Is(err, ErrTextRecordSize) {
+		t.Errorf("got %v, want %v", err, ErrTextRecordSize)
+	}
+}
@@ -35,8 +35,26 @@ func encodeVwi(x int) []byte {
 	return relevant
 }
 
+// encodeBackwardVwi encodes x as a variable width integer that is read
+// from its end, as the sizes of trailing entries are. Unlike with
+// encodeVwi, the high bit marks the first byte.
+func encodeBackwardVwi(x int) []byte {
+	b := encodeVwi(x)
+	b[len(b)-1] &^= 0x80
+	b[0] |= 0x80
+	return b
+}
+
+// encodeTrailingBytes returns data followed by its size as a trailing
+// entry. The size counts the bytes of the size itself.
 func encodeTrailingBytes(data []byte) []byte {
-	return append(data, encodeVwi(len(data))...)
+
+	n := 1
+	for len(encodeBackwardVwi(len(data)+n)) > n {
+		n++
+	}
+
+	return append(data, encodeBackwardVwi(len(data)+n)...)
 }
 
 func reverseBytes(buf []byte) {
@@ -74,13 +92,19 @@ func mapTagToNvals(tag t.TAGXTag) byte {
 	switch tag {
 	case t.TAGXTagSkeletonGeometry:
 		return 4
-	case t.TAGXTagChunkGeometry, t.TAGXTagSkeletonChunkCount:
+	case t.TAGXTagChunkGeometry, t.TAGXTagSkeletonChunkCount, t.TAGXTagGuidePosFid:
 		return 2
 	default:
 		return 1
 	}
 }
 
+// tagMask returns the bits of the control byte for tag.
+func tagMask(tag t.TAGXTag) byte {
+	_, _, bm, _ := deconstructTag(tag)
+	return bm
+}
+
 func deconstructTag(tag t.TAGXTag) (byte, byte, byte, byte) {
 	bs := make([]byte, 4)
 	pdb.Endian.PutUint32(bs, uint32(tag))
@@ -89,3 +89,6 @@ func (r FCISRecord) Write(w io.Writer) error {
 const EOFRecordLength = 4
 
 var EOFRecord = pdb.RawRecord{0xE9, 0x8E, 0x0D, 0x0A}
+
+// BoundaryRecord separates the MOBI 6 and KF8 parts of a joint file.
+var BoundaryRecord = pdb.RawRecord("BOUNDARY")
@@ -124,6 +124,17 @@ var TAGXTableNCXSingle = TAGXTagTable{
 	TAGXTagEnd,
 }
 
+var TAGXTableNCX = TAGXTagTable{
+	TAGXTagEntryPosition,
+	TAGXTagEntryLength,
+	TAGXTagEntryNameOffset,
+	TAGXTagEntryDepthLevel,
+	TAGXTagEntryParent,
+	TAGXTagEntryChild1,
+	TAGXTagEntryChildN,
+	TAGXTagEnd,
+}
+
 var TAGXTableSkeleton = TAGXTagTable{
 	TAGXTagSkeletonChunkCount,
 	TAGXTagSkeletonGeometry,
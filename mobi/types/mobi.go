@@ -4,6 +4,13 @@ import "math"
 
 const PalmDocHeaderLength = 16 // 0x10
 
+// Values of PalmDocHeader.Compression.
+const (
+	CompressionNone     = 1
+	CompressionPalmDoc  = 2
+	CompressionHuffCdic = 17480
+)
+
 type PalmDocHeader struct {
 	Compression     uint16
 	Unused1         uint16
@@ -16,7 +23,7 @@ type PalmDocHeader struct {
 
 func NewPalmDocHeader() PalmDocHeader {
 	return PalmDocHeader{
-		Compression:     1,
+		Compression:     CompressionNone,
 		Unused1:         0,
 		TextLength:      0,
 		TextRecordCount: 0,
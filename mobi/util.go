@@ -2,6 +2,7 @@ package mobi
 
 import (
 	"strings"
+	"unicode/utf8"
 
 	r "github.com/adamay909/AozoraConvert/mobi/records"
 )
@@ -37,13 +38,17 @@ func chaptersToText(m Book) (string, []r.ChunkInfo, []r.ChapterInfo, error) {
 			Title:  chap.Title,
 			Start:  chapStart,
 			Length: text.Len() - chapStart,
+			Depth:  chap.Depth,
 		})
 	}
 
 	return text.String(), chunks, chaps, nil
 }
 
-func textToRecords(html string, chapters []r.ChapterInfo) []r.TextRecord {
+// textToRecords splits html into text records, compressing them if
+// compress is true. A character cut at the end of a record is
+// repeated in the multibyte trailing entry.
+func textToRecords(html string, chapters []r.ChapterInfo, compress bool) ([]r.TextRecord, error) {
 	//		provider := r.NewTrailProvider(chapters)
 	records := make([]r.TextRecord, 0)
 	recordCount := len(html) / r.TextRecordMaxSize
@@ -55,10 +60,27 @@ func textToRecords(html string, chapters []r.ChapterInfo) []r.TextRecord {
 		from := i * r.TextRecordMaxSize
 		to := min(from+r.TextRecordMaxSize, len(html))
 		trail := r.Get(len(html), from, to)
-		records = append(records, r.NewTextRecord(html[from:to], trail))
+		trail.Multibyte = []byte(html[to:overlapEnd(html, to)])
+		if compress {
+			records = append(records, r.NewCompressedTextRecord(PalmDocCompress([]byte(html[from:to])), trail))
+			continue
+		}
+		rec, err := r.NewTextRecord(html[from:to], trail)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, rec)
 	}
 
-	return records
+	return records, nil
+}
+
+// overlapEnd returns the end of the character continuing at i.
+func overlapEnd(s string, i int) int {
+	for i < len(s) && !utf8.RuneStart(s[i]) {
+		i++
+	}
+	return i
 }
 
 func min(a, b int) int {
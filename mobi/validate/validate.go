@@ -0,0 +1,899 @@
+// Package validate checks the structure of KF8 formatted MOBI and AZW3
+// files such as the ones written by package mobi.
+//
+// The checks cover the Palm database, the headers of the null record,
+// the text records and their trailing entries, the FDST flows, the
+// skeleton, chunk, NCX and guide indices, and the image records
+// referred to by the text and the EXTH header. Problems that make a
+// file unreadable on Kindle devices are reported as errors, suspicious
+// but harmless ones as warnings.
+package validate
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/adamay909/AozoraConvert/internal/diag"
+	"github.com/adamay909/AozoraConvert/mobi"
+	"github.com/adamay909/AozoraConvert/mobi/pdb"
+	r "github.com/adamay909/AozoraConvert/mobi/records"
+	t "github.com/adamay909/AozoraConvert/mobi/types"
+)
+
+// Severity tells how bad a problem is. It is the same as in package
+// epubcheck.
+type Severity = diag.Severity
+
+const (
+	Warning = diag.Warning
+	Error   = diag.Error
+)
+
+// Diagnostic describes a problem found in a file. Record is the index
+// of the record the problem was found in, or -1 if the problem is not
+// about a single record.
+type Diagnostic struct {
+	Severity Severity
+	Record   int
+	Message  string
+}
+
+func (d Diagnostic) String() string {
+
+	if d.Record < 0 {
+		return d.Severity.String() + ": " + d.Message
+	}
+
+	return fmt.Sprintf("%s: record %d: %s", d.Severity, d.Record, d.Message)
+}
+
+// HasErrors reports whether ds contains errors.
+func HasErrors(ds []Diagnostic) bool {
+
+	for _, d := range ds {
+		if d.Severity == Error {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CheckBook realizes m and checks the result. The error is the error
+// returned by Realize or by writing the database.
+func CheckBook(m mobi.Book) ([]Diagnostic, error) {
+
+	db, err := m.Realize()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := new(bytes.Buffer)
+
+	err = db.Write(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return Check(buf.Bytes()), nil
+}
+
+// Check checks the KF8 file data.
+func Check(data []byte) []Diagnostic {
+
+	c := &checker{data: data}
+
+	c.check()
+
+	return c.ds
+}
+
+type checker struct {
+	data []byte
+	db   *pdb.Database
+
+	// index of the KF8 null record
+	base int
+
+	null r.NullRecord
+	text []byte
+	html []byte
+
+	images int
+
+	ds []Diagnostic
+}
+
+func (c *checker) errorf(rec int, format string, args ...any) {
+	c.ds = append(c.ds, Diagnostic{Error, rec, fmt.Sprintf(format, args...)})
+}
+
+func (c *checker) warnf(rec int, format string, args ...any) {
+	c.ds = append(c.ds, Diagnostic{Warning, rec, fmt.Sprintf(format, args...)})
+}
+
+// record returns record i, counted from the KF8 null record.
+func (c *checker) record(i int) []byte {
+
+	i += c.base
+
+	if i < 0 || i >= len(c.db.Records) {
+		return nil
+	}
+
+	raw, _ := c.db.Records[i].(pdb.RawRecord)
+
+	return raw
+}
+
+// has reports whether record i, counted from the KF8 null record,
+// exists and reports an error about what otherwise.
+func (c *checker) has(i int, what string) bool {
+
+	if i < 0 || i+c.base >= len(c.db.Records) {
+		c.errorf(-1, "%s refers to record %d but there are %d records", what, i+c.base, len(c.db.Records))
+		return false
+	}
+
+	return true
+}
+
+func (c *checker) check() {
+
+	if !c.checkDatabase() {
+		return
+	}
+
+	if !c.checkNull() {
+		return
+	}
+
+	c.text = c.checkText(c.null, "")
+
+	c.checkFlows()
+	c.checkIndices()
+	c.checkImages()
+	c.checkFixed()
+}
+
+func (c *checker) checkDatabase() bool {
+
+	if len(c.data) < pdb.PalmDBHeaderLength {
+		c.errorf(-1, "file is too short for a Palm database header")
+		return false
+	}
+
+	if tp := string(c.data[60:68]); tp != "BOOKMOBI" {
+		c.errorf(-1, "Palm database type and creator are %q, not BOOKMOBI", tp)
+	}
+
+	db, err := pdb.ReadDatabase(bytes.NewReader(c.data))
+	if err != nil {
+		c.errorf(-1, "cannot read Palm database: %v", err)
+		return false
+	}
+
+	c.db = db
+
+	if len(db.Records) == 0 {
+		c.errorf(-1, "Palm database has no records")
+		return false
+	}
+
+	if !bytes.Equal(c.record(len(db.Records)-1), t.EOFRecord) {
+		c.warnf(len(db.Records)-1, "last record is not the EOF record")
+	}
+
+	return true
+}
+
+// checkNull checks the null record and, for joint files, the MOBI 6
+// part and the boundary record.
+func (c *checker) checkNull() bool {
+
+	null, ok := c.checkNullRecord(0)
+	if !ok {
+		return false
+	}
+
+	b, joint := null.EXTHSection.Int(t.EXTHKF8Boundary)
+
+	if null.MOBIHeader.FileVersion < 8 {
+
+		if !joint {
+			c.errorf(0, "MOBI version %d file without KF8 part", null.MOBIHeader.FileVersion)
+			return false
+		}
+
+		if !c.has(b, "EXTH KF8 boundary") {
+			return false
+		}
+
+		if !bytes.Equal(c.record(b-1), t.BoundaryRecord) {
+			c.errorf(b-1, "record in front of the KF8 part is not a BOUNDARY record")
+		}
+
+		c.checkLegacy(null)
+
+		c.base = b
+
+		null, ok = c.checkNullRecord(0)
+		if !ok {
+			return false
+		}
+
+	} else if joint {
+		c.errorf(0, "KF8 null record has an EXTH KF8 boundary entry")
+	}
+
+	if null.MOBIHeader.FileVersion != 8 || null.MOBIHeader.HeaderLength != t.KF8HeaderLength {
+		c.errorf(c.base, "KF8 null record has version %d and header length %d, want 8 and %d",
+			null.MOBIHeader.FileVersion, null.MOBIHeader.HeaderLength, t.KF8HeaderLength)
+	}
+
+	c.null = null
+
+	return true
+}
+
+func (c *checker) checkNullRecord(i int) (r.NullRecord, bool) {
+
+	data := c.record(i)
+	rec := i + c.base
+
+	null, err := r.ParseNullRecord(data)
+	if err != nil {
+		c.errorf(rec, "cannot read null record: %v", err)
+		return null, false
+	}
+
+	h := null.MOBIHeader
+
+	if h.EXTHFlags&0x40 == 0 {
+		c.warnf(rec, "no EXTH header")
+	} else {
+		exthLen := null.EXTHSection.Length()
+		if int(h.FullNameOffset) < t.PalmDocHeaderLength+int(h.HeaderLength)+exthLen {
+			c.errorf(rec, "full name at offset %d overlaps the EXTH header", h.FullNameOffset)
+		}
+	}
+
+	if int(h.FullNameOffset)+int(h.FullNameLength) > len(data) {
+		c.errorf(rec, "full name at %d+%d beyond end of record of length %d", h.FullNameOffset, h.FullNameLength, len(data))
+	}
+
+	if h.TextEncoding != 65001 {
+		c.warnf(rec, "text encoding is %d, not UTF-8 (65001)", h.TextEncoding)
+	}
+
+	if null.PalmDocHeader.Encryption != 0 {
+		c.errorf(rec, "text is encrypted")
+	}
+
+	if null.PalmDocHeader.TextRecordCount == 0 {
+		c.errorf(rec, "no text records")
+	}
+
+	if n := int(null.PalmDocHeader.TextRecordCount); int(h.FirstNonBookIndex) <= n {
+		c.errorf(rec, "first non-book record %d is one of the %d text records", h.FirstNonBookIndex, n)
+	}
+
+	return null, true
+}
+
+// checkLegacy checks the MOBI 6 part of a joint file.
+func (c *checker) checkLegacy(null r.NullRecord) {
+
+	text := c.checkText(null, "MOBI 6 ")
+
+	if !bytes.HasPrefix(text, []byte("<html")) {
+		c.warnf(-1, "MOBI 6 text does not start with <html")
+	}
+
+	for _, m := range filepos.FindAllSubmatch(text, -1) {
+		if p, _ := strconv.Atoi(string(m[1])); p >= len(text) {
+			c.errorf(-1, "MOBI 6 text refers to file position %d but has length %d", p, len(text))
+		}
+	}
+
+	h := null.MOBIHeader
+
+	if h.FirstImageIndex == math.MaxUint32 {
+		return
+	}
+
+	n := 0
+	for i := int(h.FirstImageIndex); i < len(c.db.Records) && isImage(c.record(i)); i++ {
+		n++
+	}
+
+	for _, m := range recindex.FindAllSubmatch(text, -1) {
+		i, _ := strconv.Atoi(string(m[1]))
+		if i < 1 || i > n {
+			c.errorf(-1, "MOBI 6 text refers to image %d but there are %d images", i, n)
+		}
+	}
+}
+
+var (
+	recindex = regexp.MustCompile(`recindex="([0-9]+)"`)
+	filepos  = regexp.MustCompile(`filepos=([0-9]+)`)
+)
+
+// checkText checks the text records following null and returns the
+// text.
+func (c *checker) checkText(null r.NullRecord, part string) []byte {
+
+	h := null.PalmDocHeader
+	flags := null.MOBIHeader.ExtraRecordDataFlags
+
+	switch h.Compression {
+	case t.CompressionNone, t.CompressionPalmDoc:
+	case t.CompressionHuffCdic:
+		c.warnf(c.base, "%stext is HUFF/CDIC compressed and not checked", part)
+		return nil
+	default:
+		c.errorf(c.base, "%stext has unknown compression %d", part, h.Compression)
+		return nil
+	}
+
+	text := new(bytes.Buffer)
+
+	var overlap []byte
+
+	for i := 1; i <= int(h.TextRecordCount); i++ {
+
+		if !c.has(i, part+"text record count") {
+			return nil
+		}
+
+		rec := i + c.base
+		raw := c.record(i)
+
+		data, err := r.StripTrailingData(raw, flags)
+		if err != nil {
+			c.errorf(rec, "%strailing entries: %v", part, err)
+			return nil
+		}
+
+		var mb []byte
+		if flags&1 != 0 {
+			n := len(data)
+			full, _ := r.StripTrailingData(raw, flags&^1)
+			mb = full[n : len(full)-1]
+		}
+
+		if h.Compression == t.CompressionPalmDoc {
+			data, err = mobi.PalmDocDecompress(data)
+			if err != nil {
+				c.errorf(rec, "%stext: %v", part, err)
+				return nil
+			}
+		}
+
+		if len(data) > int(h.RecordSize) {
+			c.errorf(rec, "%stext record holds %d bytes, more than the record size %d", part, len(data), h.RecordSize)
+		}
+
+		if !bytes.HasPrefix(data, overlap) {
+			c.errorf(rec, "%stext does not start with the bytes % x of the multibyte entry of the previous record", part, overlap)
+		}
+
+		overlap = mb
+
+		text.Write(data)
+	}
+
+	if len(overlap) > 0 {
+		c.errorf(int(h.TextRecordCount)+c.base, "%slast text record has a multibyte entry", part)
+	}
+
+	if text.Len() != int(h.TextLength) {
+		c.errorf(c.base, "%stext length is %d but the PalmDoc header says %d", part, text.Len(), h.TextLength)
+	}
+
+	if !utf8.Valid(text.Bytes()) {
+		c.errorf(-1, "%stext is not valid UTF-8", part)
+	}
+
+	return text.Bytes()
+}
+
+func (c *checker) checkFlows() {
+
+	h := c.null.MOBIHeader
+
+	idx := int(h.FirstContentRecordNumberOrFDSTNumberMSB)<<16 | int(h.LastContentRecordNumberOrFDSTNumberLSB)
+
+	if h.FirstContentRecordNumberOrFDSTNumberMSB == math.MaxUint16 {
+		c.warnf(c.base, "no FDST record")
+		c.html = c.text
+		return
+	}
+
+	if !c.has(idx, "FDST index") {
+		return
+	}
+
+	entries, err := r.ParseFDSTRecord(c.record(idx))
+	if err != nil {
+		c.errorf(idx+c.base, "cannot read FDST record: %v", err)
+		return
+	}
+
+	if len(entries) != int(h.Unknown3OrFDSTEntryCount) {
+		c.errorf(c.base, "FDST has %d entries but the MOBI header says %d", len(entries), h.Unknown3OrFDSTEntryCount)
+	}
+
+	end := uint32(0)
+
+	for i, e := range entries {
+		if e.Start != end {
+			c.errorf(idx+c.base, "flow %d starts at %d, not at the end %d of the previous flow", i, e.Start, end)
+		}
+		if e.End < e.Start {
+			c.errorf(idx+c.base, "flow %d ends at %d before its start %d", i, e.End, e.Start)
+		}
+		end = e.End
+	}
+
+	if int(end) != len(c.text) {
+		c.errorf(idx+c.base, "flows end at %d but the text has length %d", end, len(c.text))
+	}
+
+	if len(entries) > 0 && int(entries[0].End) <= len(c.text) {
+		c.html = c.text[:entries[0].End]
+	}
+}
+
+// index is a decoded INDX index.
+type index struct {
+	tagx    t.TAGXTagTable
+	entries []r.IndexEntry
+	cncx    map[int]string
+}
+
+// readIndex checks and reads the index with header record idx.
+func (c *checker) readIndex(idx uint32, name string, required ...byte) *index {
+
+	if idx == math.MaxUint32 {
+		return nil
+	}
+
+	i := int(idx)
+
+	if !c.has(i, name+" index") {
+		return nil
+	}
+
+	h, tagx, cbs, err := r.ParseIndexHeader(c.record(i))
+	if err != nil {
+		c.errorf(i+c.base, "%s index header: %v", name, err)
+		return nil
+	}
+
+	if len(tagx) == 0 {
+		c.errorf(i+c.base, "%s index header has no TAGX table", name)
+		return nil
+	}
+
+	ends := 0
+	used := make(map[byte]byte)
+	for _, tag := range tagx {
+		tg, _, mask, end := deconstruct(tag)
+		if end == 1 {
+			ends++
+			continue
+		}
+		if used[byte(ends)]&mask != 0 {
+			c.errorf(i+c.base, "%s TAGX: mask of tag %d overlaps other tags", name, tg)
+		}
+		used[byte(ends)] |= mask
+	}
+
+	if ends != cbs {
+		c.errorf(i+c.base, "%s TAGX has %d end tags but %d control bytes", name, ends, cbs)
+	}
+
+	ix := &index{tagx: tagx}
+
+	for k := 1; k <= int(h.IndexRecordCount); k++ {
+
+		if !c.has(i+k, name+" index record count") {
+			return nil
+		}
+
+		data := c.record(i + k)
+
+		if !c.checkIDXT(i+k, data, name) {
+			return nil
+		}
+
+		entries, err := r.ParseIndexEntries(data, tagx, cbs)
+		if err != nil {
+			c.errorf(i+k+c.base, "%s index entries: %v", name, err)
+			return nil
+		}
+
+		ix.entries = append(ix.entries, entries...)
+	}
+
+	if len(ix.entries) != int(h.IndexEntryCount) {
+		c.errorf(i+c.base, "%s index has %d entries but the header says %d", name, len(ix.entries), h.IndexEntryCount)
+	}
+
+	var cncx [][]byte
+	for k := 0; k < int(h.CNCXCount); k++ {
+		n := i + int(h.IndexRecordCount) + 1 + k
+		if !c.has(n, name+" CNCX count") {
+			return nil
+		}
+		cncx = append(cncx, c.record(n))
+	}
+	ix.cncx = r.ParseCNCX(cncx)
+
+	nvals := make(map[byte]int)
+	for _, tag := range tagx {
+		tg, nv, _, end := deconstruct(tag)
+		if end == 0 {
+			nvals[tg] = int(nv)
+		}
+	}
+
+	for n, e := range ix.entries {
+		for _, tg := range required {
+			if _, ok := e.Tags[tg]; !ok {
+				c.errorf(i+c.base, "%s entry %d (%s) has no tag %d", name, n, e.Label, tg)
+			}
+		}
+		for tg, vals := range e.Tags {
+			if nv := nvals[tg]; nv > 0 && len(vals)%nv != 0 {
+				c.errorf(i+c.base, "%s entry %d (%s): tag %d has %d values, not a multiple of %d", name, n, e.Label, tg, len(vals), nv)
+			}
+		}
+	}
+
+	return ix
+}
+
+// checkIDXT checks the IDXT table of an INDX data record.
+func (c *checker) checkIDXT(i int, data []byte, name string) bool {
+
+	h, _, _, err := r.ParseIndexHeader(data)
+	if err != nil {
+		c.errorf(i+c.base, "%s index record: %v", name, err)
+		return false
+	}
+
+	start := int(h.IDXTStart)
+	n := int(h.IndexRecordCount)
+
+	if start+4+2*n > len(data) || string(data[start:start+4]) != "IDXT" {
+		c.errorf(i+c.base, "%s index record: no IDXT with %d offsets at %d", name, n, start)
+		return false
+	}
+
+	prev := t.INDXHeaderLength
+	for k := 0; k < n; k++ {
+		o := int(pdb.Endian.Uint16(data[start+4+2*k:]))
+		if o < prev || o >= start {
+			c.errorf(i+c.base, "%s index record: IDXT offset %d of entry %d out of order or range", name, o, k)
+			return false
+		}
+		prev = o
+	}
+
+	return true
+}
+
+func (c *checker) checkIndices() {
+
+	h := c.null.MOBIHeader
+
+	skel := c.readIndex(h.SkeletonIndex, "skeleton", 1, 6)
+	chunk := c.readIndex(h.ChunkIndex, "chunk", 2, 3, 4, 6)
+	ncx := c.readIndex(h.INDXRecordOffset, "NCX", 1, 2, 3, 4)
+	guide := c.readIndex(h.GuideIndex, "guide", 1, 6)
+
+	if skel == nil || chunk == nil {
+		c.errorf(c.base, "KF8 file without skeleton or chunk index")
+	} else {
+		c.checkSkeletons(skel, chunk)
+	}
+
+	if ncx == nil {
+		c.warnf(c.base, "no NCX index")
+	} else {
+		c.checkNCX(ncx)
+	}
+
+	if guide != nil {
+		c.checkGuide(guide, chunk)
+	}
+
+	if chunk != nil {
+		c.checkLinks(chunk)
+	}
+}
+
+var posLink = regexp.MustCompile(`kindle:pos:fid:([0-9A-V]{4}):off:([0-9A-V]{10})`)
+
+// checkLinks checks that the kindle:pos links of the text refer to
+// positions inside chunks.
+func (c *checker) checkLinks(chunk *index) {
+
+	for _, m := range posLink.FindAllSubmatch(c.text, -1) {
+
+		fid, _ := strconv.ParseInt(string(m[1]), 32, 32)
+		off, _ := strconv.ParseInt(string(m[2]), 32, 64)
+
+		if int(fid) >= len(chunk.entries) {
+			c.errorf(-1, "link %s refers to chunk %d but there are %d chunks", m[0], fid, len(chunk.entries))
+		} else if l := val(chunk.entries[fid], 6, 1); int(off) > l {
+			c.errorf(-1, "link %s refers to offset %d in chunk %d of length %d", m[0], off, fid, l)
+		}
+	}
+}
+
+// checkGuide checks that the guide entries are sorted by type and
+// refer to positions inside chunks.
+func (c *checker) checkGuide(guide, chunk *index) {
+
+	for n, e := range guide.entries {
+
+		if _, ok := guide.cncx[val(e, 1, 0)]; !ok {
+			c.errorf(-1, "guide entry %d: no CNCX string at %d", n, val(e, 1, 0))
+		}
+
+		if n > 0 && guide.entries[n-1].Label >= e.Label {
+			c.errorf(-1, "guide entry %d (%s) is not sorted after %s", n, e.Label, guide.entries[n-1].Label)
+		}
+
+		if chunk == nil {
+			continue
+		}
+
+		fid, off := val(e, 6, 0), val(e, 6, 1)
+
+		if fid < 0 || fid >= len(chunk.entries) {
+			c.errorf(-1, "guide entry %d (%s) refers to chunk %d but there are %d chunks", n, e.Label, fid, len(chunk.entries))
+		} else if l := val(chunk.entries[fid], 6, 1); off < 0 || off > l {
+			c.errorf(-1, "guide entry %d (%s) refers to offset %d in chunk %d of length %d", n, e.Label, off, fid, l)
+		}
+	}
+}
+
+var aidSelector = regexp.MustCompile(`@aid='([0-9A-V]+)'`)
+
+// checkSkeletons checks that skeletons and chunks cover the html flow
+// and that chunks are inserted into their skeletons.
+func (c *checker) checkSkeletons(skel, chunk *index) {
+
+	pos := 0
+	k := 0
+
+	for n, s := range skel.entries {
+
+		count, start, length := val(s, 1, 0), val(s, 6, 0), val(s, 6, 1)
+
+		if start != pos {
+			c.errorf(-1, "skeleton %d (%s) starts at %d, not at the end %d of the previous one", n, s.Label, start, pos)
+		}
+
+		pos = start + length
+
+		if k+count > len(chunk.entries) {
+			c.errorf(-1, "skeleton %d (%s) has %d chunks but only %d are left", n, s.Label, count, len(chunk.entries)-k)
+			return
+		}
+
+		for _, e := range chunk.entries[k : k+count] {
+
+			insert, err := strconv.Atoi(e.Label)
+			if err != nil {
+				c.errorf(-1, "chunk %d: label %q is not an insert position", k, e.Label)
+			}
+
+			clen := val(e, 6, 1)
+
+			if insert < start || insert > pos {
+				c.errorf(-1, "chunk %d is inserted at %d outside its skeleton %d at %d-%d", k, insert, n, start, pos)
+			}
+
+			sel, ok := chunk.cncx[val(e, 2, 0)]
+			if !ok {
+				c.errorf(-1, "chunk %d: no CNCX string at %d", k, val(e, 2, 0))
+			}
+
+			if m := aidSelector.FindStringSubmatch(sel); m != nil && start <= len(c.html) && insert <= len(c.html) &&
+				!bytes.Contains(c.html[start:insert], []byte(`aid="`+m[1]+`"`)) {
+				c.warnf(-1, "chunk %d: selector %s matches nothing in skeleton %d", k, sel, n)
+			}
+
+			pos += clen
+			k++
+		}
+	}
+
+	if k != len(chunk.entries) {
+		c.errorf(-1, "%d chunks do not belong to any skeleton", len(chunk.entries)-k)
+	}
+
+	if pos != len(c.html) {
+		c.errorf(-1, "skeletons and chunks end at %d but the html flow has length %d", pos, len(c.html))
+	}
+}
+
+func (c *checker) checkNCX(ncx *index) {
+
+	for n, e := range ncx.entries {
+
+		start, length, label, depth := val(e, 1, 0), val(e, 2, 0), val(e, 3, 0), val(e, 4, 0)
+
+		// entries are ordered by depth and then by position
+		if n > 0 {
+			prev := ncx.entries[n-1]
+			if pd := val(prev, 4, 0); pd > depth || (pd == depth && val(prev, 1, 0) > start) {
+				c.errorf(-1, "NCX entry %d (depth %d, offset %d) is out of order", n, depth, start)
+			}
+		}
+
+		if start+length > len(c.text) {
+			c.errorf(-1, "NCX entry %d ends at %d beyond the text of length %d", n, start+length, len(c.text))
+		}
+
+		if title, ok := ncx.cncx[label]; !ok {
+			c.errorf(-1, "NCX entry %d: no CNCX string at %d", n, label)
+		} else if title == "" {
+			c.warnf(-1, "NCX entry %d has no title", n)
+		}
+
+		if p := val(e, 21, 0); p >= 0 {
+			if p >= len(ncx.entries) {
+				c.errorf(-1, "NCX entry %d has parent %d but there are %d entries", n, p, len(ncx.entries))
+			} else if pd := val(ncx.entries[p], 4, 0); pd+1 != depth {
+				c.errorf(-1, "NCX entry %d has depth %d but its parent %d has depth %d", n, depth, p, pd)
+			}
+		} else if depth != 0 {
+			c.errorf(-1, "NCX entry %d has depth %d but no parent", n, depth)
+		}
+
+		first, last := val(e, 22, 0), val(e, 23, 0)
+
+		if (first < 0) != (last < 0) {
+			c.errorf(-1, "NCX entry %d has only one of first and last child", n)
+		} else if first >= 0 && (first > last || last >= len(ncx.entries) || first <= n) {
+			c.errorf(-1, "NCX entry %d has children %d-%d out of range", n, first, last)
+		} else {
+			for k := first; k >= 0 && k <= last; k++ {
+				if p := val(ncx.entries[k], 21, 0); p != n {
+					c.errorf(-1, "NCX entry %d is a child of entry %d but has parent %d", k, n, p)
+				}
+				if s := val(ncx.entries[k], 1, 0); s < start || s >= start+length {
+					c.errorf(-1, "NCX entry %d at %d is outside its parent %d", k, s, n)
+				}
+			}
+		}
+	}
+}
+
+var embed = regexp.MustCompile(`kindle:embed:([0-9A-V]{4})`)
+
+func (c *checker) checkImages() {
+
+	h := c.null.MOBIHeader
+
+	if h.FirstImageIndex == math.MaxUint32 {
+		if embed.Match(c.text) {
+			c.errorf(c.base, "text refers to images but there is no first image record")
+		}
+		return
+	}
+
+	first := int(h.FirstImageIndex)
+
+	if !c.has(first, "first image index") {
+		return
+	}
+
+	for i := first; i+c.base < len(c.db.Records); i++ {
+		data := c.record(i)
+		if !isImage(data) {
+			break
+		}
+		c.images++
+	}
+
+	if n, ok := c.null.EXTHSection.Int(t.EXTHKF8CountResources); ok && n != c.images {
+		c.errorf(c.base, "EXTH says there are %d resources but there are %d image records", n, c.images)
+	}
+
+	for _, m := range embed.FindAllSubmatch(c.text, -1) {
+		n, _ := strconv.ParseInt(string(m[1]), 32, 32)
+		if n < 1 || int(n) > c.images {
+			c.errorf(-1, "text refers to image %s (%d) but there are %d images", m[1], n, c.images)
+		}
+	}
+
+	for _, tp := range []t.EXTHEntryType{t.EXTHCoverOffset, t.EXTHThumbOffset} {
+		if n, ok := c.null.EXTHSection.Int(tp); ok && n >= c.images {
+			c.errorf(c.base, "EXTH %d refers to image %d but there are %d images", tp, n, c.images)
+		}
+	}
+
+	for _, uri := range c.null.EXTHSection.Strings(t.EXTHKF8CoverURI) {
+		m := embed.FindStringSubmatch(uri)
+		if m == nil {
+			c.errorf(c.base, "cover URI %q is not a kindle:embed link", uri)
+			continue
+		}
+		n, _ := strconv.ParseInt(m[1], 32, 32)
+		if n < 1 || int(n) > c.images {
+			c.errorf(c.base, "cover URI %s refers to image %d but there are %d images", uri, n, c.images)
+		}
+	}
+}
+
+// checkFixed checks the FLIS and FCIS records.
+func (c *checker) checkFixed() {
+
+	h := c.null.MOBIHeader
+
+	for _, x := range []struct {
+		name       string
+		idx, count uint32
+	}{
+		{"FLIS", h.FLISRecordNumber, h.FLISRecordCount},
+		{"FCIS", h.FCISRecordNumber, h.FCISRecordCount},
+	} {
+		if x.count == 0 {
+			continue
+		}
+		if !c.has(int(x.idx), x.name+" record number") {
+			continue
+		}
+		if !bytes.HasPrefix(c.record(int(x.idx)), []byte(x.name)) {
+			c.errorf(int(x.idx)+c.base, "not a %s record", x.name)
+		}
+	}
+
+	if h.FCISRecordCount > 0 {
+		fcis := c.record(int(h.FCISRecordNumber))
+		if len(fcis) >= 24 && pdb.Endian.Uint32(fcis[20:]) != c.null.PalmDocHeader.TextLength {
+			c.errorf(int(h.FCISRecordNumber)+c.base, "FCIS text length %d differs from the text length %d",
+				pdb.Endian.Uint32(fcis[20:]), c.null.PalmDocHeader.TextLength)
+		}
+	}
+}
+
+func isImage(data []byte) bool {
+
+	for _, magic := range []string{"\xff\xd8\xff", "\x89PNG", "GIF8"} {
+		if bytes.HasPrefix(data, []byte(magic)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func deconstruct(tag t.TAGXTag) (tg, nvals, mask, end byte) {
+
+	v := uint32(tag)
+
+	return byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)
+}
+
+// val returns the i-th value of tag in e, or -1 if there is none.
+func val(e r.IndexEntry, tag byte, i int) int {
+
+	if i >= len(e.Tags[tag]) {
+		return -1
+	}
+
+	return e.Tags[tag][i]
+}
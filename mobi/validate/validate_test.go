@@ -0,0 +1,148 @@
+package validate
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+
+	"github.com/adamay909/AozoraConvert/mobi"
+	"github.com/adamay909/AozoraConvert/mobi/pdb"
+	r "github.com/adamay909/AozoraConvert/mobi/records"
+	"golang.org/x/text/language"
+)
+
+func testBook() mobi.Book {
+
+	return mobi.Book{
+		Title:       "羅生門",
+		Authors:     []string{"芥川龍之介"},
+		Language:    language.Japanese,
+		Vertical:    true,
+		RightToLeft: true,
+		UniqueID:    1,
+		CSSFlows:    []string{"p { margin: 0; }"},
+		CoverImage:  image.NewGray(image.Rect(0, 0, 10, 10)),
+		Images:      []r.ImageRecord{{Img: image.NewGray(image.Rect(0, 0, 2, 2)), Ext: ".png"}},
+		Guide: []mobi.GuideEntry{
+			{Type: "text", Title: "本文", Chapter: 0, Chunk: 0},
+			{Type: "toc", Title: "目次", Chapter: 3, Chunk: 0},
+		},
+		Chapters: []mobi.Chapter{
+			{Title: "一", Chunks: mobi.Chunks(strings.Repeat("<p>或日の暮方の事である。</p>", 400))},
+			{Title: "一の一", Chunks: mobi.Chunks(`<p>下人が<a href="` + mobi.PosLink(3, 0) + `">雨やみ</a>を待っていた。</p>`), Depth: 1},
+			{Title: "一の二", Chunks: mobi.Chunks("<p>広い門の下には、この男のほかに誰もいない。</p>"), Depth: 1},
+			{Title: "二", Chunks: mobi.Chunks(`<p><img src="kindle:embed:0001?mime=image/png"/></p>`)},
+		},
+	}
+}
+
+func realize(t *testing.T, m mobi.Book) []byte {
+
+	db, err := m.Realize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := db.Write(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestCheckValid(t *testing.T) {
+
+	for _, joint := range []bool{false, true} {
+		for _, compress := range []bool{false, true} {
+
+			m := testBook()
+			m.Joint = joint
+			m.Compress = compress
+
+			ds, err := CheckBook(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, d := range ds {
+				t.Errorf("joint %v, compress %v: %v", joint, compress, d)
+			}
+		}
+	}
+}
+
+func TestCheckBroken(t *testing.T) {
+
+	tests := []struct {
+		name string
+		book func(m *mobi.Book)
+		data func(data []byte) []byte
+		want string
+	}{
+		{
+			name: "missing image",
+			book: func(m *mobi.Book) {
+				m.Chapters[3].Chunks[0].Body = `<img src="kindle:embed:0009?mime=image/png"/>`
+			},
+			want: "refers to image 0009 (9) but there are 2 images",
+		},
+		{
+			name: "broken link",
+			book: func(m *mobi.Book) {
+				m.Chapters[1].Chunks[0].Body = `<a href="` + mobi.PosLink(9, 0) + `">x</a>`
+			},
+			want: "refers to chunk 9 but there are 4 chunks",
+		},
+		{
+			name: "truncated",
+			data: func(data []byte) []byte {
+				return data[:len(data)/2]
+			},
+			want: "cannot read Palm database",
+		},
+		{
+			name: "FDST",
+			data: func(data []byte) []byte {
+				i := bytes.Index(data, []byte("FDST"))
+				pdb.Endian.PutUint32(data[i+16:], 10)
+				return data
+			},
+			want: "not at the end 10 of the previous flow",
+		},
+		{
+			name: "FCIS",
+			data: func(data []byte) []byte {
+				i := bytes.Index(data, []byte("FCIS"))
+				pdb.Endian.PutUint32(data[i+20:], 10)
+				return data
+			},
+			want: "FCIS text length 10",
+		},
+	}
+
+	for _, tc := range tests {
+
+		m := testBook()
+		if tc.book != nil {
+			tc.book(&m)
+		}
+
+		data := realize(t, m)
+		if tc.data != nil {
+			data = tc.data(data)
+		}
+
+		ds := Check(data)
+
+		found := false
+		for _, d := range ds {
+			found = found || strings.Contains(d.String(), tc.want)
+		}
+
+		if !found || !HasErrors(ds) {
+			t.Errorf("%s: want %q, got %v", tc.name, tc.want, ds)
+		}
+	}
+}